@@ -3,40 +3,34 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
-type APIError struct {
-	Msg    string
-	Status int
-}
-
-func (e *APIError) Error() string {
-	return e.Msg
-}
-
 func API_getMove(r *http.Request) ([]byte, *APIError) {
 	vars := mux.Vars(r)
 	gid := vars["gameId"]
 	g, ok := GAMES.Get(gid)
 	if !ok {
 		// 404 - Game not found or player is not a part of it.
-		return nil, &APIError{"game not found", http.StatusNotFound}
+		return nil, mkAPIError(http.StatusNotFound, ErrUnknownGame, "game not found").forGame(gid)
 	}
 	moveNumStr := vars["move_number"]
 	moveNumStr = strings.TrimSpace(moveNumStr)
 	moveNum, err := strconv.Atoi(moveNumStr)
 	if err != nil {
-		return nil, &APIError{"unable to parse move number", http.StatusBadRequest}
+		return nil, mkAPIError(http.StatusBadRequest, ErrInvalidParameter,
+			"unable to parse move number").forField("move_number").forGame(gid)
 	}
 
 	move, err := g.GetMove(moveNum)
 	if err != nil {
-		return nil, &APIError{err.Error(), http.StatusNotFound}
+		return nil, mkAPIError(http.StatusNotFound, ErrUnknownMove, err.Error()).forGame(gid).forMove(moveNum)
 	}
 
 	mr := mkMoveResponse(move)
@@ -46,7 +40,7 @@ func API_getMove(r *http.Request) ([]byte, *APIError) {
 	err = enc.Encode(mr)
 	if err != nil {
 		LOGGER.Println(fmt.Sprintf("Encode Error: %s", err))
-		return nil, &APIError{fmt.Sprintf("JSON Encode error"), http.StatusInternalServerError}
+		return nil, serverError()
 	}
 	return buf.Bytes(), nil
 }
@@ -56,8 +50,11 @@ func mkMoveResponse(move *Move) MoveResponse {
 		Type:   move.Type,
 		Player: move.player,
 	}
-	if move.Type == MoveMove {
-		mRes.Column = move.col
+	if move.Type != MoveQuit {
+		row, col := move.row, move.col
+		mRes.Row = &row
+		mRes.Column = &col
+		mRes.WinningLines = move.lines
 	}
 	return mRes
 }
@@ -79,12 +76,16 @@ func API_moveList(r *http.Request) ([]byte, *APIError) {
 	gid := vars["gameId"]
 	g, ok := GAMES.Get(gid)
 	if !ok {
-		return nil, &APIError{"game not found", http.StatusNotFound}
+		return nil, mkAPIError(http.StatusNotFound, ErrUnknownGame, "game not found").forGame(gid)
 	}
 
 	rangeReq, err := validateMoveList(r)
 	if err != nil {
-		return nil, &APIError{err.Error(), http.StatusBadRequest}
+		return nil, invalidRequest(ErrInvalidParameter, err).forGame(gid)
+	}
+	if rangeReq.Wait > 0 {
+		// An empty list is returned if nothing happens in time.
+		g.WaitForMoves(r.Context(), rangeReq.Start, rangeReq.Wait)
 	}
 	moves := g.GetMoves(rangeReq.Start, rangeReq.Until)
 
@@ -95,70 +96,366 @@ func API_moveList(r *http.Request) ([]byte, *APIError) {
 	err = enc.Encode(mRangeRes)
 	if err != nil {
 		LOGGER.Println(fmt.Sprintf("JSON Encode error: %s", err))
-		return nil, &APIError{"server error", http.StatusInternalServerError}
+		return nil, serverError()
 	}
 	return buf.Bytes(), nil
 }
 
+// authorizePlayer checks the request carries the token of the player it acts
+// for. Players not in the game are left for the game to reject.
+func authorizePlayer(r *http.Request, g *game, playerId string) *APIError {
+	if !g.hasSeat(playerId) {
+		return nil
+	}
+	token, ok := validateBearer(r)
+	if !ok {
+		return mkAPIError(http.StatusUnauthorized, ErrMissingToken,
+			"missing bearer token").forField("Authorization").forGame(g.id)
+	}
+	if !g.Authorize(playerId, token) {
+		return mkAPIError(http.StatusForbidden, ErrInvalidToken,
+			"invalid token for player").forField("Authorization").forGame(g.id)
+	}
+	return nil
+}
+
 func API_makeMove(r *http.Request) ([]byte, *APIError) {
 	vars := mux.Vars(r)
 	gid := vars["gameId"]
 	g, ok := GAMES.Get(gid)
 	if !ok {
-		return nil, &APIError{"unknown game", http.StatusNotFound}
+		return nil, mkAPIError(http.StatusNotFound, ErrUnknownGame, "unknown game").forGame(gid)
 	}
 
-	mr, APIerr := validateMakeMove(r)
+	APIerr := authorizePlayer(r, g, vars["playerId"])
 	if APIerr != nil {
 		return nil, APIerr
 	}
 
-	confirmation, status := g.Move(vars["playerId"], mr.Column)
+	mr, APIerr := validateMakeMove(r)
+	if APIerr != nil {
+		return nil, APIerr.forGame(gid)
+	}
 
-	var err error
-	switch status {
-	case MoveOK:
-		buf := new(bytes.Buffer)
-		enc := json.NewEncoder(buf)
-		err = enc.Encode(confirmation)
-		if err != nil {
-			LOGGER.Println(fmt.Sprintf("JSON Encode error: %s", err))
-			return nil, &APIError{"server error", http.StatusInternalServerError}
-		}
-		return buf.Bytes(), nil
+	row := -1
+	if mr.Row != nil {
+		row = *mr.Row
+	} else if g.rules.FreePlacement && mr.Type == MoveMove {
+		return nil, mkAPIError(http.StatusBadRequest, ErrInvalidParameter,
+			"row is required when coins are placed freely").forField("row").forGame(gid)
+	}
 
+	moveNum := g.MoveCount()
+	confirmation, status := g.Play(vars["playerId"], mr.Type, row, mr.Column)
+	if status != MoveOK {
+		return nil, moveRejection(g, vars["playerId"], row, mr.Column, status).forGame(gid).forMove(moveNum)
+	}
+
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	err := enc.Encode(confirmation)
+	if err != nil {
+		LOGGER.Println(fmt.Sprintf("JSON Encode error: %s", err))
+		return nil, serverError()
+	}
+	return buf.Bytes(), nil
+}
+
+// moveRejection explains why a move was not made.
+func moveRejection(g *game, playerId string, row, col int, status MoveStatus) *APIError {
+	switch status {
 	case MoveWrongGame:
-		return nil, &APIError{string(status), http.StatusBadRequest}
+		return mkAPIError(http.StatusBadRequest, ErrorCode(status),
+			fmt.Sprintf("%s is not playing this game", playerId))
 	case MoveBadRequest:
-		return nil, &APIError{string(status), http.StatusBadRequest}
+		return rejectedCell(g, row, col)
 	case MoveWrongTurn:
-		return nil, &APIError{string(status), http.StatusConflict}
+		return mkAPIError(http.StatusConflict, ErrorCode(status),
+			fmt.Sprintf("it is not %s's turn", playerId))
+	case MoveNotStarted:
+		return mkAPIError(http.StatusConflict, ErrorCode(status), "game is waiting for players")
+	case MoveOutOfTime:
+		return mkAPIError(http.StatusConflict, ErrorCode(status),
+			fmt.Sprintf("%s ran out of time", playerId))
+	case MoveCannotPop:
+		if !g.rules.PopOut {
+			return mkAPIError(http.StatusBadRequest, ErrorCode(status),
+				"this game is not played with pop out").forField("type")
+		}
+		return mkAPIError(http.StatusBadRequest, ErrorCode(status),
+			fmt.Sprintf("%s has no coin at the bottom of column %d", playerId, col)).forField("column")
 	default:
-		return nil, &APIError{string(status), http.StatusNotFound}
+		return mkAPIError(http.StatusNotFound, ErrorCode(status), string(status))
 	}
 }
 
-func API_quitGame(r *http.Request) int {
-	vars := mux.Vars(r)
-	g, ok := GAMES.Get(vars["gameId"])
-	if !ok {
-		return http.StatusNotFound
+// rejectedCell explains why a move in col, or at row, col with free
+// placement, was a bad request.
+func rejectedCell(g *game, row, col int) *APIError {
+	if g.GameStatus().Status == STATUS_DONE {
+		return mkAPIError(http.StatusBadRequest, ErrGameOver, "game is over")
+	}
+	columns := g.rules.Columns
+	rows := g.rules.Rows
+	msg := fmt.Sprintf("column %d is full", col)
+	if g.rules.FreePlacement {
+		msg = fmt.Sprintf("row %d of column %d is taken", row, col)
 	}
+	if col < 0 || col >= columns {
+		msg = fmt.Sprintf("column must be between 0 and %d, got %d", columns-1, col)
+	} else if g.rules.FreePlacement && (row < 0 || row >= rows) {
+		return mkAPIError(http.StatusBadRequest, ErrorCode(MoveBadRequest),
+			fmt.Sprintf("row must be between 0 and %d, got %d", rows-1, row)).forField("row")
+	}
+	return mkAPIError(http.StatusBadRequest, ErrorCode(MoveBadRequest), msg).forField("column")
+}
 
-	gameStatus := g.Quit(vars["playerId"])
-	switch gameStatus {
+// quitRejection explains why a player could not quit.
+func quitRejection(playerId string, status GameStatus) *APIError {
+	switch status {
 	case STATUS_INVALID_GAME:
-		return http.StatusNotFound
+		return mkAPIError(http.StatusNotFound, ErrNotAPlayer,
+			fmt.Sprintf("%s is not playing this game", playerId))
 	case STATUS_QUIT_LEFT_GAME:
 		// NOTE: only valid when more than 2 players.
-		return http.StatusNotFound
+		return mkAPIError(http.StatusNotFound, ErrAlreadyLeft,
+			fmt.Sprintf("%s has already left this game", playerId))
 	case STATUS_GAME_OVER:
-		return http.StatusGone
-	case STATUS_LEFT_GAME:
-		return http.StatusAccepted
+		return mkAPIError(http.StatusGone, ErrGameOver, "game is over")
+	case STATUS_WAITING:
+		return mkAPIError(http.StatusConflict, ErrorCode(MoveNotStarted), "game is waiting for players")
 	default:
-		return http.StatusNotFound
+		return mkAPIError(http.StatusNotFound, ErrorCode(status), string(status))
+	}
+}
+
+// API_quitGame removes a player from a game.
+func API_quitGame(r *http.Request) *APIError {
+	vars := mux.Vars(r)
+	gid := vars["gameId"]
+	playerId := vars["playerId"]
+	g, ok := GAMES.Get(gid)
+	if !ok {
+		return mkAPIError(http.StatusNotFound, ErrUnknownGame, "unknown game").forGame(gid)
+	}
+
+	APIerr := authorizePlayer(r, g, playerId)
+	if APIerr != nil {
+		return APIerr
+	}
+
+	gameStatus := g.Quit(playerId)
+	if gameStatus == STATUS_LEFT_GAME {
+		return nil
+	}
+	return quitRejection(playerId, gameStatus).forGame(gid)
+}
+
+// joinRejection explains why a player could not claim a seat.
+func joinRejection(playerId string, status JoinStatus) *APIError {
+	switch status {
+	case JoinFull:
+		return mkAPIError(http.StatusConflict, ErrorCode(status), "game has no open seats")
+	case JoinSeated:
+		return mkAPIError(http.StatusConflict, ErrorCode(status),
+			fmt.Sprintf("%s already has a seat in this game", playerId)).forField("player")
+	case JoinInviteOnly:
+		return mkAPIError(http.StatusForbidden, ErrorCode(status), "game is invite only")
+	default:
+		return mkAPIError(http.StatusNotFound, ErrorCode(status), string(status))
+	}
+}
+
+// joinGame seats a player in g, returning their seat token as when creating
+// a game.
+func joinGame(r *http.Request, g *game, invite string) ([]byte, *APIError) {
+	playerId, APIerr := validateJoinGame(r)
+	if APIerr != nil {
+		return nil, APIerr.forGame(g.id)
+	}
+	token, status := g.Join(playerId, invite)
+	if status != JoinOK {
+		return nil, joinRejection(playerId, status).forGame(g.id)
+	}
+	buf := new(bytes.Buffer)
+	err := json.NewEncoder(buf).Encode(&CreateGameResponse{
+		GameId: g.id,
+		Tokens: map[string]string{playerId: token},
+	})
+	if err != nil {
+		LOGGER.Println(fmt.Sprintf("error encoding JSON %s", err))
+		return nil, serverError()
+	}
+	return buf.Bytes(), nil
+}
+
+// API_joinGame claims an open seat of a public game.
+func API_joinGame(r *http.Request) ([]byte, *APIError) {
+	gid := mux.Vars(r)["gameId"]
+	g, ok := GAMES.Get(gid)
+	if !ok {
+		return nil, mkAPIError(http.StatusNotFound, ErrUnknownGame, "unknown game").forGame(gid)
+	}
+	return joinGame(r, g, "")
+}
+
+// API_acceptInvite claims the open seat an invite was made for.
+func API_acceptInvite(r *http.Request) ([]byte, *APIError) {
+	invite := mux.Vars(r)["invite"]
+	g, ok := GAMES.GetByInvite(invite)
+	if !ok {
+		return nil, mkAPIError(http.StatusNotFound, ErrorCode(JoinUnknownInvite), "invite not found")
+	}
+	return joinGame(r, g, invite)
+}
+
+// takebackRejection explains why a takeback could not be asked for or
+// answered.
+func takebackRejection(playerId string, status TakebackStatus) *APIError {
+	switch status {
+	case TakebackWrongGame:
+		return mkAPIError(http.StatusNotFound, ErrNotAPlayer,
+			fmt.Sprintf("%s is not playing this game", playerId))
+	case TakebackGameOver:
+		return mkAPIError(http.StatusGone, ErrGameOver, "game is over")
+	case TakebackNoMove:
+		return mkAPIError(http.StatusConflict, ErrorCode(status),
+			fmt.Sprintf("the last move is not %s's to take back", playerId))
+	case TakebackPending:
+		return mkAPIError(http.StatusConflict, ErrorCode(status), "a takeback is already pending")
+	case TakebackNone:
+		return mkAPIError(http.StatusConflict, ErrorCode(status), "no takeback is pending")
+	case TakebackOwn:
+		return mkAPIError(http.StatusConflict, ErrorCode(status),
+			fmt.Sprintf("%s cannot accept their own takeback", playerId))
+	default:
+		return mkAPIError(http.StatusNotFound, ErrorCode(status), string(status))
+	}
+}
+
+// API_takeback asks to take back the last move (POST), or accepts (PUT) or
+// declines (DELETE) the pending takeback.
+func API_takeback(r *http.Request) *APIError {
+	vars := mux.Vars(r)
+	gid := vars["gameId"]
+	playerId := vars["playerId"]
+	g, ok := GAMES.Get(gid)
+	if !ok {
+		return mkAPIError(http.StatusNotFound, ErrUnknownGame, "unknown game").forGame(gid)
+	}
+
+	APIerr := authorizePlayer(r, g, playerId)
+	if APIerr != nil {
+		return APIerr
+	}
+
+	var status TakebackStatus
+	switch r.Method {
+	case "POST":
+		status = g.RequestTakeback(playerId)
+	case "PUT":
+		status = g.AnswerTakeback(playerId, true)
+	default:
+		status = g.AnswerTakeback(playerId, false)
+	}
+	if status != TakebackOK {
+		return takebackRejection(playerId, status).forGame(gid)
+	}
+	return nil
+}
+
+// API_takebackList returns the moves taken back in a game.
+func API_takebackList(r *http.Request) ([]byte, *APIError) {
+	gid := mux.Vars(r)["gameId"]
+	g, ok := GAMES.Get(gid)
+	if !ok {
+		return nil, mkAPIError(http.StatusNotFound, ErrUnknownGame, "unknown game").forGame(gid)
+	}
+	buf := new(bytes.Buffer)
+	err := json.NewEncoder(buf).Encode(&TakebackList{g.Undone()})
+	if err != nil {
+		LOGGER.Println(fmt.Sprintf("error encoding JSON %s", err))
+		return nil, serverError()
+	}
+	return buf.Bytes(), nil
+}
+
+// API_exportGame returns the record of a game in the PGN-like notation.
+func API_exportGame(r *http.Request) ([]byte, *APIError) {
+	vars := mux.Vars(r)
+	g, ok := GAMES.Get(vars["gameId"])
+	if !ok {
+		return nil, mkAPIError(http.StatusNotFound, ErrUnknownGame, "unknown game").forGame(vars["gameId"])
 	}
+	return []byte(g.Notation()), nil
+}
+
+// API_importGame creates a game by replaying a record, returning the new game
+// id and seat tokens as when creating a game.
+func API_importGame(r *http.Request) ([]byte, *APIError) {
+	n, APIerr := validateImportGame(r)
+	if APIerr != nil {
+		return nil, APIerr
+	}
+	g := CreateGameWithRules(&n.Rules, n.Players...)
+	APIerr = replayRecord(g, n)
+	if APIerr != nil {
+		return nil, APIerr
+	}
+
+	buf := new(bytes.Buffer)
+	err := json.NewEncoder(buf).Encode(&CreateGameResponse{GameId: g.id, Tokens: g.Tokens()})
+	if err != nil {
+		LOGGER.Println(fmt.Sprintf("JSON Encode error: %s", err))
+		return nil, serverError()
+	}
+	GAMES.Add(g)
+	return buf.Bytes(), nil
+}
+
+// replayRecord makes the moves of a record in a new game, checking each is
+// legal and the game ends as the record says. Engine players only take over
+// once the game is added. Moves replayed are not counted in the metrics.
+func replayRecord(g *game, n *Notation) *APIError {
+	bots := g.bots
+	g.bots = map[string]BotLevel{}
+	defer func() {
+		g.bots = bots
+	}()
+
+	for i, m := range n.Moves {
+		var APIerr *APIError
+		moveStatus, quitStatus := g.replayNotation(m)
+		if m.Quit && quitStatus != STATUS_LEFT_GAME {
+			APIerr = quitRejection(m.Player, quitStatus)
+		} else if !m.Quit && moveStatus != MoveOK {
+			APIerr = moveRejection(g, m.Player, m.Row, m.Column, moveStatus)
+		}
+		if APIerr != nil {
+			// The record is at fault, not the state of a game on the server.
+			APIerr.Status = http.StatusBadRequest
+			return APIerr.forMove(i)
+		}
+	}
+
+	if result := g.result(); result != n.Result {
+		return mkAPIError(http.StatusBadRequest, ErrInvalidRecord,
+			fmt.Sprintf("result %s does not follow from the moves", n.Result)).
+			forField("Result").forMove(len(n.Moves))
+	}
+	return nil
+}
+
+// recordRejection is a 400 for a record that cannot be read, at the move
+// that could not be read if there is one.
+func recordRejection(err error) *APIError {
+	APIerr := mkAPIError(http.StatusBadRequest, ErrInvalidRecord, err.Error())
+	var re *RecordError
+	if errors.As(err, &re) && re.Move >= 0 {
+		APIerr.Msg = re.Msg
+		APIerr.forMove(re.Move)
+	}
+	return APIerr
 }
 
 func API_gameStatus(r *http.Request) ([]byte, *APIError) {
@@ -166,7 +463,7 @@ func API_gameStatus(r *http.Request) ([]byte, *APIError) {
 
 	g, ok := GAMES.Get(vars["gameId"])
 	if !ok {
-		return nil, &APIError{"unknown game", http.StatusNotFound}
+		return nil, mkAPIError(http.StatusNotFound, ErrUnknownGame, "unknown game").forGame(vars["gameId"])
 	}
 	status := g.GameStatus()
 
@@ -175,27 +472,182 @@ func API_gameStatus(r *http.Request) ([]byte, *APIError) {
 	err := enc.Encode(status)
 	if err != nil {
 		LOGGER.Println(fmt.Sprintf("error encoding JSON %s", err))
-		return nil, &APIError{"server error", http.StatusInternalServerError}
+		return nil, serverError()
 	}
 	return buf.Bytes(), nil
 }
 
-func API_getGameList() ([]byte, *APIError) {
-	glist := GAMES.GetGames()
+// API_getBoard returns the board of a game in the format the request asks for.
+func API_getBoard(r *http.Request) ([]byte, BoardFormat, *APIError) {
+	vars := mux.Vars(r)
+	g, ok := GAMES.Get(vars["gameId"])
+	if !ok {
+		return nil, "", mkAPIError(http.StatusNotFound, ErrUnknownGame, "unknown game").forGame(vars["gameId"])
+	}
+	format, err := validateBoardFormat(r)
+	if err != nil {
+		return nil, "", invalidRequest(ErrInvalidParameter, err).forGame(g.id)
+	}
+	board := g.Board()
 
+	switch format {
+	case BoardASCII:
+		return []byte(board.ASCII()), format, nil
+	case BoardPosition:
+		return []byte(board.Position() + "\n"), format, nil
+	case BoardSVG:
+		return []byte(board.SVG()), format, nil
+	}
 	buf := new(bytes.Buffer)
 	enc := json.NewEncoder(buf)
-	err := enc.Encode(&GameList{
-		Games: glist,
-	})
+	err = enc.Encode(board)
+	if err != nil {
+		LOGGER.Println(fmt.Sprintf("JSON Encode error: %s", err))
+		return nil, "", serverError()
+	}
+	return buf.Bytes(), format, nil
+}
+
+// API_getGameList returns a page of the games matching the filters of the
+// request.
+func API_getGameList(r *http.Request) ([]byte, *APIError) {
+	glr, err := validateGameList(r)
+	if err != nil {
+		return nil, invalidRequest(ErrInvalidParameter, err)
+	}
+	games, next := GAMES.List(glr)
+
+	gl := &GameList{Games: []string{}}
+	if glr.Summary {
+		gl.Summaries = []*GameSummary{}
+	}
+	for _, g := range games {
+		gl.Games = append(gl.Games, g.id)
+		if glr.Summary {
+			gl.Summaries = append(gl.Summaries, g.Summary())
+		}
+	}
+	if next != nil {
+		gl.Next = next.String()
+	}
+
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	err = enc.Encode(gl)
 	if err != nil {
 		LOGGER.Println(fmt.Sprintf("error encoding JSON %s", err))
-		return nil, &APIError{"server error", http.StatusInternalServerError}
+		return nil, serverError()
 	}
 	return buf.Bytes(), nil
 
 }
 
+// API_stats returns the JSON counts of games held, forfeited and evicted.
+func API_stats() ([]byte, *APIError) {
+	buf := new(bytes.Buffer)
+	err := json.NewEncoder(buf).Encode(GAMES.Stats())
+	if err != nil {
+		LOGGER.Println(fmt.Sprintf("error encoding JSON %s", err))
+		return nil, serverError()
+	}
+	return buf.Bytes(), nil
+}
+
+// API_getPlayer returns the rating and record of a player who has finished a
+// game.
+func API_getPlayer(r *http.Request) ([]byte, *APIError) {
+	playerId := mux.Vars(r)["playerId"]
+	pr, ok := GAMES.ratings.Get(playerId)
+	if !ok {
+		return nil, mkAPIError(http.StatusNotFound, ErrUnknownPlayer,
+			fmt.Sprintf("%s has not finished a game", playerId))
+	}
+	buf := new(bytes.Buffer)
+	err := json.NewEncoder(buf).Encode(pr)
+	if err != nil {
+		LOGGER.Println(fmt.Sprintf("error encoding JSON %s", err))
+		return nil, serverError()
+	}
+	return buf.Bytes(), nil
+}
+
+// API_joinMatchmaking queues a player for a game, returning their ticket and
+// if they were matched straight away.
+func API_joinMatchmaking(r *http.Request) ([]byte, bool, *APIError) {
+	player, rules, APIerr := validateMatchRequest(r)
+	if APIerr != nil {
+		return nil, false, APIerr
+	}
+	ticket, ok := MATCHMAKER.Enqueue(player, rules, time.Now())
+	if !ok {
+		return nil, false, mkAPIError(http.StatusConflict, ErrAlreadyWaiting,
+			fmt.Sprintf("%s is already waiting for a game", player)).forField("player")
+	}
+	buf := new(bytes.Buffer)
+	err := json.NewEncoder(buf).Encode(ticket)
+	if err != nil {
+		LOGGER.Println(fmt.Sprintf("error encoding JSON %s", err))
+		return nil, false, serverError()
+	}
+	return buf.Bytes(), ticket.Status == TicketMatched, nil
+}
+
+// API_getTicket returns a matchmaking ticket, waiting for it to be matched if
+// asked to.
+func API_getTicket(r *http.Request) ([]byte, *APIError) {
+	ticketId := mux.Vars(r)["ticketId"]
+	wait, err := validateTicketWait(r)
+	if err != nil {
+		return nil, invalidRequest(ErrInvalidParameter, err)
+	}
+	ticket, ok := MATCHMAKER.Ticket(r.Context(), ticketId, wait)
+	if !ok {
+		return nil, mkAPIError(http.StatusNotFound, ErrUnknownTicket, "ticket not found")
+	}
+	buf := new(bytes.Buffer)
+	err = json.NewEncoder(buf).Encode(ticket)
+	if err != nil {
+		LOGGER.Println(fmt.Sprintf("error encoding JSON %s", err))
+		return nil, serverError()
+	}
+	return buf.Bytes(), nil
+}
+
+// API_leaveMatchmaking gives up a matchmaking ticket.
+func API_leaveMatchmaking(r *http.Request) *APIError {
+	if !MATCHMAKER.Leave(mux.Vars(r)["ticketId"]) {
+		return mkAPIError(http.StatusNotFound, ErrUnknownTicket, "ticket not found")
+	}
+	return nil
+}
+
+// API_leaderboard returns a page of players from the highest rated down.
+func API_leaderboard(r *http.Request) ([]byte, *APIError) {
+	lr, err := validateLeaderboard(r)
+	if err != nil {
+		return nil, invalidRequest(ErrInvalidParameter, err)
+	}
+	resp := &LeaderboardResponse{Players: []*LeaderboardEntry{}}
+	for i, pr := range GAMES.ratings.Leaderboard() {
+		if lr.After != nil && !lr.After.Before(pr) {
+			continue
+		}
+		if len(resp.Players) == lr.Limit {
+			resp.Next = ratingCursor(resp.Players[lr.Limit-1].PlayerRating)
+			break
+		}
+		resp.Players = append(resp.Players, &LeaderboardEntry{i + 1, pr})
+	}
+
+	buf := new(bytes.Buffer)
+	err = json.NewEncoder(buf).Encode(resp)
+	if err != nil {
+		LOGGER.Println(fmt.Sprintf("error encoding JSON %s", err))
+		return nil, serverError()
+	}
+	return buf.Bytes(), nil
+}
+
 // API_createGame validates a request to create a game and returns the JSON response or
 // an error on failure.
 func API_createGame(r *http.Request) ([]byte, *APIError) {
@@ -204,14 +656,15 @@ func API_createGame(r *http.Request) ([]byte, *APIError) {
 		return nil, APIerr
 	}
 
-	game := CreateGame(*CONSECUTIVE_LENGTH, cgr.Rows, cgr.Columns, cgr.Players...)
+	game := CreateGameWithRules(cgr.Rules(), cgr.Players...)
+	game.public = cgr.Public
 
 	buf := new(bytes.Buffer)
 	enc := json.NewEncoder(buf)
-	err := enc.Encode(&CreateGameResponse{game.id})
+	err := enc.Encode(&CreateGameResponse{game.id, game.Tokens(), game.Invites()})
 	if err != nil {
 		LOGGER.Println(fmt.Sprintf("JSON Encode error: %s", err))
-		return nil, &APIError{"server error", http.StatusInternalServerError}
+		return nil, serverError()
 	}
 	GAMES.Add(game)
 	return buf.Bytes(), nil
@@ -0,0 +1,130 @@
+package main
+
+// LineFinder holds a player's coins and finds lines through them.
+type LineFinder interface {
+	Add(row, col int)
+	Remove(row, col int)
+	Get(row, col int) bool
+	FindConsecutive(row, col, num int) bool
+
+	// Edges of the board the coins are on.
+	Edges() Edges
+}
+
+// BitBoard holds a player's coins as one bit per cell, row by row. Each row
+// is followed by an always empty padding bit so that shifting a line off
+// the end of a row lands on the padding instead of the next row.
+type BitBoard struct {
+	rows   int
+	cols   int
+	stride int
+	bits   []uint64
+}
+
+func NewBitBoard(rows, cols int) *BitBoard {
+	stride := cols + 1
+	return &BitBoard{
+		rows:   rows,
+		cols:   cols,
+		stride: stride,
+		bits:   make([]uint64, (rows*stride+63)/64),
+	}
+}
+
+func (bb *BitBoard) index(row, col int) (int, bool) {
+	if row < 0 || row >= bb.rows || col < 0 || col >= bb.cols {
+		return 0, false
+	}
+	return row*bb.stride + col, true
+}
+
+func (bb *BitBoard) Add(row, col int) {
+	if i, ok := bb.index(row, col); ok {
+		bb.bits[i/64] |= 1 << uint(i%64)
+	}
+}
+
+func (bb *BitBoard) Remove(row, col int) {
+	if i, ok := bb.index(row, col); ok {
+		bb.bits[i/64] &^= 1 << uint(i%64)
+	}
+}
+
+// Edges are never joined on a BitBoard, lines stop at its padding.
+func (bb *BitBoard) Edges() Edges {
+	return Edges{Rows: bb.rows, Cols: bb.cols}
+}
+
+func (bb *BitBoard) Get(row, col int) bool {
+	i, ok := bb.index(row, col)
+	return ok && bitSet(bb.bits, i)
+}
+
+func bitSet(bits []uint64, i int) bool {
+	return i >= 0 && i/64 < len(bits) && bits[i/64]&(1<<uint(i%64)) != 0
+}
+
+// shiftRight sets dst to src shifted n bits towards bit 0.
+func shiftRight(dst, src []uint64, n int) {
+	words := n / 64
+	shift := uint(n % 64)
+	for i := range dst {
+		var w uint64
+		if i+words < len(src) {
+			w = src[i+words] >> shift
+		}
+		if shift > 0 && i+words+1 < len(src) {
+			w |= src[i+words+1] << (64 - shift)
+		}
+		dst[i] = w
+	}
+}
+
+// lineShifts are the bit distances between neighbouring cells of each Line:
+// LeftRight, UpDown, DiagonalLR_UD and DiagonalLR_DU.
+func (bb *BitBoard) lineShifts() [4]int {
+	return [4]int{1, bb.stride, bb.stride + 1, bb.stride - 1}
+}
+
+// runStarts returns a bit set of the cells that begin num coins in a row
+// with the given distance between cells, by and-ing the board with itself
+// shifted num-1 times.
+func runStarts(bits []uint64, shift, num int) []uint64 {
+	starts := append([]uint64{}, bits...)
+	shifted := make([]uint64, len(bits))
+	for k := 1; k < num; k++ {
+		shiftRight(shifted, bits, k*shift)
+		for i := range starts {
+			starts[i] &= shifted[i]
+		}
+	}
+	return starts
+}
+
+// FindConsecutive checks for a line of at least num coins through row, col
+// in any direction. As with PlayerGraph.FindConsecutive the cell itself
+// counts as a coin even if it has not been added.
+func (bb *BitBoard) FindConsecutive(row, col, num int) bool {
+	cell, ok := bb.index(row, col)
+	if !ok {
+		return false
+	}
+	if num <= 1 {
+		return true
+	}
+	bits := bb.bits
+	if !bitSet(bits, cell) {
+		bits = append([]uint64{}, bits...)
+		bits[cell/64] |= 1 << uint(cell%64)
+	}
+	for _, shift := range bb.lineShifts() {
+		starts := runStarts(bits, shift, num)
+		// Any run containing the cell starts at most num-1 cells before it.
+		for k := 0; k < num; k++ {
+			if bitSet(starts, cell-k*shift) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type BoardFormat string
+
+var BoardJSON = BoardFormat("json")
+var BoardASCII = BoardFormat("ascii")
+var BoardPosition = BoardFormat("position")
+var BoardSVG = BoardFormat("svg")
+
+var BOARD_CONTENT_TYPES = map[BoardFormat]string{
+	BoardJSON:     "application/json",
+	BoardASCII:    "text/plain; charset=utf-8",
+	BoardPosition: "text/plain; charset=utf-8",
+	BoardSVG:      "image/svg+xml",
+}
+
+// BoardResponse is the grid of a game, row 0 being the top row.
+type BoardResponse struct {
+	Rows    int        `json:"rows"`
+	Columns int        `json:"columns"`
+	Grid    [][]string `json:"grid"`
+
+	// Players in seat order and the symbol used for each in the ASCII and
+	// position renderings.
+	Players []string          `json:"players"`
+	Symbols map[string]string `json:"symbols"`
+
+	// Coins in each column, and the columns a coin can be dropped in, left
+	// out when coins are placed freely. In Pop Out games also the columns the
+	// next player can pop a coin out of.
+	Heights  []int `json:"heights,omitempty"`
+	Playable []int `json:"playable,omitempty"`
+	Poppable []int `json:"poppable,omitempty"`
+
+	// Player to move, empty when the game is over.
+	Next string `json:"next,omitempty"`
+}
+
+// seatSymbol is the letter standing for the player in the given seat.
+func seatSymbol(seat int) string {
+	return string(rune('A' + seat))
+}
+
+func (g *game) Board() *BoardResponse {
+	g.RLock()
+	defer g.RUnlock()
+	br := &BoardResponse{
+		Rows:    len(g.board),
+		Columns: len(g.board[0]),
+		Grid:    [][]string{},
+		Players: append([]string{}, g.playerList...),
+		Symbols: map[string]string{},
+	}
+	for seat, player := range g.playerList {
+		br.Symbols[player] = seatSymbol(seat)
+	}
+	for _, row := range g.board {
+		br.Grid = append(br.Grid, append([]string{}, row...))
+	}
+	if !g.over {
+		br.Next = g.nextMove()
+	}
+	if g.rules.FreePlacement {
+		return br
+	}
+	for col := 0; col < br.Columns; col++ {
+		height := 0
+		for row := br.Rows - 1; row >= 0 && g.board[row][col] != ""; row-- {
+			height++
+		}
+		br.Heights = append(br.Heights, height)
+		if !g.over && height < br.Rows {
+			br.Playable = append(br.Playable, col)
+		}
+		if !g.over && g.rules.PopOut && g.board[br.Rows-1][col] == br.Next {
+			br.Poppable = append(br.Poppable, col)
+		}
+	}
+	return br
+}
+
+// ASCII draws the board with a player's symbol or . in each cell and the
+// column numbers underneath.
+func (br *BoardResponse) ASCII() string {
+	width := len(strconv.Itoa(br.Columns - 1))
+	cell := fmt.Sprintf(" %%%ds", width)
+
+	buf := new(bytes.Buffer)
+	for _, row := range br.Grid {
+		buf.WriteString("|")
+		for _, spot := range row {
+			symbol := "."
+			if spot != "" {
+				symbol = br.Symbols[spot]
+			}
+			fmt.Fprintf(buf, cell, symbol)
+		}
+		buf.WriteString(" |\n")
+	}
+	buf.WriteString("+" + strings.Repeat("-", br.Columns*(width+1)+1) + "+\n")
+	buf.WriteString(" ")
+	for col := 0; col < br.Columns; col++ {
+		fmt.Fprintf(buf, cell, strconv.Itoa(col))
+	}
+	buf.WriteString("\n")
+	for _, player := range br.Players {
+		fmt.Fprintf(buf, "%s=%s\n", br.Symbols[player], player)
+	}
+	return buf.String()
+}
+
+// Position is a compact string of the board: rows from the top separated by
+// /, each a run of player symbols with empty cells counted as a number, then
+// the symbol of the player to move or - when the game is over, eg.
+// "4/4/4/1AB1 A".
+func (br *BoardResponse) Position() string {
+	rows := []string{}
+	for _, row := range br.Grid {
+		s := ""
+		empty := 0
+		for _, spot := range row {
+			if spot == "" {
+				empty++
+				continue
+			}
+			if empty > 0 {
+				s += strconv.Itoa(empty)
+				empty = 0
+			}
+			s += br.Symbols[spot]
+		}
+		if empty > 0 {
+			s += strconv.Itoa(empty)
+		}
+		rows = append(rows, s)
+	}
+	next := "-"
+	if br.Next != "" {
+		next = br.Symbols[br.Next]
+	}
+	return strings.Join(rows, "/") + " " + next
+}
+
+var SEAT_COLORS = []string{"#d62828", "#fcbf49", "#2a9d8f", "#7b2cbf", "#f77f00", "#4361ee"}
+
+const svgCell = 40
+
+// SVG draws the board as coins in a grid.
+func (br *BoardResponse) SVG() string {
+	buf := new(bytes.Buffer)
+	width := br.Columns * svgCell
+	height := br.Rows * svgCell
+	fmt.Fprintf(buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`,
+		width, height, width, height)
+	fmt.Fprintf(buf, `<rect width="%d" height="%d" fill="#1d3557"/>`, width, height)
+	seats := map[string]int{}
+	for seat, player := range br.Players {
+		seats[player] = seat
+	}
+	for row, cells := range br.Grid {
+		for col, spot := range cells {
+			fill := "#f1faee"
+			if spot != "" {
+				fill = SEAT_COLORS[seats[spot]%len(SEAT_COLORS)]
+			}
+			fmt.Fprintf(buf, `<circle cx="%d" cy="%d" r="%d" fill="%s"/>`,
+				col*svgCell+svgCell/2, row*svgCell+svgCell/2, svgCell*2/5, fill)
+		}
+	}
+	buf.WriteString("</svg>\n")
+	return buf.String()
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func boardGame() *game {
+	/*
+
+	   _ _ _ _
+	   _ _ _ _
+	   _ b _ _
+	   a a b _
+
+	*/
+	g := CreateGame(4, 4, 4, "a", "b")
+	g.Move("a", 0)
+	g.Move("b", 2)
+	g.Move("a", 1)
+	g.Move("b", 1)
+	return g
+}
+
+func Test_Board(t *testing.T) {
+	br := boardGame().Board()
+	if br.Grid[3][0] != "a" || br.Grid[2][1] != "b" || br.Grid[0][0] != "" {
+		t.Error("expected the grid to match the board")
+	}
+	for i, h := range []int{1, 2, 1, 0} {
+		if br.Heights[i] != h {
+			t.Error("expected column ", i, " height ", h, " got ", br.Heights[i])
+		}
+	}
+	if len(br.Playable) != 4 || br.Next != "a" {
+		t.Error("expected every column playable by a")
+	}
+
+	g := CreateGame(4, 4, 4, "a", "b")
+	mkDraw(g, "a", "b")
+	br = g.Board()
+	if len(br.Playable) != 0 || br.Next != "" {
+		t.Error("expected nothing playable once the game is over")
+	}
+
+	// A full Pop Out board can still be played by popping.
+	g = CreateGameWithRules(&Rules{Rows: 3, Columns: 3, WinLength: 3, Players: 2, PopOut: true}, "a", "b")
+	g.board = [][]string{{"a", "b", "a"}, {"a", "b", "a"}, {"b", "a", "b"}}
+	br = g.Board()
+	if len(br.Playable) != 0 || len(br.Poppable) != 1 || br.Poppable[0] != 1 {
+		t.Error("expected only column 1 to be poppable got ", br.Playable, br.Poppable)
+	}
+
+	// Nor do columns fill up when coins are placed freely.
+	g = CreateGameWithRules(&Rules{Rows: 3, Columns: 3, WinLength: 3, Players: 2, FreePlacement: true}, "a", "b")
+	g.Place("a", 0, 0)
+	br = g.Board()
+	if br.Heights != nil || br.Playable != nil || br.Next != "b" {
+		t.Error("expected no heights or playable columns got ", br.Heights, br.Playable)
+	}
+}
+
+func Test_BoardRenderings(t *testing.T) {
+	br := boardGame().Board()
+
+	expected := "| . . . . |\n" +
+		"| . . . . |\n" +
+		"| . B . . |\n" +
+		"| A A B . |\n" +
+		"+---------+\n" +
+		"  0 1 2 3\n" +
+		"A=a\n" +
+		"B=b\n"
+	if br.ASCII() != expected {
+		t.Errorf("expected\n%s got\n%s", expected, br.ASCII())
+	}
+
+	if br.Position() != "4/4/1B2/AAB1 A" {
+		t.Error("expected 4/4/1B2/AAB1 A got", br.Position())
+	}
+
+	svg := br.SVG()
+	if !strings.HasPrefix(svg, "<svg ") || strings.Count(svg, "<circle ") != 16 {
+		t.Error("expected an svg of 16 cells got", svg)
+	}
+	if strings.Count(svg, SEAT_COLORS[0]) != 2 || strings.Count(svg, SEAT_COLORS[1]) != 2 {
+		t.Error("expected two coins of each player")
+	}
+
+	// Column numbers stay aligned past 9.
+	g := CreateGame(4, 3, 11, "a", "b")
+	g.Move("a", 10)
+	ascii := g.Board().ASCII()
+	if !strings.Contains(ascii, "|  .  .  .  .  .  .  .  .  .  .  A |\n") ||
+		!strings.Contains(ascii, "   0  1  2  3  4  5  6  7  8  9 10\n") {
+		t.Error("expected aligned columns got\n", ascii)
+	}
+	if g.Board().Position() != "11/11/10A B" {
+		t.Error("expected 11/11/10A B got", g.Board().Position())
+	}
+}
+
+func Test_boardHandler(t *testing.T) {
+	g := boardGame()
+	GAMES.Add(g)
+
+	for _, test := range []struct {
+		format      string
+		accept      string
+		contentType string
+		body        string
+	}{
+		{"", "", "application/json", `"grid":[["","","",""],["","","",""],["","b","",""],["a","a","b",""]]`},
+		{"", "text/html, image/svg+xml;q=0.9", "image/svg+xml", "<svg "},
+		{"", "text/plain", "text/plain; charset=utf-8", "| A A B . |"},
+		{"position", "image/svg+xml", "text/plain; charset=utf-8", "4/4/1B2/AAB1 A"},
+		{"json", "text/plain", "application/json", `"heights":[1,2,1,0],"playable":[0,1,2,3],"next":"a"`},
+	} {
+		url := apiURL("cats/board")
+		if test.format != "" {
+			url += "?format=" + test.format
+		}
+		r := httptest.NewRequest("GET", url, nil)
+		r.Header.Set("Accept", test.accept)
+		r = mux.SetURLVars(r, map[string]string{"gameId": "cats"})
+		w := httptest.NewRecorder()
+		boardHandler(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Error("expected 200 got", w.Code)
+		}
+		if w.Header().Get("Content-Type") != test.contentType {
+			t.Error("expected ", test.contentType, " got ", w.Header().Get("Content-Type"))
+		}
+		if !strings.Contains(w.Body.String(), test.body) {
+			t.Error("expected ", test.body, " in ", w.Body.String())
+		}
+	}
+
+	r := httptest.NewRequest("GET", apiURL("cats/board?format=png"), nil)
+	r = mux.SetURLVars(r, map[string]string{"gameId": "cats"})
+	w := httptest.NewRecorder()
+	boardHandler(w, r)
+	err := expectWithWriter(w, http.StatusBadRequest, `{"error":{"code":"INVALID_PARAMETER","message":"unknown format png","field":"format","gameId":"cats"}}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	r = httptest.NewRequest("GET", apiURL("dogs/board"), nil)
+	r = mux.SetURLVars(r, map[string]string{"gameId": "dogs"})
+	w = httptest.NewRecorder()
+	boardHandler(w, r)
+	err = expectWithWriter(w, http.StatusNotFound, `{"error":{"code":"UNKNOWN_GAME","message":"unknown game","gameId":"dogs"}}`)
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BotLevel is the difficulty of an engine player.
+type BotLevel string
+
+var BotEasy = BotLevel("easy")
+var BotMedium = BotLevel("medium")
+var BotHard = BotLevel("hard")
+
+// Players named with this prefix are engine players, eg. bot:hard. A suffix
+// after a second colon tells apart several bots of the same level,
+// eg. bot:easy:2.
+const botPrefix = "bot:"
+
+// How many moves ahead each level searches.
+var BOT_DEPTH = map[BotLevel]int{
+	BotEasy:   0,
+	BotMedium: 2,
+	BotHard:   6,
+}
+
+const botWin = 1000000
+
+// botLine is what a line already owned scores in scoring games.
+const botLine = 1000
+
+// isBot returns if a player id names an engine player, valid level or not.
+func isBot(playerId string) bool {
+	return strings.HasPrefix(playerId, botPrefix)
+}
+
+// parseBot returns the level of an engine player id.
+func parseBot(playerId string) (BotLevel, bool) {
+	if !isBot(playerId) {
+		return "", false
+	}
+	level := BotLevel(strings.SplitN(strings.TrimPrefix(playerId, botPrefix), ":", 2)[0])
+	_, ok := BOT_DEPTH[level]
+	return level, ok
+}
+
+// playBots makes the moves of engine players until it is a person's turn or
+// the game is over. Nobody moves while there are open seats. Engine players
+// only pop out, at random, when the board is full.
+func (g *game) playBots() {
+	for !g.over && g.openSeats() == 0 {
+		player := g.nextMove()
+		level, ok := g.bots[player]
+		if !ok {
+			return
+		}
+		var status MoveStatus
+		switch {
+		case g.boardIsFull():
+			col, ok := g.botPop(player)
+			if !ok {
+				// Nothing to pop, which ends the game before it gets here.
+				return
+			}
+			status = g.makePop(player, col)
+		case g.rules.FreePlacement:
+			cell := g.botMove(player, level)
+			status = g.placeMove(player, cell.Row, cell.Col)
+		default:
+			status = g.makeMove(player, g.botMove(player, level).Col)
+		}
+		MOVES.Inc(string(status))
+	}
+}
+
+// botPop chooses a column an engine player has a coin at the bottom of, and
+// false if there is none.
+func (g *game) botPop(player string) (int, bool) {
+	cols := []int{}
+	for col, spot := range g.board[len(g.board)-1] {
+		if spot == player {
+			cols = append(cols, col)
+		}
+	}
+	if len(cols) == 0 {
+		return 0, false
+	}
+	return cols[rand.Intn(len(cols))], true
+}
+
+// botMove chooses the cell an engine player's coin goes to, which is where
+// it lands in the column it drops into unless coins are placed freely.
+func (g *game) botMove(player string, level BotLevel) Cell {
+	s := &botSearch{
+		board:   [][]string{},
+		win:     g.sequentialWin,
+		free:    g.rules.FreePlacement,
+		edges:   g.rules.edges(),
+		goal:    g.rules.WinCondition,
+		players: []string{},
+	}
+	// Coins are marked by the side they count for, so teammates play
+	// together.
+	s.me = g.side(player)
+	for _, row := range g.board {
+		sides := make([]string, len(row))
+		for col, spot := range row {
+			if spot != "" {
+				sides[col] = g.side(spot)
+			}
+		}
+		s.board = append(s.board, sides)
+	}
+	// Turn order of the sides starting with this player's.
+	playing := g.currentlyPlaying()
+	seen := map[string]bool{}
+	for i, p := range playing {
+		if p == player {
+			for j := range playing {
+				side := g.side(playing[(i+j)%len(playing)])
+				if !seen[side] {
+					seen[side] = true
+					s.players = append(s.players, side)
+				}
+			}
+			break
+		}
+	}
+
+	cells := s.legalMoves()
+	depth := BOT_DEPTH[level]
+	if depth == 0 {
+		return cells[rand.Intn(len(cells))]
+	}
+	// Deeper searches are made while there is time, which bounds how long
+	// the game waits on large boards where every position costs more.
+	s.deadline = time.Now().Add(*BOT_THINK_TIME)
+	best := cells[0]
+	for d := 1; d <= depth; d++ {
+		cell, complete := s.bestMove(d)
+		if !complete {
+			break
+		}
+		best = cell
+	}
+	return best
+}
+
+// botSearch is a minimax search over a copy of the board. With more than two
+// players or teams every opponent is assumed to play against the engine
+// player. In misère games completing a line loses instead, and in scoring
+// games it only adds to the evaluation.
+type botSearch struct {
+	board   [][]string
+	win     int
+	free    bool
+	edges   Edges
+	goal    WinCondition
+	me      string
+	players []string
+
+	// When a search deeper than one move gives up, discarding its result.
+	deadline time.Time
+}
+
+// legalMoves returns the cells a coin may go to, from the center outwards
+// since central cells take part in more lines. Coins dropped land on the
+// lowest empty row of each column with room. Coins placed freely are only
+// tried next to another coin, or in the center of an empty board, to keep
+// the search narrow on large boards.
+func (s *botSearch) legalMoves() []Cell {
+	cells := []Cell{}
+	height := len(s.board)
+	width := len(s.board[0])
+	for col := 0; col < width; col++ {
+		if !s.free {
+			if s.board[0][col] == "" {
+				cells = append(cells, Cell{s.landing(col), col})
+			}
+			continue
+		}
+		for row := 0; row < height; row++ {
+			if s.board[row][col] == "" && s.nextToCoin(row, col) {
+				cells = append(cells, Cell{row, col})
+			}
+		}
+	}
+	if s.free && len(cells) == 0 && s.board[height/2][width/2] == "" {
+		cells = append(cells, Cell{height / 2, width / 2})
+	}
+	// Distance from the center, doubled to stay whole.
+	fromCenter := func(c Cell) int {
+		d := 2*c.Col - (width - 1)
+		if d < 0 {
+			d = -d
+		}
+		if !s.free {
+			return d
+		}
+		r := 2*c.Row - (height - 1)
+		if r < 0 {
+			r = -r
+		}
+		return d + r
+	}
+	sort.SliceStable(cells, func(i, j int) bool {
+		return fromCenter(cells[i]) < fromCenter(cells[j])
+	})
+	return cells
+}
+
+// landing returns the row a coin dropped in col lands on.
+func (s *botSearch) landing(col int) int {
+	row := len(s.board) - 1
+	for row > 0 && s.board[row][col] != "" {
+		row--
+	}
+	return row
+}
+
+// nextToCoin returns if any of the cells around row, col holds a coin.
+func (s *botSearch) nextToCoin(row, col int) bool {
+	for r := row - 1; r <= row+1; r++ {
+		for c := col - 1; c <= col+1; c++ {
+			if r >= 0 && r < len(s.board) && c >= 0 && c < len(s.board[0]) && s.board[r][c] != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// step returns the cell dRow, dCol on from row, col, across the edges that
+// are joined, and false if it is off the board.
+func (s *botSearch) step(row, col, dRow, dCol int) (int, int, bool) {
+	row += dRow
+	col += dCol
+	rows := len(s.board)
+	cols := len(s.board[0])
+	if s.edges.Topology == TopologyCylinder || s.edges.Topology == TopologyTorus {
+		col = (col + cols) % cols
+	}
+	if s.edges.Topology == TopologyTorus {
+		row = (row + rows) % rows
+	}
+	return row, col, row >= 0 && row < rows && col >= 0 && col < cols
+}
+
+// ring returns how many cells a line in direction dRow, dCol passes before it
+// comes back around to where it started, or 0 if it runs off an edge.
+func (s *botSearch) ring(dRow, dCol int) int {
+	rows := len(s.board)
+	cols := len(s.board[0])
+	wrapsCols := s.edges.Topology == TopologyCylinder || s.edges.Topology == TopologyTorus
+	wrapsRows := s.edges.Topology == TopologyTorus
+	switch {
+	case (dRow != 0 && !wrapsRows) || (dCol != 0 && !wrapsCols):
+		return 0
+	case dRow == 0:
+		return cols
+	case dCol == 0:
+		return rows
+	}
+	// Diagonals around a torus come back once they have crossed both
+	// sides a whole number of times.
+	a, b := rows, cols
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return rows / a * cols
+}
+
+// count returns how many of the player's coins continue from row, col,
+// stopping if the line comes back around to row, col.
+func (s *botSearch) count(row, col, dRow, dCol int, player string) int {
+	n := 0
+	r, c := row, col
+	for {
+		var ok bool
+		r, c, ok = s.step(r, c, dRow, dCol)
+		if !ok || (r == row && c == col) || s.board[r][c] != player {
+			return n
+		}
+		n++
+	}
+}
+
+// wins returns if the coin at row, col completes a line.
+func (s *botSearch) wins(row, col int) bool {
+	player := s.board[row][col]
+	for _, d := range [][2]int{{1, 0}, {0, 1}, {1, 1}, {1, -1}} {
+		n := 1 + s.count(row, col, d[0], d[1], player) + s.count(row, col, -d[0], -d[1], player)
+		// Coins all the way around a ring are counted from both sides.
+		if ring := s.ring(d[0], d[1]); ring > 0 && n > ring {
+			n = ring
+		}
+		if n >= s.win {
+			return true
+		}
+	}
+	return false
+}
+
+// ends returns if the coin at row, col completes a line that ends the game
+// for its player.
+func (s *botSearch) ends(row, col int) bool {
+	return s.goal != WinScoring && s.wins(row, col)
+}
+
+// evaluate scores the board for the engine player by the lines each player
+// could still complete, weighted by how many coins they already have in them.
+// Coins towards a line are a liability in misère games.
+func (s *botSearch) evaluate() int {
+	score := 0
+	rows := len(s.board)
+	cols := len(s.board[0])
+	spots := make([]string, 0, s.win)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			for _, d := range [][2]int{{1, 0}, {0, 1}, {1, 1}, {1, -1}} {
+				if ring := s.ring(d[0], d[1]); ring > 0 && s.win > ring {
+					continue
+				}
+				spots = append(spots[:0], s.board[row][col])
+				r, c, ok := row, col, true
+				for len(spots) < s.win && ok {
+					r, c, ok = s.step(r, c, d[0], d[1])
+					if ok {
+						spots = append(spots, s.board[r][c])
+					}
+				}
+				if !ok {
+					continue
+				}
+				owner := ""
+				n := 0
+				for _, spot := range spots {
+					if spot == "" {
+						continue
+					}
+					if owner != "" && spot != owner {
+						owner = ""
+						n = 0
+						break
+					}
+					owner = spot
+					n++
+				}
+				weight := n * n
+				if n == s.win {
+					weight = botLine
+				}
+				if owner == s.me {
+					score += weight
+				} else if owner != "" {
+					score -= weight
+				}
+			}
+		}
+	}
+	if s.goal == WinMisere {
+		return -score
+	}
+	return score
+}
+
+// outOfTime returns if the search has run past its deadline.
+func (s *botSearch) outOfTime() bool {
+	return time.Now().After(s.deadline)
+}
+
+// bestMove searches depth moves ahead and returns the best cell and if the
+// search finished in time. Looking one move ahead always finishes, so wins
+// and blocks are never missed.
+func (s *botSearch) bestMove(depth int) (Cell, bool) {
+	cells := s.legalMoves()
+	best := cells[0]
+	alpha := -botWin * 2
+	for _, c := range cells {
+		if depth > 1 && s.outOfTime() {
+			return best, false
+		}
+		s.board[c.Row][c.Col] = s.me
+		var score int
+		switch {
+		case !s.ends(c.Row, c.Col):
+			score = s.minimax(1, depth-1, alpha, botWin*2)
+		case s.goal == WinMisere:
+			score = -botWin - depth
+		default:
+			score = botWin + depth
+		}
+		s.board[c.Row][c.Col] = ""
+		if score > alpha {
+			alpha = score
+			best = c
+		}
+	}
+	return best, depth == 1 || !s.outOfTime()
+}
+
+func (s *botSearch) minimax(turn, depth, alpha, beta int) int {
+	if depth == 0 || s.outOfTime() {
+		return s.evaluate()
+	}
+	cells := s.legalMoves()
+	if len(cells) == 0 {
+		// A full board is a draw, unless the lines on it are scored.
+		if s.goal == WinScoring {
+			return s.evaluate()
+		}
+		return 0
+	}
+	player := s.players[turn%len(s.players)]
+	maximize := player == s.me
+	best := botWin * 2
+	if maximize {
+		best = -best
+	}
+	for _, c := range cells {
+		s.board[c.Row][c.Col] = player
+		var score int
+		switch {
+		case !s.ends(c.Row, c.Col):
+			score = s.minimax(turn+1, depth-1, alpha, beta)
+		case maximize != (s.goal == WinMisere):
+			// Sooner wins score higher, later losses lower.
+			score = botWin + depth
+		default:
+			score = -botWin - depth
+		}
+		s.board[c.Row][c.Col] = ""
+
+		if maximize && score > best {
+			best = score
+			if best > alpha {
+				alpha = best
+			}
+		}
+		if !maximize && score < best {
+			best = score
+			if best < beta {
+				beta = best
+			}
+		}
+		if alpha >= beta {
+			break
+		}
+	}
+	return best
+}
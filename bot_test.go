@@ -0,0 +1,258 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_parseBot(t *testing.T) {
+	level, ok := parseBot("bot:hard")
+	if !ok || level != BotHard {
+		t.Error("expected hard bot got", level)
+	}
+	level, ok = parseBot("bot:easy:2")
+	if !ok || level != BotEasy {
+		t.Error("expected easy bot got", level)
+	}
+	if _, ok = parseBot("bot:genius"); ok {
+		t.Error("expected unknown level")
+	}
+	if _, ok = parseBot("robot"); ok {
+		t.Error("expected a person")
+	}
+}
+
+func Test_botTakesWin(t *testing.T) {
+	for _, level := range []BotLevel{BotMedium, BotHard} {
+		/*
+
+		   _ _ _ _ _ _
+		   b _ _ _ _ _
+		   b _ _ _ _ _
+		   b a a a _ a
+
+		*/
+		bot := "bot:" + string(level)
+		g := CreateGame(4, 4, 6, "a", bot)
+		for _, col := range []int{5, 1, 2, 3} {
+			g.makeMove("a", col)
+		}
+		for i := 0; i < 3; i++ {
+			g.makeMove(bot, 0)
+		}
+
+		// Winning beats blocking column 4.
+		col := g.botMove(bot, level).Col
+		if col != 0 {
+			t.Error(level, " expected winning column 0 got ", col)
+		}
+	}
+}
+
+func Test_botBlocks(t *testing.T) {
+	for _, level := range []BotLevel{BotMedium, BotHard} {
+		bot := "bot:" + string(level)
+		g := CreateGame(4, 6, 7, "a", bot)
+		g.makeMove("a", 0)
+		g.makeMove(bot, 6)
+		g.makeMove("a", 1)
+		g.makeMove(bot, 6)
+		g.makeMove("a", 2)
+		col := g.botMove(bot, level).Col
+		if col != 3 {
+			t.Error(level, " expected to block at column 3 got ", col)
+		}
+	}
+}
+
+func Test_botWrapsAround(t *testing.T) {
+	for _, level := range []BotLevel{BotMedium, BotHard} {
+		/*
+
+		   _ _ _ _ _ _
+		   _ _ _ a _ _
+		   _ _ _ a _ _
+		   b b _ a _ b
+
+		*/
+		bot := "bot:" + string(level)
+		g := CreateGameWithRules(&Rules{Rows: 4, Columns: 6, WinLength: 4, Players: 2, Topology: TopologyCylinder}, "a", bot)
+		for _, col := range []int{3, 3, 3} {
+			g.makeMove("a", col)
+		}
+		for _, col := range []int{0, 1, 5} {
+			g.makeMove(bot, col)
+		}
+
+		// Winning across the edge beats blocking column 3.
+		if col := g.botMove(bot, level).Col; col != 4 && col != 2 {
+			t.Error(level, " expected a winning column got ", col)
+		}
+		if s := (&botSearch{board: g.board, edges: Edges{Rows: 4, Cols: 6, Topology: TopologyTorus}}); s.ring(1, 1) != 12 || s.ring(0, 1) != 6 {
+			t.Error("expected diagonals around a 4x6 torus to pass 12 cells")
+		}
+	}
+}
+
+func Test_botTeams(t *testing.T) {
+	for _, level := range []BotLevel{BotMedium, BotHard} {
+		/*
+
+		   _ _ _ _ _ _ _
+		   _ _ _ _ _ _ _
+		   _ _ _ _ _ _ _
+		   _ _ _ _ _ _ a
+		   _ _ _ _ _ _ a
+		   B d B _ _ _ a
+
+		*/
+		bot := "bot:" + string(level)
+		g := CreateGameWithRules(&Rules{Rows: 6, Columns: 7, WinLength: 4, Players: 4, Teams: 2}, "a", bot, "c", "d")
+		g.makeMove(bot, 0)
+		g.makeMove("d", 1)
+		g.makeMove(bot, 2)
+		for i := 0; i < 3; i++ {
+			g.makeMove("a", 6)
+		}
+
+		// Finishing the line with its teammate beats blocking a.
+		if col := g.botMove(bot, level).Col; col != 3 {
+			t.Error(level, " expected 3 got ", col)
+		}
+	}
+}
+
+func Test_botWinConditions(t *testing.T) {
+	for _, level := range []BotLevel{BotMedium, BotHard} {
+		/*
+
+		   _ _ _ _ _ _ _
+		   _ _ _ _ _ _ _
+		   _ _ _ _ _ _ _
+		   B _ _ _ _ _ _
+		   B _ _ _ _ _ _
+		   B _ _ _ _ _ a
+
+		*/
+		bot := "bot:" + string(level)
+		for goal, expected := range map[WinCondition]bool{WinMisere: false, WinScoring: true} {
+			g := CreateGameWithRules(&Rules{Rows: 6, Columns: 7, WinLength: 4, Players: 2, WinCondition: goal}, "a", bot)
+			for i := 0; i < 3; i++ {
+				g.makeMove(bot, 0)
+			}
+			g.makeMove("a", 6)
+
+			if col := g.botMove(bot, level).Col; (col == 0) != expected {
+				t.Error(level, " ", goal, " unexpected column ", col)
+			}
+		}
+	}
+}
+
+func Test_botThinkTime(t *testing.T) {
+	old := *BOT_THINK_TIME
+	defer func() { *BOT_THINK_TIME = old }()
+	*BOT_THINK_TIME = 100 * time.Millisecond
+
+	for _, free := range []bool{false, true} {
+		bot := "bot:hard"
+		g := CreateGameWithRules(&Rules{Rows: 20, Columns: 20, WinLength: 5, Players: 2, FreePlacement: free}, bot, "a")
+		for col := 0; col < 20; col += 3 {
+			g.addCoin(bot, 19, col)
+			g.addCoin("a", 18, col)
+		}
+		start := time.Now()
+		g.botMove(bot, BotHard)
+		if took := time.Since(start); took > time.Second {
+			t.Error("free placement ", free, " expected a move within the think time got ", took)
+		}
+	}
+}
+
+func Test_botFreePlacement(t *testing.T) {
+	for _, level := range []BotLevel{BotEasy, BotMedium, BotHard} {
+		bot := "bot:" + string(level)
+		g := CreateGameWithRules(&Rules{Rows: 15, Columns: 15, WinLength: 5, Players: 2, FreePlacement: true}, bot, "a")
+		g.playBots()
+		if g.board[7][7] != bot {
+			t.Fatal(level, " expected the first coin in the center")
+		}
+		if level == BotEasy {
+			continue
+		}
+		// Four in a column with one end open.
+		g.placeMove("a", 3, 2)
+		g.placeMove(bot, 2, 2)
+		for row := 4; row < 7; row++ {
+			g.placeMove("a", row, 2)
+		}
+		if cell := g.botMove(bot, level); cell != (Cell{7, 2}) {
+			t.Error(level, " expected to block at the open end got ", cell)
+		}
+	}
+}
+
+func Test_botPlaysInTurn(t *testing.T) {
+	gc, _ := NewGamesContainer(&memoryStore{})
+
+	// A bot with the first move plays once the game is added.
+	g := CreateGame(4, 4, 4, "bot:easy", "a")
+	gc.Add(g)
+	if len(g.moves) != 1 || g.moves[0].player != "bot:easy" {
+		t.Fatal("expected the bot to have moved first")
+	}
+
+	// Bots answer a move before it returns.
+	g = CreateGame(4, 4, 4, "a", "bot:easy", "bot:hard")
+	gc.Add(g)
+	confirmation, status := g.Move("a", 0)
+	if status != MoveOK || confirmation.Move != g.id+"/moves/0" {
+		t.Error("expected a confirmation of move 0 got", status)
+	}
+	if len(g.moves) != 3 || g.nextMove() != "a" {
+		t.Error("expected both bots to have moved got", len(g.moves), "moves")
+	}
+
+	// And a quit.
+	g.Quit("bot:easy")
+	g.Move("a", 0)
+	for !g.over {
+		col := 0
+		for g.board[0][col] != "" {
+			col++
+		}
+		g.Move("a", col)
+	}
+	for i, m := range g.moves {
+		if m.Type == MoveMove && i > 0 && g.moves[i-1].player == m.player {
+			t.Error("expected players to alternate at move", i)
+		}
+	}
+
+	// Bots are not left to play it out once nobody else is.
+	g = CreateGame(4, 20, 20, "a", "bot:easy", "bot:hard")
+	gc.Add(g)
+	g.Move("a", 0)
+	moves := len(g.moves)
+	g.Quit("a")
+	if !g.over || g.Winner() != "" || len(g.moves) != moves+1 {
+		t.Error("expected the quit to draw the game got", g.Winner(), len(g.moves)-moves, "moves")
+	}
+}
+
+func Test_botMovesAreStored(t *testing.T) {
+	dir := t.TempDir()
+	gc := openTestStore(t, dir)
+	g := CreateGame(4, 4, 4, "a", "bot:easy")
+	g.id = "bots"
+	gc.Add(g)
+	g.Move("a", 0)
+	g.Move("a", 1)
+	gc.store.Close()
+
+	// Random moves are replayed exactly as they were played.
+	gc = openTestStore(t, dir)
+	got, _ := gc.Get("bots")
+	expectSameGame(t, got, g)
+	gc.store.Close()
+}
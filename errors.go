@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrorCode is the machine readable reason a request failed. Rejected moves
+// use their MoveStatus, eg. WRONG_TURN.
+type ErrorCode string
+
+var ErrNotFound = ErrorCode("NOT_FOUND")
+var ErrMethodNotAllowed = ErrorCode("METHOD_NOT_ALLOWED")
+var ErrUnknownGame = ErrorCode("UNKNOWN_GAME")
+var ErrUnknownMove = ErrorCode("UNKNOWN_MOVE")
+var ErrUnknownPlayer = ErrorCode("UNKNOWN_PLAYER")
+var ErrUnknownTicket = ErrorCode("UNKNOWN_TICKET")
+var ErrMalformedInput = ErrorCode("MALFORMED_INPUT")
+var ErrInvalidParameter = ErrorCode("INVALID_PARAMETER")
+var ErrInvalidPlayers = ErrorCode("INVALID_PLAYERS")
+var ErrInvalidRules = ErrorCode("INVALID_RULES")
+var ErrMissingToken = ErrorCode("MISSING_TOKEN")
+var ErrInvalidToken = ErrorCode("INVALID_TOKEN")
+var ErrNotAPlayer = ErrorCode("NOT_A_PLAYER")
+var ErrAlreadyLeft = ErrorCode("ALREADY_LEFT")
+var ErrGameOver = ErrorCode("GAME_OVER")
+var ErrInvalidRecord = ErrorCode("INVALID_RECORD")
+var ErrAlreadyWaiting = ErrorCode("ALREADY_WAITING")
+var ErrServer = ErrorCode("SERVER_ERROR")
+
+// APIError is a failed request, sent to the client as
+// {"error": {"code": ..., "message": ..., "field": ..., "gameId": ..., "move": ...}}.
+type APIError struct {
+	Code ErrorCode `json:"code"`
+	Msg  string    `json:"message"`
+
+	// Request field at fault, if any.
+	Field string `json:"field,omitempty"`
+
+	// Game and move number the error is about, if any.
+	GameId string `json:"gameId,omitempty"`
+	Move   *int   `json:"move,omitempty"`
+
+	Status int `json:"-"`
+}
+
+func (e *APIError) Error() string {
+	return e.Msg
+}
+
+func mkAPIError(status int, code ErrorCode, msg string) *APIError {
+	return &APIError{Code: code, Msg: msg, Status: status}
+}
+
+func (e *APIError) forField(field string) *APIError {
+	e.Field = field
+	return e
+}
+
+func (e *APIError) forGame(gameId string) *APIError {
+	e.GameId = gameId
+	return e
+}
+
+func (e *APIError) forMove(num int) *APIError {
+	e.Move = &num
+	return e
+}
+
+func serverError() *APIError {
+	return mkAPIError(http.StatusInternalServerError, ErrServer, "server error")
+}
+
+// FieldError is a validation error of a single request field.
+type FieldError struct {
+	Field string
+	Msg   string
+}
+
+func (fe *FieldError) Error() string {
+	return fe.Msg
+}
+
+func fieldError(field, format string, a ...interface{}) *FieldError {
+	return &FieldError{Field: field, Msg: fmt.Sprintf(format, a...)}
+}
+
+// invalidRequest is a 400 with the field of err if it is a FieldError.
+func invalidRequest(code ErrorCode, err error) *APIError {
+	APIerr := mkAPIError(http.StatusBadRequest, code, err.Error())
+	var fe *FieldError
+	if errors.As(err, &fe) {
+		APIerr.Field = fe.Field
+	}
+	return APIerr
+}
+
+type ErrorResponse struct {
+	Error *APIError `json:"error"`
+}
+
+// writeError sends the JSON error envelope.
+func writeError(w http.ResponseWriter, e *APIError) {
+	content, err := json.Marshal(&ErrorResponse{e})
+	if err != nil {
+		content = []byte(`{"error":{"code":"SERVER_ERROR","message":"server error"}}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.WriteHeader(e.Status)
+	w.Write(append(content, '\n'))
+}
+
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, mkAPIError(http.StatusNotFound, ErrNotFound, "no such resource"))
+}
+
+func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(w, mkAPIError(http.StatusMethodNotAllowed, ErrMethodNotAllowed,
+		fmt.Sprintf("method %s not allowed", r.Method)))
+}
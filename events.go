@@ -0,0 +1,121 @@
+package main
+
+import (
+	"sync"
+)
+
+type EventType string
+
+var EventMove = EventType("MOVE")
+var EventQuit = EventType("QUIT")
+var EventPop = EventType("POP")
+var EventGameOver = EventType("GAME_OVER")
+var EventGameCreated = EventType("GAME_CREATED")
+var EventPlayerJoined = EventType("PLAYER_JOINED")
+var EventGameStarted = EventType("GAME_STARTED")
+var EventTakebackRequested = EventType("TAKEBACK_REQUESTED")
+var EventTakebackDeclined = EventType("TAKEBACK_DECLINED")
+var EventTakeback = EventType("TAKEBACK")
+
+// GameEvent describes a change to a game as it is applied.
+type GameEvent struct {
+	// Sequential id of this event in the stream it was published to. Ids
+	// start again when the server restarts, since a game's events are
+	// rebuilt from its stored moves and takeback requests are not stored,
+	// so clients reconnecting after a restart should ask for move numbers.
+	Id     int       `json:"id"`
+	Type   EventType `json:"type"`
+	GameId string    `json:"gameId"`
+
+	// Move number the event was caused by.
+	Move   int    `json:"move"`
+	Player string `json:"player,omitempty"`
+	Column *int   `json:"column,omitempty"`
+	Row    *int   `json:"row,omitempty"`
+
+	// Set on GAME_OVER, an empty winner is a draw.
+	Winner       string         `json:"winner,omitempty"`
+	Draw         bool           `json:"draw,omitempty"`
+	WinningLines []*WinningLine `json:"winningLines,omitempty"`
+
+	// Set on GAME_CREATED and GAME_STARTED.
+	Players []string `json:"players,omitempty"`
+}
+
+// subscriberBuffer is how many events a subscriber may fall behind before it
+// is dropped.
+const subscriberBuffer = 64
+
+// eventHub keeps published events and fans new ones out to subscribers.
+type eventHub struct {
+	sync.Mutex
+
+	events      []*GameEvent
+	subscribers map[chan *GameEvent]bool
+
+	// Id of the next event published.
+	next int
+
+	// Most events kept for replay, 0 keeps everything.
+	limit int
+}
+
+func newEventHub(limit int) *eventHub {
+	return &eventHub{
+		events:      []*GameEvent{},
+		subscribers: map[chan *GameEvent]bool{},
+		limit:       limit,
+	}
+}
+
+// publish numbers the event and sends it to every subscriber. A subscriber
+// whose buffer is full has its channel closed rather than blocking the game.
+func (h *eventHub) publish(e *GameEvent) {
+	h.Lock()
+	defer h.Unlock()
+	e.Id = h.next
+	h.next++
+	h.events = append(h.events, e)
+	if h.limit > 0 && len(h.events) > h.limit {
+		h.events = h.events[len(h.events)-h.limit:]
+	}
+	for ch := range h.subscribers {
+		select {
+		case ch <- e:
+		default:
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// published returns how many events have been published, the id of the next.
+func (h *eventHub) published() int {
+	h.Lock()
+	defer h.Unlock()
+	return h.next
+}
+
+// subscribe returns the past events matching include and a channel of new
+// events. The returned func must be called to stop receiving events.
+func (h *eventHub) subscribe(include func(*GameEvent) bool) ([]*GameEvent, chan *GameEvent, func()) {
+	h.Lock()
+	defer h.Unlock()
+	backlog := []*GameEvent{}
+	for _, e := range h.events {
+		if include(e) {
+			backlog = append(backlog, e)
+		}
+	}
+	ch := make(chan *GameEvent, subscriberBuffer)
+	h.subscribers[ch] = true
+	unsubscribe := func() {
+		h.Lock()
+		defer h.Unlock()
+		if h.subscribers[ch] {
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+	return backlog, ch, unsubscribe
+}
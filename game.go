@@ -1,9 +1,16 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/gofrs/uuid"
 )
@@ -12,6 +19,7 @@ type GameStatus string
 
 var STATUS_DONE = GameStatus("DONE")
 var STATUS_IN_PROGRESS = GameStatus("IN_PROGRESS")
+var STATUS_WAITING = GameStatus("WAITING")
 var STATUS_INVALID_GAME = GameStatus("INVALID_GAME")
 var STATUS_GAME_OVER = GameStatus("GAME_OVER")
 var STATUS_QUIT_LEFT_GAME = GameStatus("QUIT_LEFT_GAME")
@@ -23,11 +31,24 @@ var MoveOK = MoveStatus("OK")
 var MoveBadRequest = MoveStatus("BAD_REQUEST")
 var MoveWrongGame = MoveStatus("WRONG_GAME")
 var MoveWrongTurn = MoveStatus("WRONG_TURN")
+var MoveNotStarted = MoveStatus("NOT_STARTED")
+var MoveOutOfTime = MoveStatus("OUT_OF_TIME")
+var MoveCannotPop = MoveStatus("CANNOT_POP")
+
+// JoinStatus is the outcome of claiming an open seat.
+type JoinStatus string
+
+var JoinOK = JoinStatus("OK")
+var JoinFull = JoinStatus("GAME_FULL")
+var JoinSeated = JoinStatus("ALREADY_SEATED")
+var JoinInviteOnly = JoinStatus("INVITE_ONLY")
+var JoinUnknownInvite = JoinStatus("UNKNOWN_INVITE")
 
 type MoveType string
 
 var MoveMove = MoveType("MOVE")
 var MoveQuit = MoveType("QUIT")
+var MovePop = MoveType("POP")
 
 type Move struct {
 	player string
@@ -35,6 +56,16 @@ type Move struct {
 	col    int
 
 	Type MoveType
+
+	// When the move was made.
+	at time.Time
+
+	// Lines completed by this move.
+	lines []*WinningLine
+
+	// Takebacks made before this move. Takebacks reuse move numbers, so
+	// this tells apart stored moves of the same number.
+	undone int
 }
 
 type MoveConfirmation struct {
@@ -52,11 +83,33 @@ var mkGameId = func() string {
 	return fmt.Sprintf("%v", u)
 }
 
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic("unable to read random bytes: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
+
+// mkToken returns a secret a player proves they hold a seat with.
+var mkToken = func() string {
+	return randomHex(32)
+}
+
+// mkInvite returns a single-use secret that claims an open seat.
+var mkInvite = func() string {
+	return randomHex(16)
+}
+
 type game struct {
 	sync.RWMutex
 
 	id string
 
+	// Rules this game was created with.
+	rules Rules
+
 	board [][]string
 
 	// Players and status regarding if they are still playing this game.
@@ -67,9 +120,15 @@ type game struct {
 	// Sequential list of moves
 	moves []*Move
 
+	// Pending request to take back the last move, if any.
+	takeback *takebackRequest
+
+	// Moves taken back, oldest first.
+	undone []*UndoneMove
+
 	// Location of player coins on the board.
-	// playerId to PlayerGraph
-	playerGraphs map[string]*PlayerGraph
+	// playerId to LineFinder, shared by teammates.
+	playerGraphs map[string]LineFinder
 
 	// If this game is over.
 	over bool
@@ -77,7 +136,285 @@ type game struct {
 	// Player id of the winner.
 	winner string
 
+	// Sides knocked out of a misère game, in the order they were, each as
+	// the players on it still playing at the time.
+	knockedOut [][]string
+
+	// Lines completed by the winning move.
+	winningLines []*WinningLine
+
 	sequentialWin int
+
+	// Engine players by player id.
+	bots map[string]BotLevel
+
+	// Secret token of each seat held by a person.
+	tokens map[string]string
+
+	// Players handed their token themselves, by joining or being matched,
+	// rather than named by whoever created the game. Only they are rated.
+	rated map[string]bool
+
+	// Unused invites to the open seats.
+	invites map[string]bool
+
+	// If anyone may claim an open seat without an invite.
+	public bool
+
+	// Where moves are recorded, set once the game is added to a GamesContainer.
+	store Store
+
+	// Events published as moves are applied.
+	hub *eventHub
+
+	// Events of every game, set once the game is added to a GamesContainer.
+	lobby *eventHub
+
+	// Updated when the game finishes, set once the game is added to a
+	// GamesContainer so imported and recovered games are not rated again.
+	ratings *Ratings
+
+	// Broadcast whenever a move is applied.
+	changed *sync.Cond
+
+	created time.Time
+
+	// When the game was created or last had a move applied.
+	lastActivity time.Time
+
+	// Time on each player's clock when their turn started, for games with
+	// ClockSeconds.
+	clocks map[string]time.Duration
+
+	// When the player on turn started their turn.
+	turnStarted time.Time
+
+	// Forfeits the player on turn when their time runs out. Only armed once
+	// the game is added to a GamesContainer.
+	flag         *time.Timer
+	clockRunning bool
+
+	// Returns the current time, which is the time a stored move was made
+	// while it is replayed.
+	now func() time.Time
+}
+
+// moveApplied records and publishes the last move made and wakes any
+// requests waiting for it.
+func (g *game) moveApplied() {
+	g.moves[len(g.moves)-1].undone = len(g.undone)
+	g.lastActivity = g.now()
+	// Moving on declines a pending takeback.
+	g.dropTakeback()
+	g.storeMove()
+	g.publishMove()
+	g.armFlag()
+	g.changed.Broadcast()
+}
+
+// timeLeft returns how long a player has to move, counting down from the
+// start of their turn if it is theirs.
+func (g *game) timeLeft(playerId string, now time.Time) time.Duration {
+	left := time.Duration(g.rules.MoveSeconds) * time.Second
+	if g.rules.ClockSeconds > 0 {
+		left = g.clocks[playerId]
+	}
+	if !g.over && g.openSeats() == 0 && g.nextMove() == playerId {
+		left -= now.Sub(g.turnStarted)
+	}
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
+// chargeClock takes the time a player spent on their move off their clock,
+// adds the increment and starts the next turn.
+func (g *game) chargeClock(playerId string, now time.Time) {
+	if g.rules.ClockSeconds > 0 {
+		g.clocks[playerId] += time.Duration(g.rules.IncrementSeconds)*time.Second - now.Sub(g.turnStarted)
+	}
+	g.turnStarted = now
+}
+
+// armFlag sets the timer that forfeits the player on turn once their time
+// runs out, replacing any set for a previous turn.
+func (g *game) armFlag() {
+	if g.flag != nil {
+		g.flag.Stop()
+		g.flag = nil
+	}
+	if !g.clockRunning || !g.rules.timed() || g.over || g.openSeats() > 0 {
+		return
+	}
+	g.flag = time.AfterFunc(g.timeLeft(g.nextMove(), g.now()), func() {
+		g.ForfeitFlag()
+	})
+}
+
+// ForfeitFlag quits the player on turn if their time has run out, returning
+// who was forfeited.
+func (g *game) ForfeitFlag() (string, bool) {
+	g.Lock()
+	defer g.Unlock()
+	wasOver := g.over
+	player, ok := g.forfeitFlag()
+	g.finished(wasOver)
+	return player, ok
+}
+
+func (g *game) forfeitFlag() (string, bool) {
+	if !g.rules.timed() || g.over || g.openSeats() > 0 {
+		return "", false
+	}
+	player := g.nextMove()
+	if g.timeLeft(player, g.now()) > 0 {
+		// The timer of an earlier turn fired after the move was made.
+		g.armFlag()
+		return "", false
+	}
+	if g.quit(player) != STATUS_LEFT_GAME {
+		return "", false
+	}
+	QUITS.Inc("time")
+	g.playBots()
+	return player, true
+}
+
+// Clocks returns the time each player still playing has left, for timed
+// games.
+func (g *game) Clocks() map[string]time.Duration {
+	g.RLock()
+	defer g.RUnlock()
+	return g.clocksAt(g.now())
+}
+
+func (g *game) clocksAt(now time.Time) map[string]time.Duration {
+	if !g.rules.timed() {
+		return nil
+	}
+	clocks := map[string]time.Duration{}
+	for _, player := range g.currentlyPlaying() {
+		clocks[player] = g.timeLeft(player, now)
+	}
+	return clocks
+}
+
+// WaitForMoves blocks until there are more than since moves or the game is
+// over. It gives up after wait or when ctx is done, returning false.
+func (g *game) WaitForMoves(ctx context.Context, since int, wait time.Duration) bool {
+	expired := false
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		case <-stop:
+			return
+		}
+		g.Lock()
+		expired = true
+		g.changed.Broadcast()
+		g.Unlock()
+	}()
+
+	g.Lock()
+	defer g.Unlock()
+	for len(g.moves) <= since && !g.over && !expired {
+		g.changed.Wait()
+	}
+	return len(g.moves) > since || g.over
+}
+
+// storeMove records the last move made. A failure is logged rather than
+// undoing the move; the next snapshot will include it.
+func (g *game) storeMove() {
+	if g.store == nil {
+		return
+	}
+	num := len(g.moves) - 1
+	err := g.store.Append(g.id, num, g.moves[num])
+	if err != nil {
+		LOGGER.Println(fmt.Sprintf("failed to store move %d of game %s: %s", num, g.id, err))
+	}
+}
+
+// publishMove publishes the last move made, followed by the end of the game if
+// the move finished it.
+func (g *game) publishMove() {
+	num := len(g.moves) - 1
+	move := g.moves[num]
+	e := &GameEvent{
+		Type:   EventMove,
+		GameId: g.id,
+		Move:   num,
+		Player: move.player,
+	}
+	switch move.Type {
+	case MoveQuit:
+		e.Type = EventQuit
+	case MovePop:
+		e.Type = EventPop
+		fallthrough
+	default:
+		row, col := move.row, move.col
+		e.Row = &row
+		e.Column = &col
+	}
+	g.publish(e)
+
+	if g.over {
+		g.publish(&GameEvent{
+			Type:   EventGameOver,
+			GameId: g.id,
+			Move:   num,
+			Winner: g.winner,
+			Draw:   g.winner == "",
+
+			WinningLines: g.winningLines,
+		})
+	}
+}
+
+// publish sends an event to this game's subscribers and a copy to the lobby,
+// which numbers its events separately.
+func (g *game) publish(e *GameEvent) {
+	g.hub.publish(e)
+	if g.lobby != nil {
+		lobbyEvent := *e
+		g.lobby.publish(&lobbyEvent)
+	}
+}
+
+// SubscribeAfter is Subscribe for clients that know the id of the last event
+// they received instead of a move number. An id this server never published
+// is from before it restarted, so events are sent from move number from.
+func (g *game) SubscribeAfter(lastId, from int) ([]*GameEvent, chan *GameEvent, func()) {
+	g.RLock()
+	defer g.RUnlock()
+	if lastId >= g.hub.published() {
+		return g.hub.subscribe(func(e *GameEvent) bool {
+			return e.Move >= from
+		})
+	}
+	return g.hub.subscribe(func(e *GameEvent) bool {
+		return e.Id > lastId
+	})
+}
+
+// Subscribe returns the events caused by moves numbered from onwards and a
+// channel of events as they happen. The returned func stops the subscription.
+func (g *game) Subscribe(from int) ([]*GameEvent, chan *GameEvent, func()) {
+	// Hold the game lock so no move is applied between reading the backlog
+	// and subscribing.
+	g.RLock()
+	defer g.RUnlock()
+	return g.hub.subscribe(func(e *GameEvent) bool {
+		return e.Move >= from
+	})
 }
 
 func (g *game) GameStatus() *GameStatusResponse {
@@ -88,19 +425,105 @@ func (g *game) GameStatus() *GameStatusResponse {
 
 	if g.over {
 		status = STATUS_DONE
+	} else if g.openSeats() > 0 {
+		status = STATUS_WAITING
 	} else {
 		status = STATUS_IN_PROGRESS
 	}
+	rules := g.rules
 	gameStatus := &GameStatusResponse{
-		Players: g.currentlyPlaying(),
-		Status:  status,
+		Players:   g.currentlyPlaying(),
+		Status:    status,
+		Rules:     &rules,
+		OpenSeats: g.openSeats(),
+		Takeback:  g.takebackResponse(),
+	}
+	if clocks := g.clocksAt(g.now()); clocks != nil && status != STATUS_DONE {
+		gameStatus.Clocks = map[string]int64{}
+		for player, left := range clocks {
+			gameStatus.Clocks[player] = left.Milliseconds()
+		}
+	}
+	if g.rules.Teams > 0 {
+		gameStatus.Teams = g.teams()
+	}
+	if g.rules.WinCondition == WinScoring {
+		gameStatus.Scores = g.scores()
 	}
 	if status == STATUS_DONE {
+		gameStatus.Ranking = g.standings()
 		gameStatus.Winner = g.winner
+		gameStatus.WinningLines = g.winningLines
+		if g.rules.Teams > 0 && g.winner != "" {
+			gameStatus.WinningTeam = gameStatus.Teams[g.team(g.winner)]
+		}
 	}
 	return gameStatus
 }
 
+func (g *game) cursor() *GameCursor {
+	return &GameCursor{Created: g.created, Id: g.id}
+}
+
+// Summary returns the players, rules and progress of the game for listing.
+func (g *game) Summary() *GameSummary {
+	g.RLock()
+	defer g.RUnlock()
+	rules := g.rules
+	summary := &GameSummary{
+		Id:        g.id,
+		Created:   g.created,
+		Players:   append([]string{}, g.playerList...),
+		Status:    STATUS_IN_PROGRESS,
+		Rules:     &rules,
+		Moves:     len(g.moves),
+		OpenSeats: g.openSeats(),
+	}
+	if g.openSeats() > 0 {
+		summary.Status = STATUS_WAITING
+	}
+	if g.over {
+		summary.Status = STATUS_DONE
+		summary.Winner = g.winner
+	}
+	return summary
+}
+
+// matches returns if the game passes the filters of a list request.
+func (g *game) matches(glr *GameListRequest) bool {
+	g.RLock()
+	defer g.RUnlock()
+	status := STATUS_IN_PROGRESS
+	if g.over {
+		status = STATUS_DONE
+	} else if g.openSeats() > 0 {
+		status = STATUS_WAITING
+	}
+	if glr.Status != "" && glr.Status != status {
+		return false
+	}
+	if glr.Player != "" {
+		if _, ok := g.players[glr.Player]; !ok {
+			return false
+		}
+	}
+	if !glr.CreatedAfter.IsZero() && !g.created.After(glr.CreatedAfter) {
+		return false
+	}
+	if !glr.CreatedBefore.IsZero() && !g.created.Before(glr.CreatedBefore) {
+		return false
+	}
+	return glr.After == nil || glr.After.Before(g.cursor())
+}
+
+// MoveCount returns how many moves have been made, which is also the number
+// of the next move.
+func (g *game) MoveCount() int {
+	g.RLock()
+	defer g.RUnlock()
+	return len(g.moves)
+}
+
 func (g *game) GetMove(index int) (*Move, error) {
 	g.RLock()
 	defer g.RUnlock()
@@ -121,22 +544,34 @@ func (g *game) GetMoves(start, until int) []*Move {
 		until = until + 1
 	}
 	moves := []*Move{}
+	if start >= until {
+		return moves
+	}
 	for _, m := range g.moves[start:until] {
 		moves = append(moves, m)
 	}
 	return moves
 }
 
+// boardIsFull returns if there is no room for another coin. Coins dropped
+// fill the top row last.
 func (g *game) boardIsFull() bool {
-	for _, item := range g.board[0] {
-		if item == "" {
-			return false
+	rows := g.board[:1]
+	if g.rules.FreePlacement {
+		rows = g.board
+	}
+	for _, row := range rows {
+		for _, item := range row {
+			if item == "" {
+				return false
+			}
 		}
 	}
 	return true
 }
 
-// makeMove performs the move on the board and sets related status.
+// makeMove drops the player's coin to the lowest empty row of col and sets
+// related status.
 func (g *game) makeMove(playerId string, col int) MoveStatus {
 	spot := g.board[0][col]
 	if spot != "" {
@@ -155,28 +590,217 @@ func (g *game) makeMove(playerId string, col int) MoveStatus {
 		}
 	}
 
-	g.board[lastEmptyRow][col] = playerId
-	g.moves = append(g.moves, &Move{playerId, lastEmptyRow, col, MoveMove})
+	g.addCoin(playerId, lastEmptyRow, col)
+	return MoveOK
+}
+
+// placeMove puts the player's coin on the empty cell at row, col, for games
+// with free placement, and sets related status.
+func (g *game) placeMove(playerId string, row, col int) MoveStatus {
+	if row < 0 || row >= len(g.board) || g.board[row][col] != "" {
+		return MoveBadRequest
+	}
+	g.addCoin(playerId, row, col)
+	return MoveOK
+}
+
+// addCoin puts the player's coin at row, col and checks if it wins.
+func (g *game) addCoin(playerId string, row, col int) {
+	g.board[row][col] = playerId
+	move := &Move{
+		player: playerId,
+		row:    row,
+		col:    col,
+		Type:   MoveMove,
+		at:     g.now(),
+	}
+	g.chargeClock(playerId, move.at)
+	g.moves = append(g.moves, move)
 
 	playerGraph := g.playerGraphs[playerId]
-	playerGraph.Add(lastEmptyRow, col)
+	playerGraph.Add(row, col)
 
-	won := playerGraph.FindConsecutive(lastEmptyRow, col, g.sequentialWin)
-	if won {
-		g.winner = playerId
-		g.over = true
+	// Lines only count towards the score in scoring games.
+	if g.rules.WinCondition != WinScoring && playerGraph.FindConsecutive(row, col, g.sequentialWin) {
+		move.lines = winningLines(playerGraph, row, col, g.sequentialWin)
+		if g.rules.WinCondition == WinMisere {
+			g.knockOut(playerId)
+		} else {
+			g.winner = playerId
+			g.over = true
+			g.winningLines = move.lines
+		}
+	}
+	g.endIfFull()
+	g.moveApplied()
+}
+
+// endIfFull ends the game once the board is full, in a draw or in scoring
+// games a win on points. With Pop Out a full board only ends the game once
+// the player on turn has nothing to pop, which a quit can also bring about.
+func (g *game) endIfFull() {
+	if g.over || !g.boardIsFull() || g.canPop(g.nextMove()) {
+		return
+	}
+	g.over = true
+	if g.rules.WinCondition == WinScoring {
+		g.winner = g.topScorer()
+	}
+}
+
+// canPop returns if the player has a coin in the bottom row to pop out.
+func (g *game) canPop(playerId string) bool {
+	if !g.rules.PopOut {
+		return false
+	}
+	for _, spot := range g.board[len(g.board)-1] {
+		if spot == playerId {
+			return true
+		}
+	}
+	return false
+}
+
+// makePop removes the player's coin from the bottom of col, shifting the
+// coins above it down, and sets related status. Every coin that moved may
+// complete a line. If several players complete one the player who popped
+// wins, or if they did not, the first of the others in turn order.
+func (g *game) makePop(playerId string, col int) MoveStatus {
+	bottom := len(g.board) - 1
+	if !g.rules.PopOut || g.board[bottom][col] != playerId {
+		return MoveCannotPop
+	}
+	move := &Move{
+		player: playerId,
+		row:    bottom,
+		col:    col,
+		Type:   MovePop,
+		at:     g.now(),
+	}
+	g.chargeClock(playerId, move.at)
+	g.moves = append(g.moves, move)
+
+	column := []string{""}
+	for row := 0; row < bottom; row++ {
+		column = append(column, g.board[row][col])
 	}
-	if g.boardIsFull() {
+	g.setColumn(col, column)
+
+	// Lines by the side completing them.
+	lines := map[string][]*WinningLine{}
+	for row := bottom; row >= 0 && g.board[row][col] != ""; row-- {
+		owner := g.board[row][col]
+		graph := g.playerGraphs[owner]
+		if !graph.FindConsecutive(row, col, g.sequentialWin) {
+			continue
+		}
+		side := g.side(owner)
+		for _, line := range winningLines(graph, row, col, g.sequentialWin) {
+			lines[side] = addLine(lines[side], line)
+		}
+	}
+	if winner := g.popWinner(playerId, lines); winner != "" {
+		g.winner = winner
 		g.over = true
+		move.lines = lines[g.side(winner)]
+		g.winningLines = move.lines
 	}
+	g.moveApplied()
 	return MoveOK
 }
 
-// Move returns an error if there was a problem with the move.
+// popWinner returns who of the players still playing whose side completes
+// lines wins a pop by playerId: playerId if they are one of them, otherwise
+// the first after them in turn order.
+func (g *game) popWinner(playerId string, lines map[string][]*WinningLine) string {
+	for i, player := range g.playerList {
+		if player != playerId {
+			continue
+		}
+		for j := 0; j < len(g.playerList); j++ {
+			next := g.playerList[(i+j)%len(g.playerList)]
+			if g.players[next] && len(lines[g.side(next)]) > 0 {
+				return next
+			}
+		}
+	}
+	return ""
+}
+
+// addLine adds a line to lines unless it is already there, as it is when
+// found through two of its coins.
+func addLine(lines []*WinningLine, line *WinningLine) []*WinningLine {
+	for _, l := range lines {
+		if l.Line != line.Line {
+			continue
+		}
+		// Lines of the same orientation sharing a coin are the same line.
+		for _, cell := range l.Cells {
+			if cell == line.Cells[0] {
+				return lines
+			}
+		}
+	}
+	return append(lines, line)
+}
+
+// setColumn replaces the coins of col, from the top row down, and moves them
+// in their players' graphs to match.
+func (g *game) setColumn(col int, column []string) {
+	for row, owner := range column {
+		if old := g.board[row][col]; old != "" {
+			g.playerGraphs[old].Remove(row, col)
+		}
+		g.board[row][col] = owner
+	}
+	for row, owner := range column {
+		if owner != "" {
+			g.playerGraphs[owner].Add(row, col)
+		}
+	}
+}
+
+// Move drops a coin in col, see Play.
 func (g *game) Move(playerId string, col int) (*MoveConfirmation, MoveStatus) {
+	return g.Play(playerId, MoveMove, 0, col)
+}
+
+// Pop removes the player's coin from the bottom of col in a game played with
+// Pop Out, see Play.
+func (g *game) Pop(playerId string, col int) (*MoveConfirmation, MoveStatus) {
+	return g.Play(playerId, MovePop, 0, col)
+}
+
+// Place puts a coin at row, col in a game with free placement, see Play.
+func (g *game) Place(playerId string, row, col int) (*MoveConfirmation, MoveStatus) {
+	return g.Play(playerId, MoveMove, row, col)
+}
+
+// Play makes a move of type mt in col, dropping a coin or popping one out.
+// Coins only go to row in games with free placement. It returns an error if
+// there was a problem with the move. Engine players whose turn follows move
+// before it returns.
+func (g *game) Play(playerId string, mt MoveType, row, col int) (*MoveConfirmation, MoveStatus) {
 	g.Lock()
 	defer g.Unlock()
 
+	wasOver := g.over
+	// A player out of time loses before the timer gets to them.
+	if late, ok := g.forfeitFlag(); ok && late == playerId {
+		MOVES.Inc(string(MoveOutOfTime))
+		g.finished(wasOver)
+		return nil, MoveOutOfTime
+	}
+	confirmation, status := g.move(playerId, mt, row, col)
+	MOVES.Inc(string(status))
+	if status == MoveOK {
+		g.playBots()
+	}
+	g.finished(wasOver)
+	return confirmation, status
+}
+
+func (g *game) move(playerId string, mt MoveType, row, col int) (*MoveConfirmation, MoveStatus) {
 	// Validate this column
 	if col < 0 || col > len(g.board[0])-1 {
 		return nil, MoveBadRequest
@@ -192,16 +816,62 @@ func (g *game) Move(playerId string, col int) (*MoveConfirmation, MoveStatus) {
 		return nil, MoveBadRequest
 	}
 
+	if g.openSeats() > 0 {
+		return nil, MoveNotStarted
+	}
+
 	// Check if it's my turn
 	if g.nextMove() != playerId {
 		return nil, MoveWrongTurn
 	}
-	status := g.makeMove(playerId, col)
+	var status MoveStatus
+	switch {
+	case mt == MovePop:
+		status = g.makePop(playerId, col)
+	case g.rules.FreePlacement:
+		status = g.placeMove(playerId, row, col)
+	default:
+		status = g.makeMove(playerId, col)
+	}
+	if status != MoveOK {
+		return nil, status
+	}
 
 	confirmation := MkConfirmation(g.id, len(g.moves)-1)
 	return confirmation, status
 }
 
+// Tokens returns the secret token of each seat held by a person.
+func (g *game) Tokens() map[string]string {
+	g.RLock()
+	defer g.RUnlock()
+	tokens := map[string]string{}
+	for player, token := range g.tokens {
+		tokens[player] = token
+	}
+	return tokens
+}
+
+// Authorize checks the token given for a player. Seats without a token are
+// engine players nobody may act for.
+func (g *game) Authorize(playerId, token string) bool {
+	g.RLock()
+	defer g.RUnlock()
+	expected, ok := g.tokens[playerId]
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(token)) == 1
+}
+
+// hasSeat returns if the player was ever part of this game.
+func (g *game) hasSeat(playerId string) bool {
+	g.RLock()
+	defer g.RUnlock()
+	_, ok := g.players[playerId]
+	return ok
+}
+
 func (g *game) isDone() bool {
 	g.RLock()
 	defer g.RUnlock()
@@ -230,11 +900,23 @@ func (g *game) currentlyPlaying() []string {
 	return players
 }
 
+// Quit removes the player from the game. Engine players whose turn follows
+// move before it returns.
 func (g *game) Quit(playerId string) GameStatus {
-
 	g.Lock()
 	defer g.Unlock()
 
+	wasOver := g.over
+	status := g.quit(playerId)
+	if status == STATUS_LEFT_GAME {
+		QUITS.Inc("player")
+		g.playBots()
+	}
+	g.finished(wasOver)
+	return status
+}
+
+func (g *game) quit(playerId string) GameStatus {
 	playing, ok := g.players[playerId]
 	if !ok {
 		return STATUS_INVALID_GAME
@@ -248,41 +930,296 @@ func (g *game) Quit(playerId string) GameStatus {
 		return STATUS_QUIT_LEFT_GAME
 	}
 
-	// Can quit now.
-	g.players[playerId] = false
-	playersLeft := g.currentlyPlaying()
-	if len(playersLeft) == 1 {
-		g.over = true
-		g.winner = playersLeft[0]
+	if g.openSeats() > 0 {
+		return STATUS_WAITING
 	}
+
+	// Can quit now. The next player's turn starts if it was this player's.
+	if g.nextMove() == playerId {
+		g.turnStarted = g.now()
+	}
+	g.players[playerId] = false
+	// A team plays on while any of its players are left.
+	g.lastSideWins()
+	g.endIfFull()
 	g.moves = append(g.moves, &Move{
 		player: playerId,
 		Type:   MoveQuit,
+		at:     g.now(),
 	})
+	g.moveApplied()
 	return STATUS_LEFT_GAME
 }
 
-// NextMove returns the playerId of the user who has the next move.
+// finished counts and rates the game if the move just made finished it.
+func (g *game) finished(wasOver bool) {
+	if wasOver || !g.over {
+		return
+	}
+	g.countFinished()
+	if g.ratings != nil {
+		g.ratings.Record(g.results())
+	}
+}
+
+// ForfeitIdle quits the player on turn if nobody has moved for timeout,
+// returning who was forfeited.
+func (g *game) ForfeitIdle(now time.Time, timeout time.Duration) (string, bool) {
+	g.Lock()
+	defer g.Unlock()
+	if g.over || g.openSeats() > 0 || now.Sub(g.lastActivity) < timeout {
+		return "", false
+	}
+	player := g.nextMove()
+	if g.quit(player) != STATUS_LEFT_GAME {
+		return "", false
+	}
+	QUITS.Inc("idle")
+	g.playBots()
+	g.finished(false)
+	return player, true
+}
+
+// Expired returns if the game has been over, or waiting for its open seats to
+// be claimed, for at least ttl.
+func (g *game) Expired(now time.Time, ttl time.Duration) bool {
+	g.RLock()
+	defer g.RUnlock()
+	return (g.over || g.openSeats() > 0) && now.Sub(g.lastActivity) >= ttl
+}
+
+// openSeats returns how many seats are yet to be claimed. Nobody may move
+// until they all are.
+func (g *game) openSeats() int {
+	return g.rules.Players - len(g.playerList)
+}
+
+// Invites returns the unused invites to the open seats.
+func (g *game) Invites() []string {
+	g.RLock()
+	defer g.RUnlock()
+	invites := []string{}
+	for invite := range g.invites {
+		invites = append(invites, invite)
+	}
+	sort.Strings(invites)
+	return invites
+}
+
+// hasInvite returns if invite claims an open seat of this game.
+func (g *game) hasInvite(invite string) bool {
+	g.RLock()
+	defer g.RUnlock()
+	return g.invites[invite]
+}
+
+// Join seats a player in an open seat, returning their token. An empty invite
+// claims a seat of a public game. Engine players whose turn it is move once
+// the last seat is claimed.
+func (g *game) Join(playerId, invite string) (string, JoinStatus) {
+	g.Lock()
+	defer g.Unlock()
+	if invite != "" && !g.invites[invite] {
+		return "", JoinUnknownInvite
+	}
+	if g.over || g.openSeats() == 0 {
+		return "", JoinFull
+	}
+	if _, ok := g.players[playerId]; ok {
+		return "", JoinSeated
+	}
+	if invite == "" && !g.public {
+		return "", JoinInviteOnly
+	}
+	token := mkToken()
+	g.join(playerId, token, invite)
+	g.playBots()
+	return token, JoinOK
+}
+
+// join seats a player holding token, using up invite if there is one.
+func (g *game) join(playerId, token, invite string) {
+	g.players[playerId] = true
+	g.playerList = append(append([]string{}, g.playerList...), playerId)
+	g.tokens[playerId] = token
+	g.rated[playerId] = true
+	g.playerGraphs[playerId] = g.graphFor(len(g.playerList) - 1)
+	g.clocks[playerId] = time.Duration(g.rules.ClockSeconds) * time.Second
+	delete(g.invites, invite)
+	g.lastActivity = g.now()
+	g.turnStarted = g.lastActivity
+
+	if g.store != nil {
+		err := g.store.Join(g.id, playerId, token, invite, g.lastActivity)
+		if err != nil {
+			LOGGER.Println(fmt.Sprintf("failed to store %s joining game %s: %s", playerId, g.id, err))
+		}
+	}
+	g.publish(&GameEvent{
+		Type:   EventPlayerJoined,
+		GameId: g.id,
+		Move:   len(g.moves),
+		Player: playerId,
+	})
+	if g.openSeats() == 0 {
+		// Invites left over once public seats are claimed are of no use.
+		g.invites = map[string]bool{}
+		g.publish(&GameEvent{
+			Type:    EventGameStarted,
+			GameId:  g.id,
+			Move:    len(g.moves),
+			Players: append([]string{}, g.playerList...),
+		})
+	}
+	g.armFlag()
+	g.changed.Broadcast()
+}
+
+// NextMove returns the playerId of the user who has the next move. Quits do
+// not take a turn, so it is the first player still playing after whoever made
+// the last move.
 func (g *game) nextMove() string {
-	players := g.currentlyPlaying()
-	if len(g.moves) == 0 {
+	var lastMove *Move
+	for i := len(g.moves) - 1; i >= 0; i-- {
+		if g.moves[i].Type != MoveQuit {
+			lastMove = g.moves[i]
+			break
+		}
+	}
+	if lastMove == nil {
+		players := g.currentlyPlaying()
+		if len(players) == 0 {
+			return ""
+		}
 		return players[0]
 	}
 
-	lastMove := g.moves[len(g.moves)-1]
+	if g.rules.Teams > 0 {
+		return g.nextTeamMove(lastMove.player)
+	}
+
 	var player string
-	for i := 0; i < len(players); i++ {
-		if players[i] == lastMove.player {
-			player = players[(i+1)%len(players)]
-			break
+	for i := 0; i < len(g.playerList); i++ {
+		if g.playerList[i] != lastMove.player {
+			continue
+		}
+		for j := 1; j <= len(g.playerList); j++ {
+			next := g.playerList[(i+j)%len(g.playerList)]
+			if g.players[next] {
+				player = next
+				break
+			}
 		}
+		break
 	}
 	return player
 }
 
+// nextTeamMove returns who moves after playerId in a team game. Teams take
+// turns, skipping any with nobody left, and the players of a team take turns
+// within it.
+func (g *game) nextTeamMove(playerId string) string {
+	teams := g.teams()
+	team := g.team(playerId)
+	for i := 1; i <= len(teams); i++ {
+		next := (team + i) % len(teams)
+		members := teams[next]
+		// Start after whoever last moved for the team.
+		start := 0
+		for j := len(g.moves) - 1; j >= 0; j-- {
+			m := g.moves[j]
+			if m.Type == MoveQuit || g.team(m.player) != next {
+				continue
+			}
+			for k, member := range members {
+				if member == m.player {
+					start = k + 1
+				}
+			}
+			break
+		}
+		for k := 0; k < len(members); k++ {
+			member := members[(start+k)%len(members)]
+			if g.players[member] {
+				return member
+			}
+		}
+	}
+	return ""
+}
+
+// team returns the team a player is on, or -1 in games without teams.
+func (g *game) team(playerId string) int {
+	if g.rules.Teams == 0 {
+		return -1
+	}
+	for seat, player := range g.playerList {
+		if player == playerId {
+			return seat % g.rules.Teams
+		}
+	}
+	return -1
+}
+
+// teams returns the players seated on each team, in seat order.
+func (g *game) teams() [][]string {
+	teams := make([][]string, g.rules.Teams)
+	for seat, player := range g.playerList {
+		team := seat % g.rules.Teams
+		teams[team] = append(teams[team], player)
+	}
+	return teams
+}
+
+// side returns who a player's coins count for: their team in games with
+// teams, otherwise the player themselves.
+func (g *game) side(playerId string) string {
+	if team := g.team(playerId); team >= 0 {
+		return "team:" + strconv.Itoa(team)
+	}
+	return playerId
+}
+
+// graphFor returns where the coins of the player in seat are kept, shared
+// with any teammate already seated.
+func (g *game) graphFor(seat int) LineFinder {
+	if g.rules.Teams > 0 {
+		for s := seat % g.rules.Teams; s < seat; s += g.rules.Teams {
+			if graph, ok := g.playerGraphs[g.playerList[s]]; ok {
+				return graph
+			}
+		}
+	}
+	return newLineFinder(&g.rules)
+}
+
+// newLineFinder returns where a player's coins are kept on a board laid out
+// by rules. Boards with joined edges use a PlayerGraph, since lines on a
+// BitBoard stop at its edges.
+func newLineFinder(rules *Rules) LineFinder {
+	if rules.Topology == "" {
+		return NewBitBoard(rules.Rows, rules.Columns)
+	}
+	return NewPlayerGraph(rules.edges())
+}
+
 func CreateGame(winningSequence, rows, cols int, players ...string) *game {
+	return CreateGameWithRules(&Rules{
+		Rows:      rows,
+		Columns:   cols,
+		WinLength: winningSequence,
+		Players:   len(players),
+	}, players...)
+}
+
+// CreateGameWithRules creates a game for the players laid out as described by rules.
+func CreateGameWithRules(rules *Rules, players ...string) *game {
 	g := &game{}
-	g.sequentialWin = winningSequence
+	g.rules = *rules
+	g.sequentialWin = rules.WinLength
+
+	rows := rules.Rows
+	cols := rules.Columns
 
 	board := [][]string{}
 	for i := 0; i < rows; i++ {
@@ -295,17 +1232,34 @@ func CreateGame(winningSequence, rows, cols int, players ...string) *game {
 	g.board = board
 	g.id = mkGameId()
 	playerMap := map[string]bool{}
-	graphs := map[string]*PlayerGraph{}
-	for _, player := range players {
+	g.playerList = players
+	g.playerGraphs = map[string]LineFinder{}
+	g.bots = map[string]BotLevel{}
+	g.tokens = map[string]string{}
+	g.rated = map[string]bool{}
+	g.clocks = map[string]time.Duration{}
+	g.invites = map[string]bool{}
+	for i := len(players); i < rules.Players; i++ {
+		g.invites[mkInvite()] = true
+	}
+	for seat, player := range players {
 		playerMap[player] = true
-		graphs[player] = &PlayerGraph{
-			coins: map[CoinKey]bool{},
+		if level, ok := parseBot(player); ok {
+			g.bots[player] = level
+		} else {
+			g.tokens[player] = mkToken()
 		}
+		g.playerGraphs[player] = g.graphFor(seat)
+		g.clocks[player] = time.Duration(rules.ClockSeconds) * time.Second
 	}
 	g.players = playerMap
 
-	g.playerList = players
 	g.moves = []*Move{}
-	g.playerGraphs = graphs
+	g.hub = newEventHub(0)
+	g.changed = sync.NewCond(&g.RWMutex)
+	g.now = time.Now
+	g.created = g.now().Round(0)
+	g.lastActivity = g.created
+	g.turnStarted = g.created
 	return g
 }
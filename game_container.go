@@ -1,12 +1,60 @@
 package main
 
 import (
+	"fmt"
+	"sort"
 	"sync"
+	"time"
 )
 
 type GamesContainer struct {
 	sync.RWMutex
 	games map[string]*game
+
+	store Store
+
+	ratings *Ratings
+
+	// Events of every game, including when games are created.
+	lobby *eventHub
+
+	// Players forfeited for taking too long and games removed by Reap.
+	forfeits  int
+	evictions int
+}
+
+// lobbyHistory is how many lobby events are kept for clients to catch up on.
+const lobbyHistory = 1000
+
+// NewGamesContainer returns a container holding the games recovered from store.
+func NewGamesContainer(store Store) (*GamesContainer, error) {
+	gc := &GamesContainer{
+		games: map[string]*game{},
+		store: store,
+		lobby: newEventHub(lobbyHistory),
+	}
+	games, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	gc.ratings, err = NewRatings(store)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range games {
+		g.Lock()
+		g.store = store
+		g.lobby = gc.lobby
+		g.ratings = gc.ratings
+		// Make any engine moves lost by a crash after the last move.
+		g.playBots()
+		// Clocks kept running while the server was down.
+		g.clockRunning = true
+		g.armFlag()
+		g.Unlock()
+		gc.games[g.id] = g
+	}
+	return gc, nil
 }
 
 func (gc *GamesContainer) Get(gameId string) (*game, bool) {
@@ -17,6 +65,16 @@ func (gc *GamesContainer) Get(gameId string) (*game, bool) {
 	return g, ok
 }
 
+// GetByInvite returns the game an unused invite claims a seat of.
+func (gc *GamesContainer) GetByInvite(invite string) (*game, bool) {
+	for _, g := range gc.all() {
+		if g.hasInvite(invite) {
+			return g, true
+		}
+	}
+	return nil, false
+}
+
 func (gc *GamesContainer) GetGames() []string {
 	gc.RLock()
 	defer gc.RUnlock()
@@ -28,8 +86,150 @@ func (gc *GamesContainer) GetGames() []string {
 	return glist
 }
 
+// List returns a page of the games matching glr, oldest first, and the
+// cursor of the next page or nil if this is the last. Without a limit every
+// matching game is listed.
+func (gc *GamesContainer) List(glr *GameListRequest) ([]*game, *GameCursor) {
+	games := []*game{}
+	for _, g := range gc.all() {
+		if g.matches(glr) {
+			games = append(games, g)
+		}
+	}
+	sort.Slice(games, func(i, j int) bool {
+		return games[i].cursor().Before(games[j].cursor())
+	})
+	if glr.Limit == 0 || len(games) <= glr.Limit {
+		return games, nil
+	}
+	games = games[:glr.Limit]
+	return games, games[len(games)-1].cursor()
+}
+
+// Add stores a new game and makes it available, then lets an engine player
+// with the first move make it. Other games are not held up while it thinks.
 func (gc *GamesContainer) Add(g *game) {
 	gc.Lock()
-	defer gc.Unlock()
+	err := gc.store.Create(g)
+	if err != nil {
+		LOGGER.Println(fmt.Sprintf("failed to store game %s: %s", g.id, err))
+	}
+	GAMES_CREATED.Inc()
+	g.Lock()
+	g.store = gc.store
+	g.lobby = gc.lobby
+	g.ratings = gc.ratings
+	gc.lobby.publish(&GameEvent{
+		Type:    EventGameCreated,
+		GameId:  g.id,
+		Move:    len(g.moves),
+		Players: g.currentlyPlaying(),
+	})
 	gc.games[g.id] = g
+	gc.Unlock()
+
+	g.playBots()
+	g.clockRunning = true
+	g.armFlag()
+	g.Unlock()
+}
+
+// SubscribeLobby returns the retained lobby events after lastId and a channel
+// of new events of every game.
+func (gc *GamesContainer) SubscribeLobby(lastId int) ([]*GameEvent, chan *GameEvent, func()) {
+	return gc.lobby.subscribe(func(e *GameEvent) bool {
+		return e.Id > lastId
+	})
+}
+
+func (gc *GamesContainer) all() []*game {
+	gc.RLock()
+	defer gc.RUnlock()
+	games := []*game{}
+	for _, g := range gc.games {
+		games = append(games, g)
+	}
+	return games
+}
+
+// Snapshot compacts the store to the games currently held.
+func (gc *GamesContainer) Snapshot() error {
+	return gc.store.Snapshot(gc.all)
+}
+
+// Reap forfeits the player on turn in games nobody has moved in for
+// idleTimeout, then archives and removes games that have been over for
+// finishedTTL. A zero duration disables either.
+func (gc *GamesContainer) Reap(now time.Time, finishedTTL, idleTimeout time.Duration) {
+	if idleTimeout > 0 {
+		for _, g := range gc.all() {
+			player, ok := g.ForfeitIdle(now, idleTimeout)
+			if !ok {
+				continue
+			}
+			LOGGER.Println(fmt.Sprintf("forfeited idle player %s in game %s", player, g.id))
+			gc.Lock()
+			gc.forfeits++
+			gc.Unlock()
+		}
+	}
+	if finishedTTL <= 0 {
+		return
+	}
+
+	// Expired games are dropped before they are archived, so a snapshot
+	// taken in between leaves them out rather than bringing them back.
+	expired := []*game{}
+	gc.Lock()
+	for id, g := range gc.games {
+		if g.Expired(now, finishedTTL) {
+			expired = append(expired, g)
+			delete(gc.games, id)
+		}
+	}
+	gc.Unlock()
+
+	for _, g := range expired {
+		err := gc.store.Archive(g)
+		gc.Lock()
+		if err != nil {
+			LOGGER.Println(fmt.Sprintf("failed to archive game %s: %s", g.id, err))
+			gc.games[g.id] = g
+		} else {
+			gc.evictions++
+		}
+		gc.Unlock()
+	}
+}
+
+// ContainerStats counts the games held and those removed by Reap.
+type ContainerStats struct {
+	Games      int `json:"games"`
+	Waiting    int `json:"waiting"`
+	InProgress int `json:"inProgress"`
+	Finished   int `json:"finished"`
+	Forfeits   int `json:"forfeits"`
+	Evictions  int `json:"evictions"`
+}
+
+func (gc *GamesContainer) Stats() *ContainerStats {
+	gc.RLock()
+	defer gc.RUnlock()
+	stats := &ContainerStats{
+		Games:     len(gc.games),
+		Forfeits:  gc.forfeits,
+		Evictions: gc.evictions,
+	}
+	for _, g := range gc.games {
+		g.RLock()
+		if g.over {
+			stats.Finished++
+		} else if g.openSeats() > 0 {
+			stats.Waiting++
+		} else {
+			stats.InProgress++
+		}
+		g.RUnlock()
+	}
+	return stats
 }
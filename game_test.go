@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"testing"
+	"time"
 )
 
 // NOTE: only works for 4x4 board.
@@ -71,6 +73,74 @@ func Test_CreateGame(t *testing.T) {
 	if g.winner != "" {
 		t.Error("expected no winner")
 	}
+
+	if g.rules != (Rules{Rows: 4, Columns: 4, WinLength: 4, Players: 2}) {
+		t.Error("expected 4x4 rules for 2 players got", g.rules)
+	}
+}
+
+func Test_CreateGameWithRules(t *testing.T) {
+	g := CreateGameWithRules(&Rules{Rows: 6, Columns: 7, WinLength: 4, Players: 2}, "a", "b")
+	if len(g.board) != 6 {
+		t.Error("expected 6 rows got", len(g.board))
+	}
+	for _, row := range g.board {
+		if len(row) != 7 {
+			t.Error("expected 7 columns got", len(row))
+		}
+	}
+	g.Move("a", 6)
+	if g.moves[0].row != 5 {
+		t.Error("expected move row to be at index 5 got ", g.moves[0].row)
+	}
+}
+
+func Test_RuleBounds(t *testing.T) {
+	rb := &RuleBounds{3, 20, 3, 20, 3, 20, 2, 4}
+	err := rb.Validate(&Rules{Rows: 6, Columns: 7, WinLength: 4, Players: 2})
+	if err != nil {
+		t.Error("expected valid rules got", err)
+	}
+	err = rb.Validate(&Rules{Rows: 6, Columns: 7, WinLength: 2, Players: 2})
+	if err == nil || err.Error() != "winLength must be between 3 and 20, got 2" {
+		t.Error("expected winLength error got", err)
+	}
+	err = rb.Validate(&Rules{Rows: 6, Columns: 7, WinLength: 4, Players: 5})
+	if err == nil || err.Error() != "players must be between 2 and 4, got 5" {
+		t.Error("expected players error got", err)
+	}
+	err = rb.Validate(&Rules{Rows: 3, Columns: 3, WinLength: 4, Players: 2})
+	if err == nil || err.Error() != "winLength 4 does not fit on a 3x3 board" {
+		t.Error("expected winLength fit error got", err)
+	}
+	err = rb.Validate(&Rules{Rows: 6, Columns: 7, WinLength: 4, Players: 2, MoveSeconds: 30, ClockSeconds: 300})
+	if err == nil || err.Error() != "moveSeconds and clockSeconds cannot both be set" {
+		t.Error("expected time control error got", err)
+	}
+	err = rb.Validate(&Rules{Rows: 6, Columns: 7, WinLength: 4, Players: 2, IncrementSeconds: 2})
+	if err == nil || err.Error() != "incrementSeconds requires clockSeconds" {
+		t.Error("expected increment error got", err)
+	}
+	err = rb.Validate(&Rules{Rows: 6, Columns: 7, WinLength: 4, Players: 2, PopOut: true, FreePlacement: true})
+	if err == nil || err.Error() != "popOut cannot be used with freePlacement" {
+		t.Error("expected variant error got", err)
+	}
+	err = rb.Validate(&Rules{Rows: 6, Columns: 7, WinLength: 4, Players: 4, Teams: 3})
+	if err == nil || err.Error() != "teams must split 4 players evenly into teams of at least 2, got 3" {
+		t.Error("expected teams error got", err)
+	}
+	err = rb.Validate(&Rules{Rows: 6, Columns: 7, WinLength: 4, Players: 2, WinCondition: "LAST"})
+	if err == nil || err.Error() != "winCondition must be MISERE or SCORING, got LAST" {
+		t.Error("expected win condition error got", err)
+	}
+	err = rb.Validate(&Rules{Rows: 6, Columns: 7, WinLength: 4, Players: 2, PopOut: true, WinCondition: WinScoring})
+	if err == nil || err.Error() != "popOut cannot be used with winCondition SCORING" {
+		t.Error("expected variant error got", err)
+	}
+	err = rb.Validate(&Rules{Rows: 6, Columns: 7, WinLength: 4, Players: 2, Topology: "SPHERE"})
+	if err == nil || err.Error() != "topology must be CYLINDER or TORUS, got SPHERE" {
+		t.Error("expected topology error got", err)
+	}
 }
 func Test_Quit(t *testing.T) {
 	g := CreateGame(4, 4, 4, "a", "b", "c")
@@ -95,6 +165,37 @@ func Test_Quit(t *testing.T) {
 		t.Error("expected STATUS_GAME_OVER")
 	}
 }
+
+func Test_nextMoveAfterQuit(t *testing.T) {
+	g := CreateGame(4, 4, 4, "a", "b", "c", "d")
+	if g.nextMove() != "a" {
+		t.Error("expected a to start got", g.nextMove())
+	}
+
+	// Quitting out of turn keeps the turn where it was.
+	g.Move("a", 0)
+	g.Quit("c")
+	if g.nextMove() != "b" {
+		t.Error("expected b got", g.nextMove())
+	}
+
+	// Quitting in turn passes it on, skipping those who left.
+	g.Quit("b")
+	if g.nextMove() != "d" {
+		t.Error("expected d got", g.nextMove())
+	}
+	g.Move("d", 0)
+	if g.nextMove() != "a" {
+		t.Error("expected a got", g.nextMove())
+	}
+
+	// The first player quitting before any move.
+	g = CreateGame(4, 4, 4, "a", "b", "c")
+	g.Quit("a")
+	if g.nextMove() != "b" {
+		t.Error("expected b got", g.nextMove())
+	}
+}
 func Test_boardIsFull(t *testing.T) {
 	g := CreateGame(4, 4, 4, "a", "b")
 	if g.boardIsFull() {
@@ -203,6 +304,197 @@ func Test_Draw(t *testing.T) {
 	}
 }
 
+func Test_PopOut(t *testing.T) {
+	rules := &Rules{Rows: 4, Columns: 4, WinLength: 3, Players: 2, PopOut: true}
+	play := func(cols ...int) *game {
+		g := CreateGameWithRules(rules, "a", "b")
+		for _, col := range cols {
+			g.Move(g.nextMove(), col)
+		}
+		return g
+	}
+
+	g := play(0)
+	if _, status := g.Pop("b", 0); status != MoveCannotPop {
+		t.Error("expected b not to pop a's coin got ", status)
+	}
+
+	// The column shifts down, moving the coins in their players' graphs, and
+	// both complete a row. The player who popped wins.
+	g = play(0, 0, 0, 1, 1, 2, 2, 1)
+	if _, status := g.Pop("a", 0); status != MoveOK {
+		t.Fatal("expected a to pop got ", status)
+	}
+	if g.board[3][0] != "b" || g.board[2][0] != "a" || g.board[1][0] != "" || !g.playerGraphs["a"].Get(2, 0) ||
+		g.playerGraphs["a"].Get(1, 0) || !g.playerGraphs["b"].Get(3, 0) || g.playerGraphs["b"].Get(2, 0) {
+		t.Error("unexpected board after the pop ", g.board)
+	}
+	if g.Winner() != "a" || len(g.winningLines) != 1 || g.winningLines[0].Cells[0] != (Cell{2, 0}) {
+		t.Error("expected a to win with their row got ", g.Winner(), g.winningLines)
+	}
+
+	// Otherwise the other player completing a line wins.
+	g = play(0, 0, 0, 1, 1, 2, 3, 1)
+	g.Pop("a", 0)
+	if g.Winner() != "b" || len(g.winningLines) != 1 || g.winningLines[0].Line != LeftRight {
+		t.Error("expected b to win with their row got ", g.Winner(), g.winningLines)
+	}
+
+	// A full board is not a draw while the next player has a coin to pop.
+	rules = &Rules{Rows: 2, Columns: 2, WinLength: 3, Players: 2, PopOut: true}
+	g = CreateGameWithRules(rules, "a", "b")
+	for _, col := range []int{0, 0, 1, 1} {
+		g.Move(g.nextMove(), col)
+	}
+	if g.isDone() {
+		t.Fatal("expected a to be able to pop")
+	}
+	g.Pop("a", 0)
+	g.RequestTakeback("a")
+	g.AnswerTakeback("b", true)
+	if g.board[1][0] != "a" || g.board[0][0] != "b" || !g.playerGraphs["a"].Get(1, 0) || g.playerGraphs["b"].Get(1, 0) {
+		t.Error("expected the popped coin to be put back ", g.board)
+	}
+
+	g = CreateGame(3, 4, 4, "a", "b")
+	g.Move("a", 0)
+	g.Move("b", 1)
+	if _, status := g.Pop("a", 0); status != MoveCannotPop {
+		t.Error("expected no pops without the rule got ", status)
+	}
+}
+
+func Test_PopOutStuckAfterQuit(t *testing.T) {
+	/*
+
+	   a a b
+	   a B a
+	   b a a
+
+	*/
+	bot := "bot:easy"
+	rules := &Rules{Rows: 3, Columns: 3, WinLength: 3, Players: 3, PopOut: true}
+	g := CreateGameWithRules(rules, "a", bot, "b")
+	for _, m := range []struct {
+		player string
+		col    int
+	}{{"b", 0}, {"a", 0}, {"a", 0}, {"a", 1}, {bot, 1}, {"a", 1}, {"a", 2}, {"a", 2}, {"b", 2}} {
+		g.makeMove(m.player, m.col)
+	}
+	if g.isDone() || g.nextMove() != "a" {
+		t.Fatal("expected a to have a coin to pop")
+	}
+
+	// The engine player on turn has nothing to pop, so the game is drawn.
+	if status := g.Quit("a"); status != STATUS_LEFT_GAME {
+		t.Fatal("expected a to leave got ", status)
+	}
+	if !g.isDone() || g.Winner() != "" {
+		t.Error("expected a draw got ", g.Winner())
+	}
+	if _, ok := g.botPop(bot); ok {
+		t.Error("expected no column to pop")
+	}
+}
+
+func Test_FreePlacement(t *testing.T) {
+	rules := &Rules{Rows: 3, Columns: 3, WinLength: 3, Players: 2, FreePlacement: true}
+	g := CreateGameWithRules(rules, "x", "o")
+	g.Place("x", 0, 0)
+	if _, status := g.Place("o", 0, 0); status != MoveBadRequest {
+		t.Error("expected the taken cell to be rejected got ", status)
+	}
+	if _, status := g.Place("o", 3, 0); status != MoveBadRequest {
+		t.Error("expected the row to be out of range got ", status)
+	}
+	g.Place("o", 1, 0)
+	g.Place("x", 1, 1)
+	g.Place("o", 0, 2)
+	g.Place("x", 2, 2)
+	if g.Winner() != "x" || g.winningLines[0].Line != DiagonalLR_UD || g.moves[4].row != 2 {
+		t.Error("expected x to win on the diagonal got ", g.Winner(), g.winningLines)
+	}
+
+	// Only a board with every cell taken is a draw.
+	g = CreateGameWithRules(rules, "x", "o")
+	for _, cell := range []Cell{{0, 0}, {0, 1}, {0, 2}, {1, 1}, {1, 0}, {1, 2}, {2, 1}, {2, 0}} {
+		g.Place(g.nextMove(), cell.Row, cell.Col)
+	}
+	if g.isDone() {
+		t.Fatal("expected the game to go on with the top row full")
+	}
+	g.Place("x", 2, 2)
+	if !g.isDone() || g.Winner() != "" {
+		t.Error("expected a draw got ", g.Winner())
+	}
+}
+
+func Test_Topology(t *testing.T) {
+	rules := &Rules{Rows: 4, Columns: 5, WinLength: 4, Players: 2, Topology: TopologyCylinder}
+	g := CreateGameWithRules(rules, "a", "b")
+	for _, col := range []int{3, 2, 4, 2, 0, 2, 1} {
+		g.Move(g.nextMove(), col)
+	}
+	if g.Winner() != "a" || len(g.winningLines) != 1 {
+		t.Fatal("expected a to win across the edge got ", g.Winner())
+	}
+	if fmt.Sprint(g.winningLines[0].Cells) != "[{3 3} {3 4} {3 0} {3 1}]" {
+		t.Error("expected the row to run across the edge got ", g.winningLines[0].Cells)
+	}
+}
+
+func Test_Teams(t *testing.T) {
+	rules := &Rules{Rows: 6, Columns: 7, WinLength: 4, Players: 4, Teams: 2}
+	g := CreateGameWithRules(rules, "a", "b", "c", "d")
+
+	// Teammates' coins count together.
+	for _, col := range []int{0, 6, 1, 6, 2, 6, 3} {
+		player := g.nextMove()
+		if _, status := g.Move(player, col); status != MoveOK {
+			t.Fatal(player, " expected to move got ", status)
+		}
+	}
+	if g.Winner() != "c" || len(g.winningLines) != 1 || len(g.winningLines[0].Cells) != 4 {
+		t.Fatal("expected c to win with a's coins got ", g.Winner())
+	}
+	status := g.GameStatus()
+	if fmt.Sprint(status.Teams) != "[[a c] [b d]]" || fmt.Sprint(status.WinningTeam) != "[a c]" {
+		t.Error("unexpected teams ", status.Teams, status.WinningTeam)
+	}
+	for _, r := range g.results() {
+		if won := r.Outcome == OutcomeWin; won != (r.Player == "a" || r.Player == "c") {
+			t.Error("unexpected outcome for ", r.Player, " ", r.Outcome)
+		}
+	}
+
+	// Teams keep taking turns once a teammate leaves.
+	g = CreateGameWithRules(rules, "a", "b", "c", "d")
+	g.Move("a", 0)
+	g.Quit("c")
+	turns := []string{}
+	for i := 0; i < 5; i++ {
+		player := g.nextMove()
+		turns = append(turns, player)
+		g.Move(player, i)
+	}
+	if fmt.Sprint(turns) != "[b a d a b]" {
+		t.Error("unexpected turns ", turns)
+	}
+
+	// The game is over once a whole team leaves.
+	g.Quit("b")
+	if g.isDone() {
+		t.Fatal("expected d to play on for their team")
+	}
+	g.Quit("d")
+	if !g.isDone() || g.Winner() != "a" {
+		t.Error("expected a to win got ", g.Winner())
+	}
+	if status := g.GameStatus(); fmt.Sprint(status.WinningTeam) != "[a c]" {
+		t.Error("expected the whole team to win got ", status.WinningTeam)
+	}
+}
+
 func Test_GetMove(t *testing.T) {
 	g := CreateGame(4, 4, 4, "a", "b")
 	g.Move("a", 1)
@@ -275,3 +567,129 @@ func Test_GetMoves(t *testing.T) {
 	}
 
 }
+
+func Test_Subscribe(t *testing.T) {
+	g := CreateGame(4, 4, 4, "a", "b")
+	g.Move("a", 0)
+	g.Move("b", 1)
+
+	backlog, events, unsubscribe := g.Subscribe(1)
+	if len(backlog) != 1 || backlog[0].Move != 1 {
+		t.Error("expected only move 1 in the backlog got", len(backlog))
+	}
+
+	// A subscriber that never reads is dropped instead of blocking moves.
+	for i := 0; i < subscriberBuffer; i++ {
+		g.hub.publish(&GameEvent{Type: EventMove})
+	}
+	g.Move("a", 2)
+	count := 0
+	for range events {
+		count++
+	}
+	if count != subscriberBuffer {
+		t.Error("expected", subscriberBuffer, "buffered events got", count)
+	}
+	unsubscribe()
+}
+
+func Test_WaitForMoves(t *testing.T) {
+	g := CreateGame(4, 4, 4, "a", "b")
+	g.Move("a", 0)
+
+	if !g.WaitForMoves(context.Background(), 0, time.Second) {
+		t.Error("expected move 0 to already be there")
+	}
+	if g.WaitForMoves(context.Background(), 1, 10*time.Millisecond) {
+		t.Error("expected to give up waiting")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		g.Move("b", 1)
+	}()
+	if !g.WaitForMoves(context.Background(), 1, 5*time.Second) {
+		t.Error("expected to be woken by move 1")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if g.WaitForMoves(ctx, 2, 5*time.Second) {
+		t.Error("expected a cancelled wait to give up")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		g.Quit("a")
+	}()
+	g.WaitForMoves(context.Background(), 3, 5*time.Second)
+	if !g.isDone() {
+		t.Error("expected to be woken by the game ending")
+	}
+}
+
+func Test_Clock(t *testing.T) {
+	g := CreateGameWithRules(&Rules{Rows: 4, Columns: 4, WinLength: 4, Players: 2, ClockSeconds: 60, IncrementSeconds: 5}, "a", "b")
+	now := g.created
+	g.now = func() time.Time { return now }
+
+	now = now.Add(10 * time.Second)
+	g.Move("a", 0)
+	now = now.Add(20 * time.Second)
+	clocks := g.Clocks()
+	if clocks["a"] != 55*time.Second || clocks["b"] != 40*time.Second {
+		t.Error("expected 55s and 40s got ", clocks)
+	}
+	if status := g.GameStatus(); status.Clocks["a"] != 55000 || status.Clocks["b"] != 40000 {
+		t.Error("expected the clocks in the status got ", status.Clocks)
+	}
+
+	// Out of time before the timer notices.
+	now = now.Add(40 * time.Second)
+	if _, status := g.Move("b", 1); status != MoveOutOfTime {
+		t.Error("expected to be out of time got ", status)
+	}
+	if !g.isDone() || g.Winner() != "a" {
+		t.Error("expected a to win on time")
+	}
+}
+
+func Test_MoveTimeLimit(t *testing.T) {
+	g := CreateGameWithRules(&Rules{Rows: 4, Columns: 4, WinLength: 4, Players: 3, MoveSeconds: 30}, "a", "b", "c")
+	now := g.created
+	g.now = func() time.Time { return now }
+
+	now = now.Add(29 * time.Second)
+	g.Move("a", 0)
+	if _, ok := g.ForfeitFlag(); ok {
+		t.Error("expected b to have time left")
+	}
+	// Every move gets the full limit, however long earlier moves took.
+	if clocks := g.Clocks(); clocks["a"] != 30*time.Second || clocks["b"] != 30*time.Second {
+		t.Error("expected 30s each got ", clocks)
+	}
+	now = now.Add(30 * time.Second)
+	if player, ok := g.ForfeitFlag(); !ok || player != "b" {
+		t.Fatal("expected b to be forfeited got ", player)
+	}
+	// c's turn starts when b's flag falls.
+	if g.nextMove() != "c" || g.Clocks()["c"] != 30*time.Second || g.isDone() {
+		t.Error("expected c to have a full turn")
+	}
+}
+
+func Test_FlagTimer(t *testing.T) {
+	gc, _ := NewGamesContainer(&memoryStore{})
+	g := CreateGameWithRules(&Rules{Rows: 4, Columns: 4, WinLength: 4, Players: 2, MoveSeconds: 1}, "a", "b")
+	gc.Add(g)
+
+	// Leave a moment of a's turn.
+	g.Lock()
+	g.turnStarted = g.turnStarted.Add(-990 * time.Millisecond)
+	g.armFlag()
+	g.Unlock()
+	g.WaitForMoves(context.Background(), 0, 5*time.Second)
+	if !g.isDone() || g.Winner() != "b" {
+		t.Error("expected a to forfeit when their time ran out")
+	}
+}
@@ -35,6 +35,27 @@ var LINE_FOR_DIRECTION = map[Direction]Line{
 	DownLeft: DiagonalLR_DU,
 }
 
+// Topology is how the edges of a board join up. Lines continue across edges
+// that are joined.
+type Topology string
+
+// TopologyFlat joins no edges, the same as no topology.
+var TopologyFlat = Topology("FLAT")
+
+// TopologyCylinder joins the left and right edges.
+var TopologyCylinder = Topology("CYLINDER")
+
+// TopologyTorus joins the top and bottom edges as well.
+var TopologyTorus = Topology("TORUS")
+
+// Edges are the size of a board and the topology joining its edges. The zero
+// value is a flat board of any size.
+type Edges struct {
+	Rows     int
+	Cols     int
+	Topology Topology
+}
+
 type LineKey struct {
 	Row  int
 	Col  int
@@ -53,17 +74,33 @@ type DirectionKey struct {
 
 type PlayerGraph struct {
 	coins map[CoinKey]bool
+	edges Edges
+}
+
+// NewPlayerGraph returns an empty graph of a board with the given edges.
+func NewPlayerGraph(edges Edges) *PlayerGraph {
+	return &PlayerGraph{coins: map[CoinKey]bool{}, edges: edges}
 }
 
 func (pg *PlayerGraph) Add(row, col int) {
 	pg.coins[CoinKey{row, col}] = true
 }
 
+func (pg *PlayerGraph) Remove(row, col int) {
+	delete(pg.coins, CoinKey{row, col})
+}
+
 func (pg *PlayerGraph) Get(row, col int) bool {
 	return pg.coins[CoinKey{row, col}]
 }
 
-func mkNextDirectionKey(row, col int, direction Direction) DirectionKey {
+func (pg *PlayerGraph) Edges() Edges {
+	return pg.edges
+}
+
+// mkNextDirectionKey returns the key after row, col in direction, which is
+// across the edge of the board if the edge is joined.
+func mkNextDirectionKey(row, col int, direction Direction, edges Edges) DirectionKey {
 	var nextRow int
 	var nextCol int
 	switch direction {
@@ -95,6 +132,12 @@ func mkNextDirectionKey(row, col int, direction Direction) DirectionKey {
 		nextRow = row - 1
 		nextCol = col + 1
 	}
+	if edges.Topology == TopologyCylinder || edges.Topology == TopologyTorus {
+		nextCol = (nextCol + edges.Cols) % edges.Cols
+	}
+	if edges.Topology == TopologyTorus {
+		nextRow = (nextRow + edges.Rows) % edges.Rows
+	}
 	return DirectionKey{nextRow, nextCol, direction}
 }
 
@@ -102,9 +145,10 @@ func dfs(key DirectionKey, lkey LineKey, graph *PlayerGraph, count int, seen map
 	// Mark coordinate as seen for this line.
 	seen[lkey] = true
 
-	nextDirectionKey := mkNextDirectionKey(key.Row, key.Col, key.Direction)
-	if ok := graph.Get(nextDirectionKey.Row, nextDirectionKey.Col); ok {
-		nextLineKey := LineKey{nextDirectionKey.Row, nextDirectionKey.Col, lkey.Line}
+	// On joined edges a line of coins can lead back to where it started.
+	nextDirectionKey := mkNextDirectionKey(key.Row, key.Col, key.Direction, graph.edges)
+	nextLineKey := LineKey{nextDirectionKey.Row, nextDirectionKey.Col, lkey.Line}
+	if ok := graph.Get(nextDirectionKey.Row, nextDirectionKey.Col); ok && !seen[nextLineKey] {
 		count = dfs(nextDirectionKey, nextLineKey, graph, count+1, seen)
 	}
 	return count
@@ -171,3 +215,51 @@ func (pg *PlayerGraph) FindConsecutive(row, col, num int) bool {
 	}
 	return false
 }
+
+// Cell is a coordinate on the board.
+type Cell struct {
+	Row int `json:"row"`
+	Col int `json:"column"`
+}
+
+// WinningLine is a run of one player's coins long enough to win.
+type WinningLine struct {
+	Line  Line   `json:"line"`
+	Cells []Cell `json:"cells"`
+}
+
+// walk returns the cells holding coins from row, col onwards in direction,
+// not including row, col itself, stopping at any cell already seen.
+func walk(finder LineFinder, row, col int, direction Direction, seen map[Cell]bool) []Cell {
+	cells := []Cell{}
+	edges := finder.Edges()
+	key := mkNextDirectionKey(row, col, direction, edges)
+	for finder.Get(key.Row, key.Col) && !seen[Cell{key.Row, key.Col}] {
+		seen[Cell{key.Row, key.Col}] = true
+		cells = append(cells, Cell{key.Row, key.Col})
+		key = mkNextDirectionKey(key.Row, key.Col, direction, edges)
+	}
+	return cells
+}
+
+// winningLines returns every line of at least num coins through row, col,
+// with its cells in order from one end to the other.
+func winningLines(finder LineFinder, row, col, num int) []*WinningLine {
+	lines := []*WinningLine{}
+	for _, directions := range [][2]Direction{{Up, Down}, {Left, Right}, {UpLeft, DownRight}, {UpRight, DownLeft}} {
+		seen := map[Cell]bool{{row, col}: true}
+		back := walk(finder, row, col, directions[0], seen)
+		forward := walk(finder, row, col, directions[1], seen)
+		if len(back)+len(forward)+1 < num {
+			continue
+		}
+		cells := []Cell{}
+		for i := len(back) - 1; i >= 0; i-- {
+			cells = append(cells, back[i])
+		}
+		cells = append(cells, Cell{row, col})
+		cells = append(cells, forward...)
+		lines = append(lines, &WinningLine{LINE_FOR_DIRECTION[directions[0]], cells})
+	}
+	return lines
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"fmt"
+	"math/rand"
 	"testing"
 )
 
@@ -29,7 +31,7 @@ func Test_FindConsecutive(t *testing.T) {
 		CoinKey{2, 2},
 		CoinKey{3, 0})
 
-	pg := PlayerGraph{m}
+	pg := PlayerGraph{coins: m}
 	if !pg.FindConsecutive(0, 3, 4) {
 		t.Error("expecting to find 4")
 	}
@@ -71,7 +73,7 @@ func Test_FindConsecutive(t *testing.T) {
 		CoinKey{3, 2},
 		CoinKey{3, 3})
 
-	pg = PlayerGraph{m}
+	pg = PlayerGraph{coins: m}
 	if !pg.FindConsecutive(3, 0, 4) {
 		t.Error("expecting to find 4")
 	}
@@ -114,7 +116,7 @@ func Test_FindConsecutive(t *testing.T) {
 		CoinKey{3, 1},
 		CoinKey{3, 3})
 
-	pg = PlayerGraph{m}
+	pg = PlayerGraph{coins: m}
 	if !pg.FindConsecutive(0, 0, 4) {
 		t.Error("expecting to find 4")
 	}
@@ -157,7 +159,7 @@ func Test_FindConsecutive(t *testing.T) {
 		CoinKey{3, 0},
 		CoinKey{3, 3})
 
-	pg = PlayerGraph{m}
+	pg = PlayerGraph{coins: m}
 	if !pg.FindConsecutive(0, 3, 4) {
 		t.Error("expecting to find 4")
 	}
@@ -180,3 +182,270 @@ func Test_FindConsecutive(t *testing.T) {
 	}
 
 }
+
+func Test_BitBoardFindConsecutive(t *testing.T) {
+	/*
+
+	   a _ _ a
+	   _ _ a a
+	   _ a a a
+	   a _ _ a
+
+	*/
+	bb := NewBitBoard(4, 4)
+	for _, coin := range []CoinKey{{0, 0}, {0, 3}, {1, 2}, {1, 3}, {2, 1}, {2, 2}, {2, 3}, {3, 0}, {3, 3}} {
+		bb.Add(coin.Row, coin.Col)
+	}
+	if !bb.Get(2, 1) || bb.Get(2, 0) || bb.Get(4, 0) {
+		t.Error("expected coin at 2, 1 only")
+	}
+	// UpDown
+	if !bb.FindConsecutive(1, 3, 4) {
+		t.Error("expecting to find 4")
+	}
+	// DiagonalLR_DU
+	if !bb.FindConsecutive(2, 1, 4) {
+		t.Error("expecting to find 4")
+	}
+	// LeftRight
+	if !bb.FindConsecutive(2, 1, 3) {
+		t.Error("expecting to find 3")
+	}
+	if bb.FindConsecutive(0, 0, 2) {
+		t.Error("expecting NOT to find 2 consecutive")
+	}
+	if bb.FindConsecutive(1, 3, 5) {
+		t.Error("expecting NOT to find 5 consecutive")
+	}
+
+	/*
+
+	   _ _ a
+	   a a _
+	   _ _ _
+
+	*/
+	bb = NewBitBoard(3, 3)
+	bb.Add(0, 2)
+	bb.Add(1, 0)
+	bb.Add(1, 1)
+	// The padding bit stops the end of a row running into the next one.
+	if bb.FindConsecutive(1, 0, 3) {
+		t.Error("expecting NOT to find 3 consecutive")
+	}
+}
+
+func Test_Remove(t *testing.T) {
+	for _, finder := range []LineFinder{&PlayerGraph{coins: newCoinMap()}, NewBitBoard(4, 4)} {
+		for row := 0; row < 4; row++ {
+			finder.Add(row, 1)
+		}
+		finder.Remove(1, 1)
+		finder.Remove(3, 3)
+		if finder.Get(1, 1) || !finder.Get(0, 1) || !finder.Get(2, 1) {
+			t.Errorf("expected only the coin at 1, 1 to be removed from %T", finder)
+		}
+		if finder.FindConsecutive(0, 1, 4) || !finder.FindConsecutive(3, 1, 2) {
+			t.Errorf("expected the removed coin to break the line in %T", finder)
+		}
+	}
+}
+
+// randomBoard returns the same coins as a PlayerGraph and a BitBoard.
+func randomBoard(rnd *rand.Rand, rows, cols int, density float64) (*PlayerGraph, *BitBoard) {
+	pg := &PlayerGraph{coins: newCoinMap()}
+	bb := NewBitBoard(rows, cols)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			if rnd.Float64() < density {
+				pg.Add(row, col)
+				bb.Add(row, col)
+			}
+		}
+	}
+	return pg, bb
+}
+
+func Test_BitBoardMatchesPlayerGraph(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, size := range [][2]int{{4, 4}, {6, 7}, {9, 3}, {13, 17}, {20, 20}} {
+		for i := 0; i < 5; i++ {
+			pg, bb := randomBoard(rnd, size[0], size[1], 0.6)
+			for row := 0; row < size[0]; row++ {
+				for col := 0; col < size[1]; col++ {
+					for num := 1; num <= 8; num++ {
+						expected := pg.FindConsecutive(row, col, num)
+						if bb.FindConsecutive(row, col, num) != expected {
+							t.Fatal("expected ", expected, " for ", num, " at ", row, col,
+								" on ", size)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func benchmarkFindConsecutive(b *testing.B, mk func(pg *PlayerGraph, bb *BitBoard) LineFinder) {
+	for _, size := range []int{4, 7, 10, 15, 20} {
+		b.Run(fmt.Sprintf("%dx%d", size, size), func(b *testing.B) {
+			pg, bb := randomBoard(rand.New(rand.NewSource(1)), size, size, 0.5)
+			finder := mk(pg, bb)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				finder.FindConsecutive(i%size, (i/size)%size, 4)
+			}
+		})
+	}
+}
+
+func Benchmark_PlayerGraphFindConsecutive(b *testing.B) {
+	benchmarkFindConsecutive(b, func(pg *PlayerGraph, bb *BitBoard) LineFinder {
+		return pg
+	})
+}
+
+func Benchmark_BitBoardFindConsecutive(b *testing.B) {
+	benchmarkFindConsecutive(b, func(pg *PlayerGraph, bb *BitBoard) LineFinder {
+		return bb
+	})
+}
+
+func Test_winningLines(t *testing.T) {
+	/*
+
+	   a _ _ a
+	   _ a a _
+	   _ a a _
+	   a a a a
+
+	*/
+	pg := &PlayerGraph{coins: newCoinMap(
+		CoinKey{0, 0},
+		CoinKey{0, 3},
+		CoinKey{1, 1},
+		CoinKey{1, 2},
+		CoinKey{2, 1},
+		CoinKey{2, 2},
+		CoinKey{3, 0},
+		CoinKey{3, 1},
+		CoinKey{3, 2},
+		CoinKey{3, 3})}
+
+	// The last coin in the corner completes a row and a diagonal.
+	lines := winningLines(pg, 3, 3, 4)
+	if len(lines) != 2 {
+		t.Fatal("expected 2 lines got ", len(lines))
+	}
+	if lines[0].Line != LeftRight {
+		t.Error("expected LeftRight got", lines[0].Line)
+	}
+	for i, cell := range lines[0].Cells {
+		if cell != (Cell{3, i}) {
+			t.Error("expected cell 3,", i, " got ", cell)
+		}
+	}
+	if lines[1].Line != DiagonalLR_UD {
+		t.Error("expected DiagonalLR_UD got", lines[1].Line)
+	}
+	for i, cell := range lines[1].Cells {
+		if cell != (Cell{i, i}) {
+			t.Error("expected cell ", i, ",", i, " got ", cell)
+		}
+	}
+
+	// Shorter runs are not lines.
+	lines = winningLines(pg, 3, 1, 4)
+	if len(lines) != 1 || lines[0].Line != LeftRight {
+		t.Error("expected only the bottom row got", len(lines))
+	}
+
+	// The same lines are found from a BitBoard.
+	bb := NewBitBoard(4, 4)
+	for coin := range pg.coins {
+		bb.Add(coin.Row, coin.Col)
+	}
+	if len(winningLines(bb, 3, 3, 4)) != 2 {
+		t.Error("expected 2 lines from the bit board")
+	}
+	if len(winningLines(bb, 0, 3, 4)) != 1 {
+		t.Error("expected the DiagonalLR_DU line from the bit board")
+	}
+}
+
+func Test_WrappedFindConsecutive(t *testing.T) {
+	cylinder := Edges{Rows: 5, Cols: 5, Topology: TopologyCylinder}
+	torus := Edges{Rows: 5, Cols: 5, Topology: TopologyTorus}
+	for _, c := range []struct {
+		line  Line
+		coins []CoinKey
+		wraps []Edges
+	}{
+		{LeftRight, []CoinKey{{2, 3}, {2, 4}, {2, 0}, {2, 1}}, []Edges{cylinder, torus}},
+		{DiagonalLR_UD, []CoinKey{{0, 3}, {1, 4}, {2, 0}, {3, 1}}, []Edges{cylinder, torus}},
+		{DiagonalLR_DU, []CoinKey{{0, 1}, {1, 0}, {2, 4}, {3, 3}}, []Edges{cylinder, torus}},
+		{UpDown, []CoinKey{{3, 1}, {4, 1}, {0, 1}, {1, 1}}, []Edges{torus}},
+		{DiagonalLR_UD, []CoinKey{{3, 3}, {4, 4}, {0, 0}, {1, 1}}, []Edges{torus}},
+	} {
+		for _, edges := range []Edges{{}, cylinder, torus} {
+			expected := false
+			for _, wraps := range c.wraps {
+				expected = expected || edges == wraps
+			}
+			pg := NewPlayerGraph(edges)
+			for _, coin := range c.coins[1:] {
+				pg.Add(coin.Row, coin.Col)
+			}
+			// The first coin completes the line from one side of the edge.
+			first := c.coins[0]
+			if pg.FindConsecutive(first.Row, first.Col, 4) != expected {
+				t.Error("expected ", expected, " for ", c.line, " on ", edges.Topology)
+			}
+			pg.Add(first.Row, first.Col)
+			lines := winningLines(pg, first.Row, first.Col, 4)
+			if expected && (len(lines) != 1 || lines[0].Line != c.line || len(lines[0].Cells) != 4) {
+				t.Error("expected the ", c.line, " line on ", edges.Topology, " got ", lines)
+			}
+		}
+	}
+}
+
+func Test_WrappedFullRows(t *testing.T) {
+	// A row all the way around is as long as the board is wide, however it
+	// is walked.
+	pg := NewPlayerGraph(Edges{Rows: 4, Cols: 5, Topology: TopologyCylinder})
+	for col := 0; col < 5; col++ {
+		pg.Add(1, col)
+	}
+	if !pg.FindConsecutive(1, 2, 5) || pg.FindConsecutive(1, 2, 6) {
+		t.Error("expected a line of exactly 5")
+	}
+	lines := winningLines(pg, 1, 2, 5)
+	if len(lines) != 1 || len(lines[0].Cells) != 5 {
+		t.Fatal("expected one line of 5 got ", lines)
+	}
+	for i, cell := range lines[0].Cells {
+		if cell != (Cell{1, (i + 3) % 5}) {
+			t.Error("expected the row from the coin after 1, 2 got ", lines[0].Cells)
+			break
+		}
+	}
+
+	// Diagonals around a 2x3 torus pass every cell.
+	pg = NewPlayerGraph(Edges{Rows: 2, Cols: 3, Topology: TopologyTorus})
+	for row := 0; row < 2; row++ {
+		for col := 0; col < 3; col++ {
+			pg.Add(row, col)
+		}
+	}
+	if !pg.FindConsecutive(0, 0, 6) || pg.FindConsecutive(0, 0, 7) {
+		t.Error("expected diagonals of exactly 6")
+	}
+	lengths := []int{}
+	for _, line := range winningLines(pg, 0, 0, 2) {
+		lengths = append(lengths, len(line.Cells))
+	}
+	if fmt.Sprint(lengths) != "[2 3 6 6]" {
+		t.Error("expected each line once around got ", lengths)
+	}
+}
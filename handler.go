@@ -6,10 +6,18 @@ import (
 )
 
 func writeJSON(w http.ResponseWriter, content []byte) {
+	writeContent(w, "application/json", content)
+}
+
+func writeContent(w http.ResponseWriter, contentType string, content []byte) {
+	writeContentStatus(w, http.StatusOK, contentType, content)
+}
+
+func writeContentStatus(w http.ResponseWriter, status int, contentType string, content []byte) {
 	w.Header().Add("Cache-Control", "no-cache, no-store, must-revalidate")
 	w.Header().Add("Content-Length", fmt.Sprintf("%d", len(content)))
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.Header().Add("Content-Type", contentType)
+	w.WriteHeader(status)
 	w.Write(content)
 }
 
@@ -18,16 +26,16 @@ func gameHandler(w http.ResponseWriter, r *http.Request) {
 	var APIerr *APIError
 
 	if r.Method == "GET" {
-		content, APIerr = API_getGameList()
+		content, APIerr = API_getGameList(r)
 	} else if r.Method == "POST" {
 		content, APIerr = API_createGame(r)
 	} else {
-		APIerr = &APIError{"method not allowed", 400}
+		APIerr = mkAPIError(http.StatusMethodNotAllowed, ErrMethodNotAllowed, "method not allowed")
 	}
 
 	if APIerr != nil {
 		LOGGER.Println(fmt.Sprintf("error in game handler %s", APIerr))
-		http.Error(w, APIerr.Msg, APIerr.Status)
+		writeError(w, APIerr)
 		return
 	}
 	writeJSON(w, content)
@@ -36,22 +44,164 @@ func gameHandler(w http.ResponseWriter, r *http.Request) {
 func gameStatusHandler(w http.ResponseWriter, r *http.Request) {
 	var content []byte
 	var APIerr *APIError
-	content, APIerr = API_gameStatus(r)
+	if r.Method == "POST" {
+		// Claim an open seat.
+		content, APIerr = API_joinGame(r)
+	} else {
+		content, APIerr = API_gameStatus(r)
+	}
+	if APIerr != nil {
+		LOGGER.Println(fmt.Sprintf("error in game status handler %s", APIerr.Msg))
+		writeError(w, APIerr)
+		return
+	}
+	writeJSON(w, content)
+}
+
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	content, APIerr := API_stats()
+	if APIerr != nil {
+		LOGGER.Println(fmt.Sprintf("error getting stats %s", APIerr.Msg))
+		writeError(w, APIerr)
+		return
+	}
+	writeJSON(w, content)
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	writeContent(w, METRICS_CONTENT_TYPE, Metrics())
+}
+
+func recordHandler(w http.ResponseWriter, r *http.Request) {
+	content, APIerr := API_exportGame(r)
+	if APIerr != nil {
+		LOGGER.Println(fmt.Sprintf("error exporting game %s", APIerr.Msg))
+		writeError(w, APIerr)
+		return
+	}
+	writeContent(w, NOTATION_CONTENT_TYPE, content)
+}
+
+func importHandler(w http.ResponseWriter, r *http.Request) {
+	content, APIerr := API_importGame(r)
+	if APIerr != nil {
+		LOGGER.Println(fmt.Sprintf("error importing game %s", APIerr.Msg))
+		writeError(w, APIerr)
+		return
+	}
+	writeJSON(w, content)
+}
+
+func playerHandler(w http.ResponseWriter, r *http.Request) {
+	content, APIerr := API_getPlayer(r)
 	if APIerr != nil {
-		LOGGER.Println(fmt.Sprintf("error getting game status %s", APIerr.Msg))
-		http.Error(w, APIerr.Msg, APIerr.Status)
+		LOGGER.Println(fmt.Sprintf("error getting player %s", APIerr.Msg))
+		writeError(w, APIerr)
 		return
 	}
 	writeJSON(w, content)
 }
 
+func leaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	content, APIerr := API_leaderboard(r)
+	if APIerr != nil {
+		LOGGER.Println(fmt.Sprintf("error getting leaderboard %s", APIerr.Msg))
+		writeError(w, APIerr)
+		return
+	}
+	writeJSON(w, content)
+}
+
+// matchmakingHandler queues a player for a game. The ticket is 200 if they
+// were matched straight away, else 202 while they wait.
+func matchmakingHandler(w http.ResponseWriter, r *http.Request) {
+	content, matched, APIerr := API_joinMatchmaking(r)
+	if APIerr != nil {
+		LOGGER.Println(fmt.Sprintf("error joining matchmaking %s", APIerr.Msg))
+		writeError(w, APIerr)
+		return
+	}
+	status := http.StatusAccepted
+	if matched {
+		status = http.StatusOK
+	}
+	writeContentStatus(w, status, "application/json", content)
+}
+
+// ticketHandler returns (GET) or gives up (DELETE) a matchmaking ticket.
+func ticketHandler(w http.ResponseWriter, r *http.Request) {
+	var content []byte
+	var APIerr *APIError
+	if r.Method == "DELETE" {
+		APIerr = API_leaveMatchmaking(r)
+	} else {
+		content, APIerr = API_getTicket(r)
+	}
+	if APIerr != nil {
+		LOGGER.Println(fmt.Sprintf("error in ticket handler %s", APIerr.Msg))
+		writeError(w, APIerr)
+		return
+	}
+	if r.Method == "DELETE" {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	writeJSON(w, content)
+}
+
+// takebackHandler asks for, accepts or declines a takeback, replying 202 on
+// success.
+func takebackHandler(w http.ResponseWriter, r *http.Request) {
+	APIerr := API_takeback(r)
+	if APIerr != nil {
+		LOGGER.Println(fmt.Sprintf("error in takeback handler %s", APIerr.Msg))
+		if APIerr.Status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		writeError(w, APIerr)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func takebackListHandler(w http.ResponseWriter, r *http.Request) {
+	content, APIerr := API_takebackList(r)
+	if APIerr != nil {
+		LOGGER.Println(fmt.Sprintf("error getting takebacks %s", APIerr.Msg))
+		writeError(w, APIerr)
+		return
+	}
+	writeJSON(w, content)
+}
+
+func inviteHandler(w http.ResponseWriter, r *http.Request) {
+	content, APIerr := API_acceptInvite(r)
+	if APIerr != nil {
+		LOGGER.Println(fmt.Sprintf("error accepting invite %s", APIerr.Msg))
+		writeError(w, APIerr)
+		return
+	}
+	writeJSON(w, content)
+}
+
+func boardHandler(w http.ResponseWriter, r *http.Request) {
+	content, format, APIerr := API_getBoard(r)
+	if APIerr != nil {
+		LOGGER.Println(fmt.Sprintf("error getting board %s", APIerr.Msg))
+		writeError(w, APIerr)
+		return
+	}
+	w.Header().Add("Vary", "Accept")
+	writeContent(w, BOARD_CONTENT_TYPES[format], content)
+}
+
 func moveListHandler(w http.ResponseWriter, r *http.Request) {
 	var content []byte
 	var APIerr *APIError
 	content, APIerr = API_moveList(r)
 	if APIerr != nil {
 		LOGGER.Println(fmt.Sprintf("error getting move list %s", APIerr.Msg))
-		http.Error(w, APIerr.Msg, APIerr.Status)
+		writeError(w, APIerr)
 		return
 	}
 	writeJSON(w, content)
@@ -63,27 +213,35 @@ func moveHandler(w http.ResponseWriter, r *http.Request) {
 	content, APIerr = API_getMove(r)
 	if APIerr != nil {
 		LOGGER.Println(fmt.Sprintf("error getting move %s", APIerr.Msg))
-		http.Error(w, APIerr.Msg, APIerr.Status)
+		writeError(w, APIerr)
 		return
 	}
 	writeJSON(w, content)
 }
 
 func playHandler(w http.ResponseWriter, r *http.Request) {
-	status := http.StatusBadRequest
+	var content []byte
+	var APIerr *APIError
 	if r.Method == "DELETE" {
 		// Quit game.
-		status = API_quitGame(r)
+		APIerr = API_quitGame(r)
 	} else if r.Method == "POST" {
 		// Make move.
-		content, APIerr := API_makeMove(r)
-		if APIerr != nil {
-			LOGGER.Println(fmt.Sprintf("error quiting game %s", APIerr.Msg))
-			http.Error(w, APIerr.Msg, APIerr.Status)
-			return
+		content, APIerr = API_makeMove(r)
+	} else {
+		APIerr = mkAPIError(http.StatusMethodNotAllowed, ErrMethodNotAllowed, "method not allowed")
+	}
+	if APIerr != nil {
+		LOGGER.Println(fmt.Sprintf("error playing game %s", APIerr.Msg))
+		if APIerr.Status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", "Bearer")
 		}
-		writeJSON(w, content)
+		writeError(w, APIerr)
 		return
 	}
-	w.WriteHeader(status)
+	if r.Method == "DELETE" {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	writeJSON(w, content)
 }
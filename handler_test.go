@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -17,6 +19,10 @@ func mockUUID() string {
 	return "cats"
 }
 
+func mockToken() string {
+	return "secret"
+}
+
 func expectWithWriter(w *httptest.ResponseRecorder, status int, expected string) error {
 	resp := w.Result()
 	body, _ := ioutil.ReadAll(resp.Body)
@@ -37,8 +43,11 @@ func TestMain(m *testing.M) {
 	flag.Parse()
 	old := mkGameId
 	mkGameId = mockUUID
+	oldToken := mkToken
+	mkToken = mockToken
 	m.Run()
 	mkGameId = old
+	mkToken = oldToken
 }
 
 func apiURL(resource string) string {
@@ -56,7 +65,7 @@ func Test_gameHandler(t *testing.T) {
 	w := httptest.NewRecorder()
 
 	gameHandler(w, r)
-	err := expectWithWriter(w, http.StatusOK, `{"gameId":"cats"}`)
+	err := expectWithWriter(w, http.StatusOK, `{"gameId":"cats","tokens":{"a":"secret","b":"secret"}}`)
 	if err != nil {
 		t.Error(err)
 	}
@@ -77,7 +86,7 @@ func Test_gameHandler(t *testing.T) {
 	w = httptest.NewRecorder()
 
 	gameHandler(w, r)
-	err = expectWithWriter(w, http.StatusBadRequest, `malformed input`)
+	err = expectWithWriter(w, http.StatusBadRequest, `{"error":{"code":"MALFORMED_INPUT","message":"malformed input"}}`)
 	if err != nil {
 		t.Error(err)
 	}
@@ -88,7 +97,40 @@ func Test_gameHandler(t *testing.T) {
 	w = httptest.NewRecorder()
 
 	gameHandler(w, r)
-	err = expectWithWriter(w, http.StatusBadRequest, `num players is not 2`)
+	err = expectWithWriter(w, http.StatusBadRequest, `{"error":{"code":"INVALID_RULES","message":"players must be between 2 and 4, got 1","field":"players"}}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// duplicate player
+	createGameBlob = strings.NewReader(`{"players": ["a", "a"], "rows": 4, "columns": 4}`)
+	r = httptest.NewRequest("POST", apiURL(""), createGameBlob)
+	w = httptest.NewRecorder()
+
+	gameHandler(w, r)
+	err = expectWithWriter(w, http.StatusBadRequest, `{"error":{"code":"INVALID_PLAYERS","message":"duplicate player a","field":"players"}}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// unknown bot level
+	createGameBlob = strings.NewReader(`{"players": ["a", "bot:genius"]}`)
+	r = httptest.NewRequest("POST", apiURL(""), createGameBlob)
+	w = httptest.NewRecorder()
+
+	gameHandler(w, r)
+	err = expectWithWriter(w, http.StatusBadRequest, `{"error":{"code":"INVALID_PLAYERS","message":"unknown bot bot:genius","field":"players"}}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// only bots
+	createGameBlob = strings.NewReader(`{"players": ["bot:easy", "bot:hard"]}`)
+	r = httptest.NewRequest("POST", apiURL(""), createGameBlob)
+	w = httptest.NewRecorder()
+
+	gameHandler(w, r)
+	err = expectWithWriter(w, http.StatusBadRequest, `{"error":{"code":"INVALID_PLAYERS","message":"at least one player must not be a bot","field":"players"}}`)
 	if err != nil {
 		t.Error(err)
 	}
@@ -99,8 +141,7 @@ func Test_gameHandler(t *testing.T) {
 	w = httptest.NewRecorder()
 
 	gameHandler(w, r)
-	err = expectWithWriter(w, http.StatusBadRequest,
-		`expecting 4 rows and 4 columns, got rows 30 cols 4`)
+	err = expectWithWriter(w, http.StatusBadRequest, `{"error":{"code":"INVALID_RULES","message":"rows must be between 3 and 20, got 30","field":"rows"}}`)
 	if err != nil {
 		t.Error(err)
 	}
@@ -111,12 +152,70 @@ func Test_gameHandler(t *testing.T) {
 	w = httptest.NewRecorder()
 
 	gameHandler(w, r)
-	err = expectWithWriter(w, http.StatusBadRequest,
-		`expecting 4 rows and 4 columns, got rows 4 cols 30`)
+	err = expectWithWriter(w, http.StatusBadRequest, `{"error":{"code":"INVALID_RULES","message":"columns must be between 3 and 20, got 30","field":"columns"}}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// win length longer than the board
+	createGameBlob = strings.NewReader(
+		`{"players": ["a", "b"], "rows": 4, "columns": 5, "winLength": 6}`)
+	r = httptest.NewRequest("POST", apiURL(""), createGameBlob)
+	w = httptest.NewRecorder()
+
+	gameHandler(w, r)
+	err = expectWithWriter(w, http.StatusBadRequest, `{"error":{"code":"INVALID_RULES","message":"winLength 6 does not fit on a 4x5 board","field":"winLength"}}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// per game rules
+	createGameBlob = strings.NewReader(
+		`{"players": ["a", "b", "c"], "rows": 6, "columns": 7, "winLength": 5}`)
+	r = httptest.NewRequest("POST", apiURL(""), createGameBlob)
+	w = httptest.NewRecorder()
+
+	gameHandler(w, r)
+	err = expectWithWriter(w, http.StatusOK,
+		`{"gameId":"cats","tokens":{"a":"secret","b":"secret","c":"secret"}}`)
 	if err != nil {
 		t.Error(err)
 	}
+	g, _ := GAMES.Get("cats")
+	if g.rules != (Rules{Rows: 6, Columns: 7, WinLength: 5, Players: 3}) {
+		t.Error("expected 6x7 rules with a win length of 5 got", g.rules)
+	}
+	if len(g.board) != 6 || len(g.board[0]) != 7 {
+		t.Error("expected a 6x7 board")
+	}
+	if g.sequentialWin != 5 {
+		t.Error("expected sequentialWin to be 5 got", g.sequentialWin)
+	}
 
+	// players named by team
+	createGameBlob = strings.NewReader(`{"teamPlayers": [["a", "b"], ["c", "d"]], "rows": 4, "columns": 4}`)
+	r = httptest.NewRequest("POST", apiURL(""), createGameBlob)
+	w = httptest.NewRecorder()
+
+	gameHandler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatal("expected the game to be created got ", w.Body.String())
+	}
+	g, _ = GAMES.Get("cats")
+	if strings.Join(g.playerList, ",") != "a,c,b,d" || g.rules.Teams != 2 || g.side("a") != g.side("b") {
+		t.Error("expected a and b to play c and d got ", g.playerList, g.teams())
+	}
+
+	createGameBlob = strings.NewReader(`{"teamPlayers": [["a", "b"], ["c"]], "rows": 4, "columns": 4}`)
+	r = httptest.NewRequest("POST", apiURL(""), createGameBlob)
+	w = httptest.NewRecorder()
+
+	gameHandler(w, r)
+	err = expectWithWriter(w, http.StatusBadRequest,
+		`{"error":{"code":"INVALID_PLAYERS","message":"team 1 has 1 players, team 0 has 2","field":"teamPlayers"}}`)
+	if err != nil {
+		t.Error(err)
+	}
 }
 
 func Test_gameStatusHandler(t *testing.T) {
@@ -130,7 +229,8 @@ func Test_gameStatusHandler(t *testing.T) {
 	w := httptest.NewRecorder()
 	gameStatusHandler(w, r)
 
-	err := expectWithWriter(w, http.StatusOK, `{"players":["a","b"],"state":"IN_PROGRESS"}`)
+	err := expectWithWriter(w, http.StatusOK, `{"players":["a","b"],"state":"IN_PROGRESS",`+
+		`"rules":{"rows":4,"columns":4,"winLength":4,"players":2}}`)
 	if err != nil {
 		t.Error(err)
 	}
@@ -146,7 +246,9 @@ func Test_gameStatusHandler(t *testing.T) {
 	w = httptest.NewRecorder()
 	gameStatusHandler(w, r)
 
-	err = expectWithWriter(w, http.StatusOK, `{"players":["a","b"],"state":"DONE"}`)
+	err = expectWithWriter(w, http.StatusOK, `{"players":["a","b"],"state":"DONE",`+
+		`"rules":{"rows":4,"columns":4,"winLength":4,"players":2},`+
+		`"ranking":[{"player":"a","rank":1},{"player":"b","rank":1}]}`)
 	if err != nil {
 		t.Error(err)
 	}
@@ -162,7 +264,7 @@ func Test_gameStatusHandler(t *testing.T) {
 	w = httptest.NewRecorder()
 	gameStatusHandler(w, r)
 
-	err = expectWithWriter(w, http.StatusNotFound, `unknown game`)
+	err = expectWithWriter(w, http.StatusNotFound, `{"error":{"code":"UNKNOWN_GAME","message":"unknown game","gameId":"dogs"}}`)
 	if err != nil {
 		t.Error(err)
 	}
@@ -184,7 +286,47 @@ func Test_gameStatusHandler(t *testing.T) {
 	w = httptest.NewRecorder()
 	gameStatusHandler(w, r)
 
-	err = expectWithWriter(w, http.StatusOK, `{"players":["a","b"],"state":"DONE","winner":"a"}`)
+	err = expectWithWriter(w, http.StatusOK, `{"players":["a","b"],"state":"DONE","winner":"a",`+
+		`"rules":{"rows":4,"columns":4,"winLength":4,"players":2},`+
+		`"ranking":[{"player":"a","rank":1},{"player":"b","rank":2}],`+
+		`"winningLines":[{"line":"UpDown","cells":[{"row":0,"column":3},{"row":1,"column":3},`+
+		`{"row":2,"column":3},{"row":3,"column":3}]}]}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// The winning move carries the line too.
+	r = httptest.NewRequest("GET", apiURL("cats/moves/6"), nil)
+	r = mux.SetURLVars(r, map[string]string{"gameId": "cats", "move_number": "6"})
+
+	w = httptest.NewRecorder()
+	moveHandler(w, r)
+	err = expectWithWriter(w, http.StatusOK, `{"type":"MOVE","player":"a","row":0,"column":3,`+
+		`"winningLines":[{"line":"UpDown","cells":[{"row":0,"column":3},{"row":1,"column":3},`+
+		`{"row":2,"column":3},{"row":3,"column":3}]}]}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// Check team winner response
+	g = CreateGameWithRules(&Rules{Rows: 4, Columns: 4, WinLength: 4, Players: 4, Teams: 2}, "a", "b", "c", "d")
+	GAMES.Add(g)
+	for _, col := range []int{0, 0, 1, 1, 2, 2, 3} {
+		g.Move(g.nextMove(), col)
+	}
+
+	r = httptest.NewRequest("GET", apiURL("cats"), nil)
+	r = mux.SetURLVars(r, map[string]string{"gameId": "cats"})
+
+	w = httptest.NewRecorder()
+	gameStatusHandler(w, r)
+
+	err = expectWithWriter(w, http.StatusOK, `{"players":["a","b","c","d"],"state":"DONE","winner":"c",`+
+		`"rules":{"rows":4,"columns":4,"winLength":4,"players":4,"teams":2},`+
+		`"teams":[["a","c"],["b","d"]],"winningTeam":["a","c"],`+
+		`"ranking":[{"player":"a","rank":1},{"player":"c","rank":1},{"player":"b","rank":3},{"player":"d","rank":3}],`+
+		`"winningLines":[{"line":"LeftRight","cells":[{"row":3,"column":0},{"row":3,"column":1},`+
+		`{"row":3,"column":2},{"row":3,"column":3}]}]}`)
 	if err != nil {
 		t.Error(err)
 	}
@@ -216,7 +358,7 @@ func Test_moveListHandler(t *testing.T) {
 	w = httptest.NewRecorder()
 	moveListHandler(w, r)
 	err = expectWithWriter(w, http.StatusOK,
-		`{"moves":[{"type":"MOVE","player":"a","column":3},{"type":"MOVE","player":"b","column":1}]}`)
+		`{"moves":[{"type":"MOVE","player":"a","row":3,"column":3},{"type":"MOVE","player":"b","row":3,"column":1}]}`)
 	if err != nil {
 		t.Error(err)
 	}
@@ -235,7 +377,7 @@ func Test_moveListHandler(t *testing.T) {
 	w = httptest.NewRecorder()
 	moveListHandler(w, r)
 	err = expectWithWriter(w, http.StatusOK,
-		`{"moves":[{"type":"MOVE","player":"b","column":1},{"type":"MOVE","player":"a","column":2}]}`)
+		`{"moves":[{"type":"MOVE","player":"b","row":3,"column":1},{"type":"MOVE","player":"a","row":3,"column":2}]}`)
 	if err != nil {
 		t.Error(err)
 	}
@@ -246,7 +388,7 @@ func Test_moveListHandler(t *testing.T) {
 
 	w = httptest.NewRecorder()
 	moveListHandler(w, r)
-	err = expectWithWriter(w, http.StatusNotFound, `game not found`)
+	err = expectWithWriter(w, http.StatusNotFound, `{"error":{"code":"UNKNOWN_GAME","message":"game not found","gameId":"dogs"}}`)
 	if err != nil {
 		t.Error(err)
 	}
@@ -257,7 +399,7 @@ func Test_moveListHandler(t *testing.T) {
 
 	w = httptest.NewRecorder()
 	moveListHandler(w, r)
-	err = expectWithWriter(w, http.StatusBadRequest, `invalid start conversion`)
+	err = expectWithWriter(w, http.StatusBadRequest, `{"error":{"code":"INVALID_PARAMETER","message":"invalid start conversion","field":"start","gameId":"cats"}}`)
 	if err != nil {
 		t.Error(err)
 	}
@@ -268,7 +410,7 @@ func Test_moveListHandler(t *testing.T) {
 
 	w = httptest.NewRecorder()
 	moveListHandler(w, r)
-	err = expectWithWriter(w, http.StatusBadRequest, `invalid until conversion`)
+	err = expectWithWriter(w, http.StatusBadRequest, `{"error":{"code":"INVALID_PARAMETER","message":"invalid until conversion","field":"until","gameId":"cats"}}`)
 	if err != nil {
 		t.Error(err)
 	}
@@ -279,7 +421,100 @@ func Test_moveListHandler(t *testing.T) {
 
 	w = httptest.NewRecorder()
 	moveListHandler(w, r)
-	err = expectWithWriter(w, http.StatusBadRequest, `bad range request`)
+	err = expectWithWriter(w, http.StatusBadRequest, `{"error":{"code":"INVALID_PARAMETER","message":"bad range request","field":"start","gameId":"cats"}}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// range past the last move
+	r = httptest.NewRequest("GET", apiURL("/cats/moves?start=6&until=8"), nil)
+	r = mux.SetURLVars(r, map[string]string{"gameId": "cats"})
+
+	w = httptest.NewRecorder()
+	moveListHandler(w, r)
+	err = expectWithWriter(w, http.StatusOK, `{"moves":[]}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// moves since
+	r = httptest.NewRequest("GET", apiURL("/cats/moves?since=3"), nil)
+	r = mux.SetURLVars(r, map[string]string{"gameId": "cats"})
+
+	w = httptest.NewRecorder()
+	moveListHandler(w, r)
+	err = expectWithWriter(w, http.StatusOK, `{"moves":[{"type":"MOVE","player":"b","row":2,"column":2}]}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// bad since
+	r = httptest.NewRequest("GET", apiURL("/cats/moves?since=Y"), nil)
+	r = mux.SetURLVars(r, map[string]string{"gameId": "cats"})
+
+	w = httptest.NewRecorder()
+	moveListHandler(w, r)
+	err = expectWithWriter(w, http.StatusBadRequest, `{"error":{"code":"INVALID_PARAMETER","message":"invalid since conversion","field":"since","gameId":"cats"}}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// since with a range
+	r = httptest.NewRequest("GET", apiURL("/cats/moves?since=1&start=1"), nil)
+	r = mux.SetURLVars(r, map[string]string{"gameId": "cats"})
+
+	w = httptest.NewRecorder()
+	moveListHandler(w, r)
+	err = expectWithWriter(w, http.StatusBadRequest,
+		`{"error":{"code":"INVALID_PARAMETER","message":"since cannot be combined with start or until","field":"since","gameId":"cats"}}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// wait without since
+	r = httptest.NewRequest("GET", apiURL("/cats/moves?wait=1s"), nil)
+	r = mux.SetURLVars(r, map[string]string{"gameId": "cats"})
+
+	w = httptest.NewRecorder()
+	moveListHandler(w, r)
+	err = expectWithWriter(w, http.StatusBadRequest, `{"error":{"code":"INVALID_PARAMETER","message":"wait requires since","field":"wait","gameId":"cats"}}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// bad wait
+	r = httptest.NewRequest("GET", apiURL("/cats/moves?since=4&wait=soon"), nil)
+	r = mux.SetURLVars(r, map[string]string{"gameId": "cats"})
+
+	w = httptest.NewRecorder()
+	moveListHandler(w, r)
+	err = expectWithWriter(w, http.StatusBadRequest, `{"error":{"code":"INVALID_PARAMETER","message":"invalid wait conversion","field":"wait","gameId":"cats"}}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// wait times out with no new moves
+	r = httptest.NewRequest("GET", apiURL("/cats/moves?since=4&wait=10ms"), nil)
+	r = mux.SetURLVars(r, map[string]string{"gameId": "cats"})
+
+	w = httptest.NewRecorder()
+	moveListHandler(w, r)
+	err = expectWithWriter(w, http.StatusOK, `{"moves":[]}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// wait for the next move
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		g.Move("a", 1)
+	}()
+	r = httptest.NewRequest("GET", apiURL("/cats/moves?since=4&wait=5"), nil)
+	r = mux.SetURLVars(r, map[string]string{"gameId": "cats"})
+
+	w = httptest.NewRecorder()
+	moveListHandler(w, r)
+	err = expectWithWriter(w, http.StatusOK, `{"moves":[{"type":"MOVE","player":"a","row":2,"column":1}]}`)
 	if err != nil {
 		t.Error(err)
 	}
@@ -297,7 +532,7 @@ func Test_moveHandler(t *testing.T) {
 
 	w := httptest.NewRecorder()
 	moveHandler(w, r)
-	err := expectWithWriter(w, http.StatusOK, `{"type":"MOVE","player":"b","column":1}`)
+	err := expectWithWriter(w, http.StatusOK, `{"type":"MOVE","player":"b","row":3,"column":1}`)
 	if err != nil {
 		t.Error(err)
 	}
@@ -308,7 +543,7 @@ func Test_moveHandler(t *testing.T) {
 
 	w = httptest.NewRecorder()
 	moveHandler(w, r)
-	err = expectWithWriter(w, http.StatusNotFound, `game not found`)
+	err = expectWithWriter(w, http.StatusNotFound, `{"error":{"code":"UNKNOWN_GAME","message":"game not found","gameId":"DOGS"}}`)
 	if err != nil {
 		t.Error(err)
 	}
@@ -319,7 +554,7 @@ func Test_moveHandler(t *testing.T) {
 
 	w = httptest.NewRecorder()
 	moveHandler(w, r)
-	err = expectWithWriter(w, http.StatusNotFound, `invalid index`)
+	err = expectWithWriter(w, http.StatusNotFound, `{"error":{"code":"UNKNOWN_MOVE","message":"invalid index","gameId":"cats","move":999}}`)
 	if err != nil {
 		t.Error(err)
 	}
@@ -330,7 +565,7 @@ func Test_moveHandler(t *testing.T) {
 
 	w = httptest.NewRecorder()
 	moveHandler(w, r)
-	err = expectWithWriter(w, http.StatusBadRequest, `unable to parse move number`)
+	err = expectWithWriter(w, http.StatusBadRequest, `{"error":{"code":"INVALID_PARAMETER","message":"unable to parse move number","field":"move_number","gameId":"cats"}}`)
 	if err != nil {
 		t.Error(err)
 	}
@@ -344,6 +579,7 @@ func Test_playHandler(t *testing.T) {
 	r := httptest.NewRequest("POST", apiURL("cats/a"), playGameBlob)
 
 	r = mux.SetURLVars(r, map[string]string{"gameId": "cats", "playerId": "a"})
+	r.Header.Set("Authorization", "Bearer secret")
 
 	w := httptest.NewRecorder()
 	playHandler(w, r)
@@ -356,6 +592,7 @@ func Test_playHandler(t *testing.T) {
 	r = httptest.NewRequest("POST", apiURL("cats/b"), playGameBlob)
 
 	r = mux.SetURLVars(r, map[string]string{"gameId": "cats", "playerId": "b"})
+	r.Header.Set("Authorization", "Bearer secret")
 	w = httptest.NewRecorder()
 
 	playHandler(w, r)
@@ -372,7 +609,7 @@ func Test_playHandler(t *testing.T) {
 	w = httptest.NewRecorder()
 
 	playHandler(w, r)
-	err = expectWithWriter(w, http.StatusNotFound, "unknown game")
+	err = expectWithWriter(w, http.StatusNotFound, `{"error":{"code":"UNKNOWN_GAME","message":"unknown game","gameId":"dogs"}}`)
 	if err != nil {
 		t.Error(err)
 	}
@@ -382,10 +619,11 @@ func Test_playHandler(t *testing.T) {
 	r = httptest.NewRequest("POST", apiURL("cats/b"), playGameBlob)
 
 	r = mux.SetURLVars(r, map[string]string{"gameId": "cats", "playerId": "b"})
+	r.Header.Set("Authorization", "Bearer secret")
 
 	w = httptest.NewRecorder()
 	playHandler(w, r)
-	err = expectWithWriter(w, http.StatusConflict, `WRONG_TURN`)
+	err = expectWithWriter(w, http.StatusConflict, `{"error":{"code":"WRONG_TURN","message":"it is not b's turn","gameId":"cats","move":2}}`)
 	if err != nil {
 		t.Error(err)
 	}
@@ -397,7 +635,7 @@ func Test_playHandler(t *testing.T) {
 
 	w = httptest.NewRecorder()
 	playHandler(w, r)
-	err = expectWithWriter(w, http.StatusBadRequest, `WRONG_GAME`)
+	err = expectWithWriter(w, http.StatusBadRequest, `{"error":{"code":"WRONG_GAME","message":"dog is not playing this game","gameId":"cats","move":2}}`)
 	if err != nil {
 		t.Error(err)
 	}
@@ -406,10 +644,11 @@ func Test_playHandler(t *testing.T) {
 	playGameBlob = strings.NewReader(`{"column" : "forty-two"}`)
 	r = httptest.NewRequest("POST", apiURL("cats/b"), playGameBlob)
 	r = mux.SetURLVars(r, map[string]string{"gameId": "cats", "playerId": "b"})
+	r.Header.Set("Authorization", "Bearer secret")
 
 	w = httptest.NewRecorder()
 	playHandler(w, r)
-	err = expectWithWriter(w, http.StatusBadRequest, `malformed input`)
+	err = expectWithWriter(w, http.StatusBadRequest, `{"error":{"code":"MALFORMED_INPUT","message":"malformed input","field":"column","gameId":"cats"}}`)
 	if err != nil {
 		t.Error(err)
 	}
@@ -418,10 +657,11 @@ func Test_playHandler(t *testing.T) {
 	playGameBlob = strings.NewReader(`{"column" : -1}`)
 	r = httptest.NewRequest("POST", apiURL("cats/b"), playGameBlob)
 	r = mux.SetURLVars(r, map[string]string{"gameId": "cats", "playerId": "b"})
+	r.Header.Set("Authorization", "Bearer secret")
 
 	w = httptest.NewRecorder()
 	playHandler(w, r)
-	err = expectWithWriter(w, http.StatusBadRequest, `BAD_REQUEST`)
+	err = expectWithWriter(w, http.StatusBadRequest, `{"error":{"code":"BAD_REQUEST","message":"column must be between 0 and 3, got -1","field":"column","gameId":"cats","move":2}}`)
 	if err != nil {
 		t.Error(err)
 	}
@@ -430,6 +670,7 @@ func Test_playHandler(t *testing.T) {
 	r = httptest.NewRequest("DELETE", apiURL("cats/b"), nil)
 
 	r = mux.SetURLVars(r, map[string]string{"gameId": "cats", "playerId": "b"})
+	r.Header.Set("Authorization", "Bearer secret")
 
 	w = httptest.NewRecorder()
 	playHandler(w, r)
@@ -444,7 +685,7 @@ func Test_playHandler(t *testing.T) {
 
 	w = httptest.NewRecorder()
 	playHandler(w, r)
-	err = expectWithWriter(w, http.StatusNotFound, ``)
+	err = expectWithWriter(w, http.StatusNotFound, `{"error":{"code":"NOT_A_PLAYER","message":"dogs is not playing this game","gameId":"cats"}}`)
 	if err != nil {
 		t.Error(err)
 	}
@@ -455,7 +696,7 @@ func Test_playHandler(t *testing.T) {
 
 	w = httptest.NewRecorder()
 	playHandler(w, r)
-	err = expectWithWriter(w, http.StatusNotFound, ``)
+	err = expectWithWriter(w, http.StatusNotFound, `{"error":{"code":"UNKNOWN_GAME","message":"unknown game","gameId":"dogs"}}`)
 	if err != nil {
 		t.Error(err)
 	}
@@ -464,10 +705,11 @@ func Test_playHandler(t *testing.T) {
 	r = httptest.NewRequest("DELETE", apiURL("cats/b"), nil)
 
 	r = mux.SetURLVars(r, map[string]string{"gameId": "cats", "playerId": "b"})
+	r.Header.Set("Authorization", "Bearer secret")
 
 	w = httptest.NewRecorder()
 	playHandler(w, r)
-	err = expectWithWriter(w, http.StatusGone, ``)
+	err = expectWithWriter(w, http.StatusGone, `{"error":{"code":"GAME_OVER","message":"game is over","gameId":"cats"}}`)
 	if err != nil {
 		t.Error(err)
 	}
@@ -476,11 +718,388 @@ func Test_playHandler(t *testing.T) {
 	r = httptest.NewRequest("DELETE", apiURL("cats/a"), nil)
 
 	r = mux.SetURLVars(r, map[string]string{"gameId": "cats", "playerId": "a"})
+	r.Header.Set("Authorization", "Bearer secret")
+
+	w = httptest.NewRecorder()
+	playHandler(w, r)
+	err = expectWithWriter(w, http.StatusGone, `{"error":{"code":"GAME_OVER","message":"game is over","gameId":"cats"}}`)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_playHandlerPopOut(t *testing.T) {
+	g := CreateGameWithRules(&Rules{Rows: 4, Columns: 4, WinLength: 4, Players: 2, PopOut: true}, "a", "b")
+	GAMES.Add(g)
+	g.Move("a", 1)
+	g.Move("b", 2)
+
+	for _, c := range []struct {
+		body     string
+		status   int
+		expected string
+	}{
+		{`{"column": 2, "type": "POP"}`, http.StatusBadRequest,
+			`{"error":{"code":"CANNOT_POP","message":"a has no coin at the bottom of column 2","field":"column","gameId":"cats","move":2}}`},
+		{`{"column": 1, "type": "PUSH"}`, http.StatusBadRequest,
+			`{"error":{"code":"INVALID_PARAMETER","message":"type must be MOVE or POP, got PUSH","field":"type","gameId":"cats"}}`},
+		{`{"column": 1, "type": "POP"}`, http.StatusOK, `{"move":"cats/moves/2"}`},
+	} {
+		r := httptest.NewRequest("POST", apiURL("cats/a"), strings.NewReader(c.body))
+		r = mux.SetURLVars(r, map[string]string{"gameId": "cats", "playerId": "a"})
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		playHandler(w, r)
+		if err := expectWithWriter(w, c.status, c.expected); err != nil {
+			t.Error(err)
+		}
+	}
+	if move, _ := g.GetMove(2); mkMoveResponse(move).Type != MovePop || *mkMoveResponse(move).Column != 1 {
+		t.Error("expected the pop in the move list")
+	}
+
+	g = CreateGame(4, 4, 4, "a", "b")
+	GAMES.Add(g)
+	g.Move("a", 1)
+	g.Move("b", 2)
+	r := httptest.NewRequest("POST", apiURL("cats/a"), strings.NewReader(`{"column": 1, "type": "POP"}`))
+	r = mux.SetURLVars(r, map[string]string{"gameId": "cats", "playerId": "a"})
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	playHandler(w, r)
+	err := expectWithWriter(w, http.StatusBadRequest,
+		`{"error":{"code":"CANNOT_POP","message":"this game is not played with pop out","field":"type","gameId":"cats","move":2}}`)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_playHandlerFreePlacement(t *testing.T) {
+	g := CreateGameWithRules(&Rules{Rows: 3, Columns: 3, WinLength: 3, Players: 2, FreePlacement: true}, "a", "b")
+	GAMES.Add(g)
+
+	for _, c := range []struct {
+		player   string
+		body     string
+		status   int
+		expected string
+	}{
+		{"a", `{"row": 0, "column": 2}`, http.StatusOK, `{"move":"cats/moves/0"}`},
+		{"b", `{"column": 2}`, http.StatusBadRequest,
+			`{"error":{"code":"INVALID_PARAMETER","message":"row is required when coins are placed freely","field":"row","gameId":"cats"}}`},
+		{"b", `{"row": 0, "column": 2}`, http.StatusBadRequest,
+			`{"error":{"code":"BAD_REQUEST","message":"row 0 of column 2 is taken","field":"column","gameId":"cats","move":1}}`},
+		{"b", `{"row": 3, "column": 2}`, http.StatusBadRequest,
+			`{"error":{"code":"BAD_REQUEST","message":"row must be between 0 and 2, got 3","field":"row","gameId":"cats","move":1}}`},
+		{"b", `{"row": 0, "column": 0}`, http.StatusOK, `{"move":"cats/moves/1"}`},
+	} {
+		r := httptest.NewRequest("POST", apiURL("cats/"+c.player), strings.NewReader(c.body))
+		r = mux.SetURLVars(r, map[string]string{"gameId": "cats", "playerId": c.player})
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		playHandler(w, r)
+		if err := expectWithWriter(w, c.status, c.expected); err != nil {
+			t.Error(err)
+		}
+	}
+
+	r := httptest.NewRequest("GET", apiURL("cats/moves"), nil)
+	r = mux.SetURLVars(r, map[string]string{"gameId": "cats"})
+	w := httptest.NewRecorder()
+	moveListHandler(w, r)
+	err := expectWithWriter(w, http.StatusOK,
+		`{"moves":[{"type":"MOVE","player":"a","row":0,"column":2},{"type":"MOVE","player":"b","row":0,"column":0}]}`)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_playHandlerTokens(t *testing.T) {
+	g := CreateGame(4, 4, 4, "a", "bot:easy")
+	g.id = "tokens"
+	GAMES.Add(g)
+	g.tokens["a"] = "other"
+
+	// missing token
+	r := httptest.NewRequest("POST", apiURL("tokens/a"), strings.NewReader(`{"column" : 2}`))
+	r = mux.SetURLVars(r, map[string]string{"gameId": "tokens", "playerId": "a"})
+
+	w := httptest.NewRecorder()
+	playHandler(w, r)
+	err := expectWithWriter(w, http.StatusUnauthorized, `{"error":{"code":"MISSING_TOKEN","message":"missing bearer token","field":"Authorization","gameId":"tokens"}}`)
+	if err != nil {
+		t.Error(err)
+	}
+	if w.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Error("expected a WWW-Authenticate challenge")
+	}
+
+	// another seat's token
+	r = httptest.NewRequest("POST", apiURL("tokens/a"), strings.NewReader(`{"column" : 2}`))
+	r = mux.SetURLVars(r, map[string]string{"gameId": "tokens", "playerId": "a"})
+	r.Header.Set("Authorization", "Bearer secret")
+
+	w = httptest.NewRecorder()
+	playHandler(w, r)
+	err = expectWithWriter(w, http.StatusForbidden, `{"error":{"code":"INVALID_TOKEN","message":"invalid token for player","field":"Authorization","gameId":"tokens"}}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// nobody holds a bot's seat
+	r = httptest.NewRequest("DELETE", apiURL("tokens/bot:easy"), nil)
+	r = mux.SetURLVars(r, map[string]string{"gameId": "tokens", "playerId": "bot:easy"})
+	r.Header.Set("Authorization", "Bearer secret")
+
+	w = httptest.NewRecorder()
+	playHandler(w, r)
+	err = expectWithWriter(w, http.StatusForbidden, `{"error":{"code":"INVALID_TOKEN","message":"invalid token for player","field":"Authorization","gameId":"tokens"}}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	r = httptest.NewRequest("POST", apiURL("tokens/a"), strings.NewReader(`{"column" : 2}`))
+	r = mux.SetURLVars(r, map[string]string{"gameId": "tokens", "playerId": "a"})
+	r.Header.Set("Authorization", "Bearer other")
 
 	w = httptest.NewRecorder()
 	playHandler(w, r)
-	err = expectWithWriter(w, http.StatusGone, ``)
+	err = expectWithWriter(w, http.StatusOK, `{"move":"tokens/moves/0"}`)
 	if err != nil {
 		t.Error(err)
 	}
 }
+
+func joinRequest(path string, vars map[string]string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", apiURL(path), strings.NewReader(body))
+	r = mux.SetURLVars(r, vars)
+	w := httptest.NewRecorder()
+	if _, ok := vars["invite"]; ok {
+		inviteHandler(w, r)
+	} else {
+		gameStatusHandler(w, r)
+	}
+	return w
+}
+
+func Test_openSeats(t *testing.T) {
+	r := httptest.NewRequest("POST", apiURL(""), strings.NewReader(`{"players": ["host"], "seats": 3}`))
+	w := httptest.NewRecorder()
+	gameHandler(w, r)
+	cgr := &CreateGameResponse{}
+	json.NewDecoder(w.Body).Decode(cgr)
+	if w.Code != http.StatusOK || cgr.GameId != "cats" || len(cgr.Invites) != 2 || cgr.Tokens["host"] != "secret" {
+		t.Fatal("expected a game with two invites got ", w.Code, *cgr)
+	}
+	g, _ := GAMES.Get("cats")
+	if status := g.GameStatus(); status.Status != STATUS_WAITING || status.OpenSeats != 2 {
+		t.Error("expected to wait for two players got ", *status)
+	}
+
+	r = httptest.NewRequest("POST", apiURL("cats/host"), strings.NewReader(`{"column": 0}`))
+	r = mux.SetURLVars(r, map[string]string{"gameId": "cats", "playerId": "host"})
+	r.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	playHandler(w, r)
+	err := expectWithWriter(w, http.StatusConflict,
+		`{"error":{"code":"NOT_STARTED","message":"game is waiting for players","gameId":"cats","move":0}}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	for _, c := range []struct {
+		path     string
+		vars     map[string]string
+		body     string
+		status   int
+		expected string
+	}{
+		{"cats", map[string]string{"gameId": "cats"}, `{"player": "guest"}`, http.StatusForbidden,
+			`{"error":{"code":"INVITE_ONLY","message":"game is invite only","gameId":"cats"}}`},
+		{"invites/nope", map[string]string{"invite": "nope"}, `{"player": "guest"}`, http.StatusNotFound,
+			`{"error":{"code":"UNKNOWN_INVITE","message":"invite not found"}}`},
+		{"invites/" + cgr.Invites[0], map[string]string{"invite": cgr.Invites[0]}, `{"player": "bot:easy"}`,
+			http.StatusBadRequest,
+			`{"error":{"code":"INVALID_PLAYERS","message":"bot bot:easy cannot claim a seat","field":"player","gameId":"cats"}}`},
+		{"invites/" + cgr.Invites[0], map[string]string{"invite": cgr.Invites[0]}, `{"player": "host"}`,
+			http.StatusConflict,
+			`{"error":{"code":"ALREADY_SEATED","message":"host already has a seat in this game","field":"player","gameId":"cats"}}`},
+		{"invites/" + cgr.Invites[0], map[string]string{"invite": cgr.Invites[0]}, `{"player": "guest"}`,
+			http.StatusOK, `{"gameId":"cats","tokens":{"guest":"secret"}}`},
+		// Invites are single-use.
+		{"invites/" + cgr.Invites[0], map[string]string{"invite": cgr.Invites[0]}, `{"player": "third"}`,
+			http.StatusNotFound, `{"error":{"code":"UNKNOWN_INVITE","message":"invite not found"}}`},
+		{"invites/" + cgr.Invites[1], map[string]string{"invite": cgr.Invites[1]}, `{"player": "third"}`,
+			http.StatusOK, `{"gameId":"cats","tokens":{"third":"secret"}}`},
+	} {
+		w = joinRequest(c.path, c.vars, c.body)
+		if err := expectWithWriter(w, c.status, c.expected); err != nil {
+			t.Error(err)
+		}
+	}
+	status := g.GameStatus()
+	if status.Status != STATUS_IN_PROGRESS || strings.Join(status.Players, ",") != "host,guest,third" {
+		t.Error("expected the game to start got ", *status)
+	}
+
+	// Anyone may claim a seat of a public game, until it is full.
+	r = httptest.NewRequest("POST", apiURL(""), strings.NewReader(`{"players": ["bot:easy", "host"], "seats": 3, "public": true}`))
+	w = httptest.NewRecorder()
+	gameHandler(w, r)
+	g, _ = GAMES.Get("cats")
+	w = joinRequest("cats", map[string]string{"gameId": "cats"}, `{"player": "guest"}`)
+	if err := expectWithWriter(w, http.StatusOK, `{"gameId":"cats","tokens":{"guest":"secret"}}`); err != nil {
+		t.Error(err)
+	}
+	// The engine player has the first move once the game starts.
+	if len(g.Invites()) != 0 || g.MoveCount() != 1 {
+		t.Error("expected the unused invite to be dropped and the engine player to move")
+	}
+	w = joinRequest("cats", map[string]string{"gameId": "cats"}, `{"player": "late"}`)
+	err = expectWithWriter(w, http.StatusConflict,
+		`{"error":{"code":"GAME_FULL","message":"game has no open seats","gameId":"cats"}}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	r = httptest.NewRequest("POST", apiURL(""), strings.NewReader(`{"players": ["a", "b", "c"], "seats": 2}`))
+	w = httptest.NewRecorder()
+	gameHandler(w, r)
+	err = expectWithWriter(w, http.StatusBadRequest,
+		`{"error":{"code":"INVALID_PLAYERS","message":"2 seats is fewer than the 3 players named","field":"seats"}}`)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_statsHandler(t *testing.T) {
+	g := CreateGame(4, 4, 4, "a", "b")
+	g.id = "counted"
+	GAMES.Add(g)
+
+	r := httptest.NewRequest("GET", apiURL("stats"), nil)
+	w := httptest.NewRecorder()
+	statsHandler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatal("expected 200 got ", w.Code)
+	}
+	stats := &ContainerStats{}
+	if err := json.NewDecoder(w.Body).Decode(stats); err != nil {
+		t.Fatal("unable to decode stats ", err)
+	}
+	if stats.Games != len(GAMES.GetGames()) || stats.Waiting+stats.InProgress+stats.Finished != stats.Games {
+		t.Error("unexpected stats ", *stats)
+	}
+}
+
+func Test_gameListFilters(t *testing.T) {
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, id := range []string{"list-c", "list-a", "list-b"} {
+		g := CreateGame(4, 4, 4, "lister", "other")
+		g.id = id
+		g.created = created.Add(time.Duration(i) * time.Minute)
+		GAMES.Add(g)
+	}
+	done, _ := GAMES.Get("list-a")
+	done.Quit("other")
+
+	list := func(query string, status int) *GameList {
+		r := httptest.NewRequest("GET", apiURL("")+"?"+query, nil)
+		w := httptest.NewRecorder()
+		gameHandler(w, r)
+		if w.Code != status {
+			t.Fatal(query, " expected ", status, " got ", w.Code, " ", w.Body.String())
+		}
+		gl := &GameList{}
+		if status == http.StatusOK {
+			json.NewDecoder(w.Body).Decode(gl)
+		}
+		return gl
+	}
+
+	// Oldest first, a page at a time.
+	gl := list("player=lister&limit=2", http.StatusOK)
+	if strings.Join(gl.Games, ",") != "list-c,list-a" || gl.Next == "" || gl.Summaries != nil {
+		t.Error("unexpected first page ", gl)
+	}
+	gl = list("player=lister&limit=2&cursor="+gl.Next, http.StatusOK)
+	if strings.Join(gl.Games, ",") != "list-b" || gl.Next != "" {
+		t.Error("unexpected last page ", gl)
+	}
+	// Without a limit every game is listed.
+	gl = list("player=lister", http.StatusOK)
+	if len(gl.Games) != 3 || gl.Next != "" {
+		t.Error("expected every game got ", gl)
+	}
+	gc, _ := NewGamesContainer(&memoryStore{})
+	for i := 0; i < 150; i++ {
+		g := CreateGame(4, 4, 4, "a", "b")
+		g.id = fmt.Sprint("many-", i)
+		gc.Add(g)
+	}
+	if games, next := gc.List(&GameListRequest{}); len(games) != 150 || next != nil {
+		t.Error("expected all 150 games got ", len(games))
+	}
+
+	gl = list("player=lister&state=done&summary=true", http.StatusOK)
+	if len(gl.Summaries) != 1 {
+		t.Fatal("expected one finished game got ", gl.Games)
+	}
+	summary := gl.Summaries[0]
+	if summary.Id != "list-a" || summary.Status != STATUS_DONE || summary.Winner != "lister" ||
+		summary.Moves != 1 || summary.Rules.Rows != 4 || len(summary.Players) != 2 {
+		t.Error("unexpected summary ", *summary)
+	}
+
+	gl = list("player=lister&state=in_progress&created_after=2020-01-01T00:00:00Z", http.StatusOK)
+	if strings.Join(gl.Games, ",") != "list-b" {
+		t.Error("expected the newest game in progress got ", gl.Games)
+	}
+	gl = list("player=nobody", http.StatusOK)
+	if gl.Games == nil || len(gl.Games) != 0 {
+		t.Error("expected an empty list got ", gl.Games)
+	}
+
+	for _, query := range []string{
+		"state=paused", "limit=0", "limit=many", "cursor=nope", "created_before=yesterday", "summary=maybe",
+	} {
+		list(query, http.StatusBadRequest)
+	}
+}
+
+func Test_errorResponses(t *testing.T) {
+	server := httptest.NewServer(configureRouter(*API_PREFIX))
+	defer server.Close()
+
+	g := CreateGame(4, 4, 4, "a", "b")
+	g.id = "errors"
+	GAMES.Add(g)
+	g.Quit("b")
+
+	expectError := func(method, path, body string, status int, expected string) {
+		r, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != status || resp.Header.Get("Content-Type") != "application/json" {
+			t.Error(method, " ", path, " expected a JSON ", status, " got ", resp.StatusCode)
+		}
+		if got := strings.TrimSpace(string(b)); got != expected {
+			t.Error(method, " ", path, " expected ", expected, " got ", got)
+		}
+	}
+
+	prefix := "/" + *API_PREFIX
+	expectError("GET", "/nowhere/at/all/here/either", "", http.StatusNotFound,
+		`{"error":{"code":"NOT_FOUND","message":"no such resource"}}`)
+	expectError("PUT", prefix, "", http.StatusMethodNotAllowed,
+		`{"error":{"code":"METHOD_NOT_ALLOWED","message":"method PUT not allowed"}}`)
+	expectError("POST", prefix+"/errors/a", `{"column": 0}`, http.StatusBadRequest,
+		`{"error":{"code":"GAME_OVER","message":"game is over","gameId":"errors","move":1}}`)
+	expectError("DELETE", prefix+"/errors/a", "", http.StatusGone,
+		`{"error":{"code":"GAME_OVER","message":"game is over","gameId":"errors"}}`)
+}
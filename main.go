@@ -5,25 +5,71 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"net/http"
 )
 
 var (
 	GAMES              *GamesContainer
+	MATCHMAKER         *Matchmaker
 	LOGGER             *log.Logger
 	API_PREFIX         = flag.String("api_prefix", "game", "api URL prefix")
-	NUM_PLAYERS        = flag.Int("num_players", 2, "required number of players")
-	BOARD_WIDTH        = flag.Int("board_width", 4, "board width")
-	BOARD_LENGTH       = flag.Int("board_length", 4, "board length")
+	BOARD_WIDTH        = flag.Int("board_width", 4, "default board width (rows)")
+	BOARD_LENGTH       = flag.Int("board_length", 4, "default board length (columns)")
 	CONSECUTIVE_LENGTH = flag.Int("consecutive_length", 4,
-		"consecutive line length required for a win")
+		"default consecutive line length required for a win")
+
+	MIN_PLAYERS            = flag.Int("min_players", 2, "minimum number of players")
+	MAX_PLAYERS            = flag.Int("max_players", 4, "maximum number of players")
+	NUM_PLAYERS            = flag.Int("num_players", 0, "deprecated: sets both min_players and max_players")
+	MIN_ROWS               = flag.Int("min_rows", 3, "minimum board rows")
+	MAX_ROWS               = flag.Int("max_rows", 20, "maximum board rows")
+	MIN_COLUMNS            = flag.Int("min_columns", 3, "minimum board columns")
+	MAX_COLUMNS            = flag.Int("max_columns", 20, "maximum board columns")
+	MIN_CONSECUTIVE_LENGTH = flag.Int("min_consecutive_length", 3,
+		"minimum consecutive line length required for a win")
+	MAX_CONSECUTIVE_LENGTH = flag.Int("max_consecutive_length", 20,
+		"maximum consecutive line length required for a win")
+
 	LOG_PATH = flag.String("log_path", "macl.log", "logging path")
 	PORT     = flag.Int("port", 8080, "server port")
+
+	DATA_DIR = flag.String("data_dir", "",
+		"directory to store games in, games are only kept in memory if empty")
+	SNAPSHOT_INTERVAL = flag.Duration("snapshot_interval", 5*time.Minute,
+		"how often stored games are compacted into a snapshot")
+
+	MAX_WAIT = flag.Duration("max_wait", time.Minute,
+		"longest a move list request may wait for a new move")
+
+	FINISHED_TTL = flag.Duration("finished_ttl", 24*time.Hour,
+		"how long finished games are kept before they are archived, 0 keeps them forever")
+	IDLE_TIMEOUT = flag.Duration("idle_timeout", time.Hour,
+		"how long the player on turn may take before forfeiting, 0 waits forever")
+	REAP_INTERVAL = flag.Duration("reap_interval", time.Minute,
+		"how often idle and finished games are checked for")
+
+	MATCH_WINDOW = flag.Float64("match_window", 100,
+		"largest rating difference between players matched straight away")
+	MATCH_WIDEN = flag.Float64("match_widen", 10,
+		"rating difference added to the match window per second of waiting")
+	MATCH_TIMEOUT = flag.Duration("match_timeout", 5*time.Minute,
+		"how long a player waits for a match before giving up")
+	MATCH_INTERVAL = flag.Duration("match_interval", time.Second,
+		"how often waiting players are matched")
+
+	BOT_THINK_TIME = flag.Duration("bot_think_time", 500*time.Millisecond,
+		"how long an engine player may search for a move before playing the best found")
 )
 
 func init() {
 	flag.Parse()
+	// num_players required games of exactly that many players.
+	if *NUM_PLAYERS > 0 {
+		*MIN_PLAYERS = *NUM_PLAYERS
+		*MAX_PLAYERS = *NUM_PLAYERS
+	}
 
 	logfile, err := os.OpenFile(*LOG_PATH, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
 	if err != nil {
@@ -32,10 +78,42 @@ func init() {
 	prefix := fmt.Sprintf("[%s] ", *API_PREFIX)
 	LOGGER = log.New(logfile, prefix, log.LstdFlags|log.Lshortfile)
 
-	GAMES = &GamesContainer{
-		games: map[string]*game{},
+	var store Store = &memoryStore{}
+	if *DATA_DIR != "" {
+		store, err = OpenFileStore(*DATA_DIR)
+		if err != nil {
+			log.Fatal(fmt.Sprintf("unable to open data dir: %s", err))
+		}
+	}
+	GAMES, err = NewGamesContainer(store)
+	if err != nil {
+		log.Fatal(fmt.Sprintf("unable to load games: %s", err))
+	}
+	MATCHMAKER = NewMatchmaker(GAMES, *MATCH_WINDOW, *MATCH_WIDEN, *MATCH_TIMEOUT)
+}
+
+// snapshotLoop periodically compacts the stored games.
+func snapshotLoop(interval time.Duration) {
+	for range time.Tick(interval) {
+		err := GAMES.Snapshot()
+		if err != nil {
+			LOGGER.Println(fmt.Sprintf("snapshot failed: %s", err))
+		}
 	}
+}
 
+// reapLoop periodically forfeits idle players and removes finished games.
+func reapLoop(interval time.Duration) {
+	for now := range time.Tick(interval) {
+		GAMES.Reap(now, *FINISHED_TTL, *IDLE_TIMEOUT)
+	}
+}
+
+// matchLoop periodically matches waiting players as their windows widen.
+func matchLoop(interval time.Duration) {
+	for now := range time.Tick(interval) {
+		MATCHMAKER.Match(now)
+	}
 }
 
 func main() {
@@ -44,6 +122,16 @@ func main() {
 		Handler: configureRouter(*API_PREFIX),
 	}
 
+	if *DATA_DIR != "" && *SNAPSHOT_INTERVAL > 0 {
+		go snapshotLoop(*SNAPSHOT_INTERVAL)
+	}
+	if *REAP_INTERVAL > 0 {
+		go reapLoop(*REAP_INTERVAL)
+	}
+	if *MATCH_INTERVAL > 0 {
+		go matchLoop(*MATCH_INTERVAL)
+	}
+
 	LOGGER.Println(fmt.Sprintf("serving on port: %d", *PORT))
 	err := server.ListenAndServe()
 	if err != nil {
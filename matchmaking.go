@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// TicketStatus is where a matchmaking ticket is in finding a game.
+type TicketStatus string
+
+var TicketWaiting = TicketStatus("WAITING")
+var TicketMatched = TicketStatus("MATCHED")
+var TicketExpired = TicketStatus("EXPIRED")
+
+// mkTicketId returns the id of a matchmaking ticket. Only the player holding it
+// learns the seat token of the game they are matched into.
+var mkTicketId = func() string {
+	u, _ := uuid.NewV4()
+	return fmt.Sprintf("%v", u)
+}
+
+// matchTicket is a player waiting for a game with the given rules.
+type matchTicket struct {
+	id       string
+	player   string
+	rules    Rules
+	rating   float64
+	enqueued time.Time
+
+	status TicketStatus
+	gameId string
+	token  string
+
+	// Closed once the ticket is matched or expires.
+	done chan struct{}
+}
+
+// Matchmaker pairs waiting players wanting the same rules into games. Players are
+// matched with those closest in rating, within a window that widens the
+// longer they wait.
+type Matchmaker struct {
+	sync.Mutex
+	tickets map[string]*matchTicket
+	games   *GamesContainer
+
+	// Rating difference allowed at first and added per second of waiting.
+	window float64
+	widen  float64
+
+	// How long a ticket waits before it expires.
+	timeout time.Duration
+}
+
+func NewMatchmaker(games *GamesContainer, window, widen float64, timeout time.Duration) *Matchmaker {
+	return &Matchmaker{
+		tickets: map[string]*matchTicket{},
+		games:   games,
+		window:  window,
+		widen:   widen,
+		timeout: timeout,
+	}
+}
+
+// Enqueue adds a player to the queue and tries to match them straight away.
+// A player can only wait for one game at a time.
+func (m *Matchmaker) Enqueue(player string, rules *Rules, now time.Time) (*MatchTicketResponse, bool) {
+	m.Lock()
+	defer m.Unlock()
+	for _, t := range m.tickets {
+		if t.player == player && t.status == TicketWaiting {
+			return nil, false
+		}
+	}
+	rating := glickoRating
+	if pr, ok := m.games.ratings.Get(player); ok {
+		rating = pr.Rating
+	}
+	t := &matchTicket{
+		id:       mkTicketId(),
+		player:   player,
+		rules:    *rules,
+		rating:   rating,
+		enqueued: now,
+		status:   TicketWaiting,
+		done:     make(chan struct{}),
+	}
+	m.tickets[t.id] = t
+	m.match(now)
+	return t.response(), true
+}
+
+// Leave removes a ticket from the queue, returning false if it was unknown.
+func (m *Matchmaker) Leave(ticketId string) bool {
+	m.Lock()
+	defer m.Unlock()
+	t, ok := m.tickets[ticketId]
+	if !ok {
+		return false
+	}
+	delete(m.tickets, ticketId)
+	if t.status == TicketWaiting {
+		close(t.done)
+	}
+	return true
+}
+
+// Ticket returns the state of a ticket, waiting up to wait for it to be
+// matched or to expire.
+func (m *Matchmaker) Ticket(ctx context.Context, ticketId string, wait time.Duration) (*MatchTicketResponse, bool) {
+	m.Lock()
+	t, ok := m.tickets[ticketId]
+	m.Unlock()
+	if !ok {
+		return nil, false
+	}
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-t.done:
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+	m.Lock()
+	defer m.Unlock()
+	return t.response(), true
+}
+
+// Match pairs waiting players whose windows have widened enough, and expires
+// those who have waited too long. Tickets that have been matched or expired
+// are forgotten after another timeout.
+func (m *Matchmaker) Match(now time.Time) {
+	m.Lock()
+	defer m.Unlock()
+	for id, t := range m.tickets {
+		waited := now.Sub(t.enqueued)
+		if t.status != TicketWaiting && waited > 2*m.timeout {
+			delete(m.tickets, id)
+		} else if t.status == TicketWaiting && waited > m.timeout {
+			t.status = TicketExpired
+			close(t.done)
+		}
+	}
+	m.match(now)
+}
+
+// windowOf is the rating difference a ticket accepts after waiting until now.
+func (m *Matchmaker) windowOf(t *matchTicket, now time.Time) float64 {
+	return m.window + m.widen*now.Sub(t.enqueued).Seconds()
+}
+
+// fits returns if two tickets are within each other's windows.
+func (m *Matchmaker) fits(a, b *matchTicket, now time.Time) bool {
+	diff := math.Abs(a.rating - b.rating)
+	return diff <= m.windowOf(a, now) && diff <= m.windowOf(b, now)
+}
+
+// match makes every game it can, giving the longest waiting players the first
+// pick of opponents.
+func (m *Matchmaker) match(now time.Time) {
+	waiting := []*matchTicket{}
+	for _, t := range m.tickets {
+		if t.status == TicketWaiting {
+			waiting = append(waiting, t)
+		}
+	}
+	sort.Slice(waiting, func(i, j int) bool {
+		if !waiting[i].enqueued.Equal(waiting[j].enqueued) {
+			return waiting[i].enqueued.Before(waiting[j].enqueued)
+		}
+		return waiting[i].id < waiting[j].id
+	})
+
+	for _, t := range waiting {
+		if t.status != TicketWaiting {
+			continue
+		}
+		// Opponents within each other's windows, closest in rating first.
+		candidates := []*matchTicket{}
+		for _, other := range waiting {
+			if other != t && other.status == TicketWaiting && other.rules == t.rules && m.fits(t, other, now) {
+				candidates = append(candidates, other)
+			}
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return math.Abs(candidates[i].rating-t.rating) < math.Abs(candidates[j].rating-t.rating)
+		})
+		// Everyone in the group is within everyone else's window.
+		group := []*matchTicket{t}
+		for _, other := range candidates {
+			if len(group) == t.rules.Players {
+				break
+			}
+			fitsAll := true
+			for _, member := range group[1:] {
+				fitsAll = fitsAll && m.fits(member, other, now)
+			}
+			if fitsAll {
+				group = append(group, other)
+			}
+		}
+		if len(group) == t.rules.Players {
+			m.start(group)
+		}
+	}
+}
+
+// start creates the game of a matched group, seating the longest waiting
+// player first.
+func (m *Matchmaker) start(group []*matchTicket) {
+	sort.SliceStable(group, func(i, j int) bool {
+		return group[i].enqueued.Before(group[j].enqueued)
+	})
+	players := []string{}
+	for _, t := range group {
+		players = append(players, t.player)
+	}
+	rules := group[0].rules
+	g := CreateGameWithRules(&rules, players...)
+	for _, player := range players {
+		g.rated[player] = true
+	}
+	tokens := g.Tokens()
+	m.games.Add(g)
+	LOGGER.Println(fmt.Sprintf("matched %v into game %s", players, g.id))
+	for _, t := range group {
+		t.status = TicketMatched
+		t.gameId = g.id
+		t.token = tokens[t.player]
+		close(t.done)
+	}
+}
+
+func (t *matchTicket) response() *MatchTicketResponse {
+	rules := t.rules
+	return &MatchTicketResponse{
+		Ticket: t.id,
+		Player: t.player,
+		Rules:  &rules,
+		Status: t.status,
+		GameId: t.gameId,
+		Token:  t.token,
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func Test_MatchmakerWidens(t *testing.T) {
+	gc, _ := NewGamesContainer(&memoryStore{})
+	gc.ratings.players["strong"] = &PlayerRating{Id: "strong", Rating: 1800}
+	mm := NewMatchmaker(gc, 100, 10, time.Minute)
+	rules := &Rules{Rows: 4, Columns: 4, WinLength: 4, Players: 2}
+	start := time.Now()
+
+	weak, ok := mm.Enqueue("weak", rules, start)
+	if !ok || weak.Status != TicketWaiting {
+		t.Fatal("expected to wait got ", weak)
+	}
+	if _, ok = mm.Enqueue("weak", rules, start); ok {
+		t.Error("expected a player to wait for one game at a time")
+	}
+	strong, _ := mm.Enqueue("strong", rules, start.Add(time.Second))
+
+	// 300 apart, so matched once both windows reach 300.
+	mm.Match(start.Add(20 * time.Second))
+	if strong, _ = mm.Ticket(context.Background(), strong.Ticket, 0); strong.Status != TicketWaiting {
+		t.Fatal("expected the window to be too narrow got ", strong.Status)
+	}
+	mm.Match(start.Add(21 * time.Second))
+	strong, _ = mm.Ticket(context.Background(), strong.Ticket, 0)
+	weak, _ = mm.Ticket(context.Background(), weak.Ticket, 0)
+	if strong.Status != TicketMatched || strong.GameId != weak.GameId {
+		t.Fatal("expected a match got ", strong, weak)
+	}
+	g, ok := gc.Get(weak.GameId)
+	if !ok || strings.Join(g.playerList, ",") != "weak,strong" || weak.Token != g.Tokens()["weak"] {
+		t.Error("expected the game to be created with the first waiting player first")
+	}
+	if !g.rated["weak"] || !g.rated["strong"] {
+		t.Error("expected matched players to be rated")
+	}
+}
+
+func Test_MatchmakerClosest(t *testing.T) {
+	gc, _ := NewGamesContainer(&memoryStore{})
+	gc.ratings.players["low"] = &PlayerRating{Id: "low", Rating: 1300}
+	gc.ratings.players["near"] = &PlayerRating{Id: "near", Rating: 1450}
+	mm := NewMatchmaker(gc, 0, 10, time.Minute)
+	rules := &Rules{Rows: 4, Columns: 4, WinLength: 4, Players: 2}
+	start := time.Now()
+
+	first, _ := mm.Enqueue("first", rules, start)
+	low, _ := mm.Enqueue("low", rules, start.Add(time.Second))
+	mm.Enqueue("near", rules, start.Add(2*time.Second))
+	small := *rules
+	small.Rows = 3
+	other, _ := mm.Enqueue("other rules", &small, start)
+
+	// Both are within the window of the first player, who is matched with
+	// the closer of them.
+	mm.Match(start.Add(32 * time.Second))
+	first, _ = mm.Ticket(context.Background(), first.Ticket, 0)
+	if first.Status != TicketMatched {
+		t.Fatal("expected a match got ", first.Status)
+	}
+	g, _ := gc.Get(first.GameId)
+	if strings.Join(g.playerList, ",") != "first,near" {
+		t.Error("expected first and near to play got ", g.playerList)
+	}
+
+	// Players wanting other rules are never matched and eventually expire.
+	mm.Match(start.Add(2 * time.Minute))
+	for _, ticket := range []*MatchTicketResponse{low, other} {
+		if ticket, _ = mm.Ticket(context.Background(), ticket.Ticket, 0); ticket.Status != TicketExpired {
+			t.Error("expected the ticket to expire got ", ticket.Status)
+		}
+	}
+	mm.Match(start.Add(3 * time.Minute))
+	if _, ok := mm.Ticket(context.Background(), other.Ticket, 0); ok {
+		t.Error("expected the expired ticket to be forgotten")
+	}
+}
+
+func Test_MatchmakerGroupSpread(t *testing.T) {
+	gc, _ := NewGamesContainer(&memoryStore{})
+	gc.ratings.players["low"] = &PlayerRating{Id: "low", Rating: 1420}
+	gc.ratings.players["high"] = &PlayerRating{Id: "high", Rating: 1580}
+	gc.ratings.players["near"] = &PlayerRating{Id: "near", Rating: 1560}
+	mm := NewMatchmaker(gc, 100, 0, time.Minute)
+	rules := &Rules{Rows: 4, Columns: 4, WinLength: 4, Players: 3}
+	start := time.Now()
+
+	mid, _ := mm.Enqueue("mid", rules, start)
+	low, _ := mm.Enqueue("low", rules, start.Add(time.Second))
+	mm.Enqueue("high", rules, start.Add(2*time.Second))
+
+	// Both are within the window of mid but not of each other.
+	mm.Match(start.Add(3 * time.Second))
+	if mid, _ = mm.Ticket(context.Background(), mid.Ticket, 0); mid.Status != TicketWaiting {
+		t.Fatal("expected no match got ", mid.Status)
+	}
+
+	mm.Enqueue("near", rules, start.Add(3*time.Second))
+	mm.Match(start.Add(4 * time.Second))
+	mid, _ = mm.Ticket(context.Background(), mid.Ticket, 0)
+	low, _ = mm.Ticket(context.Background(), low.Ticket, 0)
+	if mid.Status != TicketMatched || low.Status != TicketWaiting {
+		t.Fatal("expected mid to be matched without low got ", mid.Status, low.Status)
+	}
+	g, _ := gc.Get(mid.GameId)
+	if strings.Join(g.playerList, ",") != "mid,high,near" {
+		t.Error("expected mid, high and near to play got ", g.playerList)
+	}
+}
+
+func matchmake(t *testing.T, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", apiURL("matchmaking"), strings.NewReader(body))
+	w := httptest.NewRecorder()
+	matchmakingHandler(w, r)
+	return w
+}
+
+func ticketRequest(method, ticketId, query string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, apiURL("matchmaking/"+ticketId)+query, nil)
+	r = mux.SetURLVars(r, map[string]string{"ticketId": ticketId})
+	w := httptest.NewRecorder()
+	ticketHandler(w, r)
+	return w
+}
+
+func Test_matchmakingHandler(t *testing.T) {
+	w := matchmake(t, `{"player": "queued", "rows": 5}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatal("expected 202 got ", w.Code, w.Body.String())
+	}
+	first := &MatchTicketResponse{}
+	json.NewDecoder(w.Body).Decode(first)
+	if first.Status != TicketWaiting || *first.Rules != (Rules{Rows: 5, Columns: 4, WinLength: 4, Players: 2}) {
+		t.Error("unexpected ticket ", *first)
+	}
+
+	err := expectWithWriter(matchmake(t, `{"player": "queued", "rows": 5}`), http.StatusConflict,
+		`{"error":{"code":"ALREADY_WAITING","message":"queued is already waiting for a game","field":"player"}}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// Waiting on the ticket returns as soon as it is matched.
+	waited := make(chan *httptest.ResponseRecorder)
+	go func() {
+		waited <- ticketRequest("GET", first.Ticket, "?wait=30s")
+	}()
+	time.Sleep(10 * time.Millisecond)
+	w = matchmake(t, `{"player": "joined", "rows": 5}`)
+	second := &MatchTicketResponse{}
+	json.NewDecoder(w.Body).Decode(second)
+	if w.Code != http.StatusOK || second.Status != TicketMatched || second.GameId != "cats" || second.Token != "secret" {
+		t.Error("expected to be matched got ", w.Code, *second)
+	}
+	w = <-waited
+	json.NewDecoder(w.Body).Decode(first)
+	if first.Status != TicketMatched || first.GameId != "cats" {
+		t.Error("expected the waiting ticket to be matched got ", *first)
+	}
+
+	w = ticketRequest("DELETE", first.Ticket, "")
+	if w.Code != http.StatusAccepted {
+		t.Error("expected 202 got ", w.Code)
+	}
+	err = expectWithWriter(ticketRequest("GET", first.Ticket, ""), http.StatusNotFound,
+		`{"error":{"code":"UNKNOWN_TICKET","message":"ticket not found"}}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	for _, c := range []struct {
+		body     string
+		expected string
+	}{
+		{`{"player": "bot:easy"}`,
+			`{"error":{"code":"INVALID_PLAYERS","message":"bot bot:easy cannot wait for a game","field":"player"}}`},
+		{`{"players": 2}`,
+			`{"error":{"code":"INVALID_PLAYERS","message":"empty player id","field":"player"}}`},
+		{`{"player": "a", "players": 9}`,
+			`{"error":{"code":"INVALID_RULES","message":"players must be between 2 and 4, got 9","field":"players"}}`},
+		{`{"player": 1}`,
+			`{"error":{"code":"MALFORMED_INPUT","message":"malformed input","field":"player"}}`},
+	} {
+		if err := expectWithWriter(matchmake(t, c.body), http.StatusBadRequest, c.expected); err != nil {
+			t.Error(err)
+		}
+	}
+}
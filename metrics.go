@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// METRICS_CONTENT_TYPE is the Prometheus text exposition format.
+const METRICS_CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+// metricCounter is a Prometheus counter with optional labels. Counts are kept
+// by the label values joined with labelSep.
+type metricCounter struct {
+	sync.Mutex
+	name   string
+	help   string
+	labels []string
+	values map[string]uint64
+}
+
+const labelSep = "\xff"
+
+func newCounter(name, help string, labels ...string) *metricCounter {
+	return &metricCounter{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: map[string]uint64{},
+	}
+}
+
+// Inc adds one to the count with the given label values.
+func (mc *metricCounter) Inc(values ...string) {
+	mc.Lock()
+	defer mc.Unlock()
+	mc.values[strings.Join(values, labelSep)]++
+}
+
+// Get returns the count with the given label values.
+func (mc *metricCounter) Get(values ...string) uint64 {
+	mc.Lock()
+	defer mc.Unlock()
+	return mc.values[strings.Join(values, labelSep)]
+}
+
+func (mc *metricCounter) write(buf *bytes.Buffer) {
+	mc.Lock()
+	defer mc.Unlock()
+	writeHeader(buf, mc.name, mc.help, "counter")
+	if len(mc.labels) == 0 {
+		fmt.Fprintf(buf, "%s %d\n", mc.name, mc.values[""])
+		return
+	}
+	for _, key := range sortedKeys(mc.values) {
+		fmt.Fprintf(buf, "%s%s %d\n", mc.name, formatLabels(mc.labels, key, "", ""), mc.values[key])
+	}
+}
+
+// metricHistogram is a Prometheus histogram with optional labels.
+type metricHistogram struct {
+	sync.Mutex
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	// Observations at or below each bucket, not yet cumulative.
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Request latencies in seconds, the Prometheus client defaults.
+var LATENCY_BUCKETS = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *metricHistogram {
+	return &metricHistogram{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		series:  map[string]*histogramSeries{},
+	}
+}
+
+func (mh *metricHistogram) Observe(v float64, values ...string) {
+	mh.Lock()
+	defer mh.Unlock()
+	key := strings.Join(values, labelSep)
+	s, ok := mh.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(mh.buckets))}
+		mh.series[key] = s
+	}
+	i := sort.SearchFloat64s(mh.buckets, v)
+	if i < len(mh.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+func (mh *metricHistogram) write(buf *bytes.Buffer) {
+	mh.Lock()
+	defer mh.Unlock()
+	writeHeader(buf, mh.name, mh.help, "histogram")
+	keys := []string{}
+	for key := range mh.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := mh.series[key]
+		var cumulative uint64
+		for i, bound := range mh.buckets {
+			cumulative += s.counts[i]
+			le := strconv.FormatFloat(bound, 'g', -1, 64)
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", mh.name, formatLabels(mh.labels, key, "le", le), cumulative)
+		}
+		fmt.Fprintf(buf, "%s_bucket%s %d\n", mh.name, formatLabels(mh.labels, key, "le", "+Inf"), s.count)
+		fmt.Fprintf(buf, "%s_sum%s %s\n", mh.name, formatLabels(mh.labels, key, "", ""),
+			strconv.FormatFloat(s.sum, 'g', -1, 64))
+		fmt.Fprintf(buf, "%s_count%s %d\n", mh.name, formatLabels(mh.labels, key, "", ""), s.count)
+	}
+}
+
+func writeHeader(buf *bytes.Buffer, name, help, kind string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeGauge(buf *bytes.Buffer, name, help string, v int) {
+	writeHeader(buf, name, help, "gauge")
+	fmt.Fprintf(buf, "%s %d\n", name, v)
+}
+
+// formatLabels renders label names with the values joined in key, plus an
+// extra label if extraName is set, eg. {route="/game",le="0.5"}.
+func formatLabels(names []string, key, extraName, extraValue string) string {
+	pairs := []string{}
+	if len(names) > 0 {
+		for i, v := range strings.Split(key, labelSep) {
+			pairs = append(pairs, fmt.Sprintf("%s=%s", names[i], strconv.Quote(v)))
+		}
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", extraName, strconv.Quote(extraValue)))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var (
+	GAMES_CREATED  = newCounter("macl_games_created_total", "Games created.")
+	GAMES_FINISHED = newCounter("macl_games_finished_total",
+		"Games finished by result, win or draw.", "result")
+	MOVES = newCounter("macl_moves_total",
+		"Moves accepted (OK) or rejected by move status.", "status")
+	QUITS = newCounter("macl_quits_total",
+		"Players leaving a game, by themselves or forfeited when idle.", "reason")
+	HTTP_LATENCY = newHistogram("macl_http_request_duration_seconds",
+		"Latency of HTTP requests by route.", LATENCY_BUCKETS, "route", "method", "code")
+)
+
+// countFinished counts the game as finished by its result.
+func (g *game) countFinished() {
+	if g.winner == "" {
+		GAMES_FINISHED.Inc("draw")
+	} else {
+		GAMES_FINISHED.Inc("win")
+	}
+}
+
+// Metrics returns every metric in the Prometheus text format.
+func Metrics() []byte {
+	buf := new(bytes.Buffer)
+	GAMES_CREATED.write(buf)
+	GAMES_FINISHED.write(buf)
+	MOVES.write(buf)
+	QUITS.write(buf)
+	HTTP_LATENCY.write(buf)
+
+	stats := GAMES.Stats()
+	writeGauge(buf, "macl_games_waiting", "Games waiting for open seats to be claimed.", stats.Waiting)
+	writeGauge(buf, "macl_games_in_progress", "Games in progress held by the server.", stats.InProgress)
+	writeGauge(buf, "macl_games_finished", "Finished games held by the server.", stats.Finished)
+	writeHeader(buf, "macl_games_evicted_total", "Finished and abandoned games archived and removed.", "counter")
+	fmt.Fprintf(buf, "macl_games_evicted_total %d\n", stats.Evictions)
+	return buf.Bytes()
+}
+
+// statusRecorder keeps the status code written by a handler. Streaming
+// handlers still need to flush and hijack the connection through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Flush() {
+	if f, ok := sr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (sr *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := sr.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection cannot be hijacked")
+	}
+	// An upgraded connection has switched protocols.
+	sr.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// metricsMiddleware times each request by the route template it matched, so
+// game and player ids do not each get their own series.
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sr, r)
+
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if tmpl, err := current.GetPathTemplate(); err == nil {
+				route = tmpl
+			}
+		}
+		HTTP_LATENCY.Observe(time.Since(start).Seconds(), route, r.Method, strconv.Itoa(sr.status))
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_formatLabels(t *testing.T) {
+	got := formatLabels([]string{"route", "method"}, "/game/{gameId}"+labelSep+"GET", "le", "0.5")
+	if got != `{route="/game/{gameId}",method="GET",le="0.5"}` {
+		t.Error("unexpected labels ", got)
+	}
+	if got = formatLabels(nil, "", "", ""); got != "" {
+		t.Error("expected no labels got ", got)
+	}
+}
+
+func Test_metricHistogram(t *testing.T) {
+	mh := newHistogram("latency", "Latency.", []float64{1, 2}, "route")
+	mh.Observe(0.5, "/a")
+	mh.Observe(2, "/a")
+	mh.Observe(3, "/a")
+	buf := new(bytes.Buffer)
+	mh.write(buf)
+	out := buf.String()
+	for _, line := range []string{
+		"# TYPE latency histogram",
+		`latency_bucket{route="/a",le="1"} 1`,
+		`latency_bucket{route="/a",le="2"} 2`,
+		`latency_bucket{route="/a",le="+Inf"} 3`,
+		`latency_sum{route="/a"} 5.5`,
+		`latency_count{route="/a"} 3`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Error("expected ", line, " in ", out)
+		}
+	}
+}
+
+func Test_gameMetrics(t *testing.T) {
+	created := GAMES_CREATED.Get()
+	wins := GAMES_FINISHED.Get("win")
+	ok := MOVES.Get(string(MoveOK))
+	wrongTurn := MOVES.Get(string(MoveWrongTurn))
+	quits := QUITS.Get("player")
+
+	g := CreateGame(4, 4, 4, "a", "b", "c")
+	g.id = "metrics"
+	GAMES.Add(g)
+	g.Move("a", 0)
+	g.Move("a", 0)
+	g.Quit("b")
+	g.Quit("c")
+
+	if GAMES_CREATED.Get()-created != 1 || GAMES_FINISHED.Get("win")-wins != 1 {
+		t.Error("expected a game created and won")
+	}
+	if MOVES.Get(string(MoveOK))-ok != 1 || MOVES.Get(string(MoveWrongTurn))-wrongTurn != 1 {
+		t.Error("expected a move accepted and one out of turn")
+	}
+	if QUITS.Get("player")-quits != 2 {
+		t.Error("expected 2 quits")
+	}
+
+	// Replaying stored moves does not count them again.
+	gr := g.record()
+	if _, err := gr.rebuild(); err != nil {
+		t.Fatal("unable to rebuild ", err)
+	}
+	if MOVES.Get(string(MoveOK))-ok != 1 || QUITS.Get("player")-quits != 2 {
+		t.Error("expected replayed moves not to be counted")
+	}
+}
+
+func Test_metricsHandler(t *testing.T) {
+	server := httptest.NewServer(configureRouter(*API_PREFIX))
+	defer server.Close()
+
+	g := CreateGame(4, 4, 4, "a", "b")
+	g.id = "scraped"
+	GAMES.Add(g)
+	resp, err := http.Get(server.URL + "/" + *API_PREFIX + "/scraped")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	resp, err = http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != METRICS_CONTENT_TYPE {
+		t.Error("unexpected content type ", resp.Header.Get("Content-Type"))
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	out := string(b)
+	for _, expected := range []string{
+		"# TYPE macl_games_created_total counter\n",
+		"# TYPE macl_games_in_progress gauge\n",
+		// Requests are labelled by route, not by game.
+		`macl_http_request_duration_seconds_count{route="/` + *API_PREFIX + `/{gameId}",method="GET",code="200"}`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Error("expected ", expected, " in ", out)
+		}
+	}
+	if strings.Contains(out, "scraped") {
+		t.Error("expected no game ids in metrics")
+	}
+}
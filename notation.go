@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NOTATION_CONTENT_TYPE is the content type of game records.
+const NOTATION_CONTENT_TYPE = "text/plain; charset=utf-8"
+
+// A game record is written like PGN: tag pairs, a blank line, then the moves.
+// Players are named in seat order by repeated Player tags and moves refer to
+// them by the seat symbol of the board renderings. Moves are numbered from 0
+// as in the moves endpoint; a coin dropped in a column is the seat symbol and
+// the column, a coin placed freely is the seat symbol, the row, a comma and
+// the column, a coin popped out is the seat symbol, P and the column, and a
+// quit is the seat symbol and Q, eg.
+//
+//	[Game "cats"]
+//	[Created "2020-01-02T15:04:05Z"]
+//	[Rows "6"]
+//	[Columns "7"]
+//	[WinLength "4"]
+//	[TimeControl "300+2"]
+//	[Player "alice"]
+//	[Player "bob"]
+//	[Result "alice"]
+//
+//	0. A3 1. B3 2. A4 3. BQ
+//
+// TimeControl is only written for timed games, as in PGN: seconds on the
+// clock plus the increment, or 1/seconds for a limit on every move. PopOut
+// and FreePlacement are only written, as true, for games played with them,
+// Topology only for boards with joined edges, Teams only for team games and
+// WinCondition only for games not won by the first line.
+// Result is the winner, draw, or * for a game in progress.
+const notationDraw = "draw"
+const notationInProgress = "*"
+
+// NotationMove is a move of a game record.
+type NotationMove struct {
+	Player string
+	Row    int
+	Column int
+	Quit   bool
+	Pop    bool
+}
+
+// Type is the type of move to make.
+func (nm *NotationMove) Type() MoveType {
+	if nm.Pop {
+		return MovePop
+	}
+	return MoveMove
+}
+
+// Notation is a parsed game record.
+type Notation struct {
+	Game    string
+	Created time.Time
+	Rules   Rules
+	Players []string
+	Result  string
+	Moves   []*NotationMove
+}
+
+// RecordError is a game record that cannot be read, at move Move, or before
+// the moves if Move is -1.
+type RecordError struct {
+	Move int
+	Msg  string
+}
+
+func (re *RecordError) Error() string {
+	if re.Move < 0 {
+		return re.Msg
+	}
+	return fmt.Sprintf("move %d: %s", re.Move, re.Msg)
+}
+
+func recordError(move int, format string, a ...interface{}) *RecordError {
+	return &RecordError{Move: move, Msg: fmt.Sprintf(format, a...)}
+}
+
+// Notation writes the full record of the game.
+func (g *game) Notation() string {
+	g.RLock()
+	defer g.RUnlock()
+
+	buf := new(bytes.Buffer)
+	tag := func(name, value string) {
+		fmt.Fprintf(buf, "[%s %s]\n", name, strconv.Quote(value))
+	}
+	tag("Game", g.id)
+	tag("Created", g.created.UTC().Format(time.RFC3339Nano))
+	tag("Rows", strconv.Itoa(g.rules.Rows))
+	tag("Columns", strconv.Itoa(g.rules.Columns))
+	tag("WinLength", strconv.Itoa(g.rules.WinLength))
+	if g.rules.timed() {
+		tag("TimeControl", timeControlTag(&g.rules))
+	}
+	if g.rules.PopOut {
+		tag("PopOut", "true")
+	}
+	if g.rules.FreePlacement {
+		tag("FreePlacement", "true")
+	}
+	if g.rules.Topology != "" {
+		tag("Topology", string(g.rules.Topology))
+	}
+	if g.rules.Teams > 0 {
+		tag("Teams", strconv.Itoa(g.rules.Teams))
+	}
+	if g.rules.WinCondition != "" {
+		tag("WinCondition", string(g.rules.WinCondition))
+	}
+	seats := map[string]int{}
+	for seat, player := range g.playerList {
+		seats[player] = seat
+		tag("Player", player)
+	}
+	tag("Result", g.notationResult())
+	buf.WriteString("\n")
+
+	// Wrap the moves to keep lines short.
+	line := 0
+	for i, m := range g.moves {
+		move := fmt.Sprintf("%d. %s", i, seatSymbol(seats[m.player]))
+		switch m.Type {
+		case MoveQuit:
+			move += "Q"
+		case MovePop:
+			move += "P" + strconv.Itoa(m.col)
+		default:
+			if g.rules.FreePlacement {
+				move += strconv.Itoa(m.row) + ","
+			}
+			move += strconv.Itoa(m.col)
+		}
+		if line > 0 && line+len(move) >= 80 {
+			buf.WriteString("\n")
+			line = 0
+		} else if line > 0 {
+			buf.WriteString(" ")
+			line++
+		}
+		buf.WriteString(move)
+		line += len(move)
+	}
+	if line > 0 {
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+func timeControlTag(rules *Rules) string {
+	if rules.MoveSeconds > 0 {
+		return fmt.Sprintf("1/%d", rules.MoveSeconds)
+	}
+	return fmt.Sprintf("%d+%d", rules.ClockSeconds, rules.IncrementSeconds)
+}
+
+// parseTimeControl sets the time controls of rules from a TimeControl tag.
+func parseTimeControl(value string, rules *Rules) error {
+	var err error
+	switch {
+	case value == "-":
+	case strings.HasPrefix(value, "1/"):
+		rules.MoveSeconds, err = strconv.Atoi(strings.TrimPrefix(value, "1/"))
+	default:
+		parts := strings.SplitN(value, "+", 2)
+		rules.ClockSeconds, err = strconv.Atoi(parts[0])
+		if err == nil && len(parts) == 2 {
+			rules.IncrementSeconds, err = strconv.Atoi(parts[1])
+		}
+	}
+	if err != nil {
+		return recordError(-1, "invalid TimeControl %s", value)
+	}
+	return nil
+}
+
+// notationResult is the Result tag of the game.
+func (g *game) notationResult() string {
+	if !g.over {
+		return notationInProgress
+	}
+	if g.winner == "" {
+		return notationDraw
+	}
+	return g.winner
+}
+
+// result is the Result tag of the game.
+func (g *game) result() string {
+	g.RLock()
+	defer g.RUnlock()
+	return g.notationResult()
+}
+
+// replayNotation makes a move of a record, returning the status of the move,
+// or of the quit if it is one.
+func (g *game) replayNotation(nm *NotationMove) (MoveStatus, GameStatus) {
+	g.Lock()
+	defer g.Unlock()
+	if nm.Quit {
+		return "", g.quit(nm.Player)
+	}
+	_, status := g.move(nm.Player, nm.Type(), nm.Row, nm.Column)
+	return status, ""
+}
+
+// ParseNotation reads a game record. Rules left out are zero, players must be
+// named.
+func ParseNotation(text string) (*Notation, error) {
+	n := &Notation{Result: notationInProgress}
+	sc := bufio.NewScanner(strings.NewReader(text))
+	movetext := []string{}
+	inTags := true
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if inTags && strings.HasPrefix(line, "[") {
+			if err := n.parseTag(line); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		inTags = false
+		movetext = append(movetext, strings.Fields(line)...)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, recordError(-1, "%s", err)
+	}
+	if len(n.Players) == 0 {
+		return nil, recordError(-1, "no Player tags")
+	}
+
+	symbols := map[string]string{}
+	for seat, player := range n.Players {
+		symbols[seatSymbol(seat)] = player
+	}
+	if len(movetext)%2 != 0 {
+		return nil, recordError(len(movetext)/2, "missing move")
+	}
+	for i := 0; i < len(movetext); i += 2 {
+		num := i / 2
+		if movetext[i] != fmt.Sprintf("%d.", num) {
+			return nil, recordError(num, "expected move number %d. got %s", num, movetext[i])
+		}
+		move := movetext[i+1]
+		player, ok := symbols[move[:1]]
+		if !ok {
+			return nil, recordError(num, "unknown seat in %s", move)
+		}
+		nm := &NotationMove{Player: player}
+		if move[1:] == "Q" {
+			nm.Quit = true
+		} else {
+			column := move[1:]
+			if strings.HasPrefix(column, "P") {
+				nm.Pop = true
+				column = column[1:]
+			}
+			if parts := strings.SplitN(column, ",", 2); len(parts) == 2 {
+				row, err := strconv.Atoi(parts[0])
+				if err != nil {
+					return nil, recordError(num, "invalid row in %s", move)
+				}
+				nm.Row = row
+				column = parts[1]
+			}
+			col, err := strconv.Atoi(column)
+			if err != nil {
+				return nil, recordError(num, "invalid column in %s", move)
+			}
+			nm.Column = col
+		}
+		n.Moves = append(n.Moves, nm)
+	}
+	return n, nil
+}
+
+func (n *Notation) parseTag(line string) error {
+	if !strings.HasSuffix(line, "]") {
+		return recordError(-1, "unterminated tag %s", line)
+	}
+	parts := strings.SplitN(strings.TrimSuffix(strings.TrimPrefix(line, "["), "]"), " ", 2)
+	if len(parts) != 2 {
+		return recordError(-1, "tag without a value %s", line)
+	}
+	name := parts[0]
+	value, err := strconv.Unquote(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return recordError(-1, "invalid %s tag value", name)
+	}
+
+	number := func() (int, error) {
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, recordError(-1, "invalid %s %s", name, value)
+		}
+		return v, nil
+	}
+	switch name {
+	case "Game":
+		n.Game = value
+	case "Created":
+		n.Created, err = time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return recordError(-1, "invalid Created %s", value)
+		}
+	case "Rows":
+		n.Rules.Rows, err = number()
+	case "Columns":
+		n.Rules.Columns, err = number()
+	case "WinLength":
+		n.Rules.WinLength, err = number()
+	case "TimeControl":
+		err = parseTimeControl(value, &n.Rules)
+	case "PopOut":
+		n.Rules.PopOut, err = strconv.ParseBool(value)
+		if err != nil {
+			return recordError(-1, "invalid PopOut %s", value)
+		}
+	case "Topology":
+		n.Rules.Topology = Topology(value)
+	case "Teams":
+		n.Rules.Teams, err = number()
+	case "WinCondition":
+		n.Rules.WinCondition = WinCondition(value)
+	case "FreePlacement":
+		n.Rules.FreePlacement, err = strconv.ParseBool(value)
+		if err != nil {
+			return recordError(-1, "invalid FreePlacement %s", value)
+		}
+	case "Player":
+		n.Players = append(n.Players, value)
+	case "Result":
+		n.Result = value
+	}
+	// Unknown tags are ignored.
+	return err
+}
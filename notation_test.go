@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func importRecord(t *testing.T, record string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", apiURL("import"), strings.NewReader(record))
+	w := httptest.NewRecorder()
+	importHandler(w, r)
+	return w
+}
+
+func Test_Notation(t *testing.T) {
+	g := CreateGameWithRules(&Rules{Rows: 4, Columns: 5, WinLength: 3, Players: 3}, "a", "b c", "bot:easy")
+	g.id = "notation"
+	g.bots = map[string]BotLevel{}
+	g.makeMove("a", 0)
+	g.makeMove("b c", 1)
+	g.quit("bot:easy")
+	g.makeMove("a", 0)
+	g.makeMove("b c", 4)
+	g.makeMove("a", 0)
+
+	expected := `[Game "notation"]
+[Created "` + g.created.UTC().Format("2006-01-02T15:04:05.999999999Z07:00") + `"]
+[Rows "4"]
+[Columns "5"]
+[WinLength "3"]
+[Player "a"]
+[Player "b c"]
+[Player "bot:easy"]
+[Result "a"]
+
+0. A0 1. B1 2. CQ 3. A0 4. B4 5. A0
+`
+	if got := g.Notation(); got != expected {
+		t.Error("expected ", expected, " got ", got)
+	}
+
+	n, err := ParseNotation(expected)
+	if err != nil {
+		t.Fatal("unable to parse ", err)
+	}
+	if n.Rules != (Rules{Rows: 4, Columns: 5, WinLength: 3}) || n.Result != "a" ||
+		strings.Join(n.Players, ",") != "a,b c,bot:easy" || len(n.Moves) != 6 {
+		t.Error("unexpected record ", *n)
+	}
+	if !n.Moves[2].Quit || n.Moves[2].Player != "bot:easy" || n.Moves[4].Column != 4 {
+		t.Error("unexpected moves ", *n.Moves[2], *n.Moves[4])
+	}
+}
+
+func Test_NotationTimeControl(t *testing.T) {
+	for _, rules := range []Rules{
+		{Rows: 4, Columns: 4, WinLength: 4, Players: 2, ClockSeconds: 300, IncrementSeconds: 2},
+		{Rows: 4, Columns: 4, WinLength: 4, Players: 2, MoveSeconds: 30},
+		{Rows: 4, Columns: 4, WinLength: 4, Players: 2, Topology: TopologyTorus},
+		{Rows: 4, Columns: 4, WinLength: 4, Players: 4, Teams: 2},
+		{Rows: 4, Columns: 4, WinLength: 4, Players: 2, WinCondition: WinMisere},
+	} {
+		g := CreateGameWithRules(&rules, []string{"a", "b", "c", "d"}[:rules.Players]...)
+		n, err := ParseNotation(g.Notation())
+		n.Rules.Players = len(n.Players)
+		if err != nil || n.Rules != rules {
+			t.Error("expected ", rules, " got ", n.Rules, err)
+		}
+	}
+	if _, err := ParseNotation("[TimeControl \"5m\"]\n[Player \"a\"]\n"); err == nil {
+		t.Error("expected an invalid TimeControl to be rejected")
+	}
+}
+
+func Test_NotationPopOut(t *testing.T) {
+	rules := &Rules{Rows: 4, Columns: 4, WinLength: 4, Players: 2, PopOut: true}
+	g := CreateGameWithRules(rules, "a", "b")
+	g.Move("a", 0)
+	g.Move("b", 0)
+	g.Pop("a", 0)
+	text := g.Notation()
+	if !strings.Contains(text, "[PopOut \"true\"]") || !strings.Contains(text, "0. A0 1. B0 2. AP0\n") {
+		t.Error("unexpected record ", text)
+	}
+
+	n, err := ParseNotation(text)
+	if err != nil || !n.Rules.PopOut || !n.Moves[2].Pop || n.Moves[2].Column != 0 {
+		t.Fatal("expected the pop to be read back got ", err)
+	}
+	imported := CreateGameWithRules(&n.Rules, n.Players...)
+	if APIerr := replayRecord(imported, n); APIerr != nil || imported.board[3][0] != "b" {
+		t.Error("expected the pop to be replayed got ", APIerr, imported.board)
+	}
+}
+
+func Test_NotationFreePlacement(t *testing.T) {
+	rules := &Rules{Rows: 3, Columns: 3, WinLength: 3, Players: 2, FreePlacement: true}
+	g := CreateGameWithRules(rules, "x", "o")
+	g.Place("x", 1, 1)
+	g.Place("o", 0, 2)
+	text := g.Notation()
+	if !strings.Contains(text, "[FreePlacement \"true\"]") || !strings.Contains(text, "0. A1,1 1. B0,2\n") {
+		t.Error("unexpected record ", text)
+	}
+
+	n, err := ParseNotation(text)
+	if err != nil || !n.Rules.FreePlacement || n.Moves[1].Row != 0 || n.Moves[1].Column != 2 {
+		t.Fatal("expected both coordinates to be read back got ", err)
+	}
+	imported := CreateGameWithRules(&n.Rules, n.Players...)
+	if APIerr := replayRecord(imported, n); APIerr != nil || imported.board[1][1] != "x" || imported.board[0][2] != "o" {
+		t.Error("expected the moves to be replayed got ", APIerr, imported.board)
+	}
+}
+
+func Test_NotationWraps(t *testing.T) {
+	g := CreateGame(4, 20, 20, "a", "b")
+	for i := 0; i < 40; i++ {
+		g.makeMove([]string{"a", "b"}[i%2], i%20)
+	}
+	for _, line := range strings.Split(g.Notation(), "\n") {
+		if len(line) > 80 {
+			t.Error("expected lines of at most 80 got ", line)
+		}
+	}
+	n, err := ParseNotation(g.Notation())
+	if err != nil || len(n.Moves) != 40 {
+		t.Error("expected 40 moves got ", err)
+	}
+}
+
+func Test_importHandler(t *testing.T) {
+	g := CreateGame(4, 4, 4, "a", "b")
+	g.id = "exported"
+	GAMES.Add(g)
+	g.Move("a", 1)
+	g.Move("b", 2)
+
+	r := httptest.NewRequest("GET", apiURL("exported/record"), nil)
+	r = mux.SetURLVars(r, map[string]string{"gameId": "exported"})
+	w := httptest.NewRecorder()
+	recordHandler(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != NOTATION_CONTENT_TYPE {
+		t.Fatal("expected a record got ", w.Code)
+	}
+
+	// An in-progress game carries on where the record stops.
+	w = importRecord(t, w.Body.String())
+	err := expectWithWriter(w, http.StatusOK, `{"gameId":"cats","tokens":{"a":"secret","b":"secret"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, _ := GAMES.Get("cats")
+	if imported == g || len(imported.moves) != 2 || imported.nextMove() != "a" {
+		t.Error("expected the moves to be replayed in a new game")
+	}
+
+	// An engine player whose turn it is moves once the game is imported.
+	w = importRecord(t, "[Player \"a\"]\n[Player \"bot:easy\"]\n\n0. A3\n")
+	err = expectWithWriter(w, http.StatusOK, `{"gameId":"cats","tokens":{"a":"secret"}}`)
+	if err != nil {
+		t.Error(err)
+	}
+	imported, _ = GAMES.Get("cats")
+	if len(imported.moves) != 2 || imported.nextMove() != "a" {
+		t.Error("expected the engine player to have moved")
+	}
+
+	for _, c := range []struct {
+		record   string
+		expected string
+	}{
+		{"[Player \"a\"]\n[Player \"b\"]\n\n0. A1 1. B1 2. B1\n",
+			`{"error":{"code":"WRONG_TURN","message":"it is not b's turn","move":2}}`},
+		{"[Player \"a\"]\n[Player \"b\"]\n[Rows \"3\"]\n\n0. A1 1. B1 2. A1 3. B1\n",
+			`{"error":{"code":"BAD_REQUEST","message":"column 1 is full","field":"column","move":3}}`},
+		{"[Player \"a\"]\n[Player \"b\"]\n\n0. A1 1. BQ 2. A1\n",
+			`{"error":{"code":"GAME_OVER","message":"game is over","move":2}}`},
+		{"[Player \"a\"]\n[Player \"b\"]\n[Player \"c\"]\n\n0. AQ 1. AQ\n",
+			`{"error":{"code":"ALREADY_LEFT","message":"a has already left this game","move":1}}`},
+		{"[Player \"a\"]\n[Player \"b\"]\n[Result \"a\"]\n\n0. A1\n",
+			`{"error":{"code":"INVALID_RECORD","message":"result a does not follow from the moves","field":"Result","move":1}}`},
+		{"[Player \"a\"]\n[Player \"b\"]\n\n0. A1 2. B1\n",
+			`{"error":{"code":"INVALID_RECORD","message":"expected move number 1. got 2.","move":1}}`},
+		{"[Player \"a\"]\n[Player \"b\"]\n\n0. A1 1. C1\n",
+			`{"error":{"code":"INVALID_RECORD","message":"unknown seat in C1","move":1}}`},
+		{"[Player \"a\"]\n[Player \"b\"]\n\n0. A1 1.\n",
+			`{"error":{"code":"INVALID_RECORD","message":"missing move","move":1}}`},
+		{"[Player \"a\"]\n[Player \"b\"]\n\n0. Ax\n",
+			`{"error":{"code":"INVALID_RECORD","message":"invalid column in Ax","move":0}}`},
+		{"[Player a]\n",
+			`{"error":{"code":"INVALID_RECORD","message":"invalid Player tag value"}}`},
+		{"0. A1\n",
+			`{"error":{"code":"INVALID_RECORD","message":"no Player tags"}}`},
+		{"[Player \"a\"]\n[Player \"a\"]\n",
+			`{"error":{"code":"INVALID_PLAYERS","message":"duplicate player a","field":"players"}}`},
+		{"[Player \"a\"]\n[Player \"b\"]\n[Rows \"30\"]\n",
+			`{"error":{"code":"INVALID_RULES","message":"rows must be between 3 and 20, got 30","field":"rows"}}`},
+	} {
+		w = importRecord(t, c.record)
+		if err := expectWithWriter(w, http.StatusBadRequest, c.expected); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func Test_importRoundTrip(t *testing.T) {
+	g := CreateGame(3, 4, 4, "a", "b", "c")
+	for _, col := range []int{0, 1, 2, 0, 1, 2} {
+		g.makeMove(g.nextMove(), col)
+	}
+	g.quit("c")
+	g.makeMove("a", 0)
+
+	// Imported moves, and those of records rejected, are not counted.
+	wins := GAMES_FINISHED.Get("win")
+	ok := MOVES.Get(string(MoveOK))
+	wrongTurn := MOVES.Get(string(MoveWrongTurn))
+	quits := QUITS.Get("player")
+	importRecord(t, "[Player \"a\"]\n[Player \"b\"]\n\n0. A1 1. B1 2. B1\n")
+	w := importRecord(t, g.Notation())
+	if GAMES_FINISHED.Get("win") != wins || MOVES.Get(string(MoveOK)) != ok ||
+		MOVES.Get(string(MoveWrongTurn)) != wrongTurn || QUITS.Get("player") != quits {
+		t.Error("expected imports not to be counted")
+	}
+	if w.Code != http.StatusOK {
+		t.Fatal("expected the record to import got ", w.Body.String())
+	}
+	cgr := &CreateGameResponse{}
+	json.NewDecoder(w.Body).Decode(cgr)
+	imported, _ := GAMES.Get(cgr.GameId)
+	if strings.SplitN(imported.Notation(), "\n", 3)[2] != strings.SplitN(g.Notation(), "\n", 3)[2] {
+		t.Error("expected the same record got ", imported.Notation())
+	}
+}
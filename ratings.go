@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+// Glicko-2 defaults for a player's first game, in the Glicko scale.
+const (
+	glickoRating     = 1500.0
+	glickoRD         = 350.0
+	glickoVolatility = 0.06
+
+	// glickoTau limits how fast volatility changes.
+	glickoTau = 0.5
+
+	// glickoScale converts between the Glicko and Glicko-2 scales.
+	glickoScale = 173.7178
+
+	glickoEpsilon = 0.000001
+)
+
+// Outcome is how a game ended for one player.
+type Outcome string
+
+var OutcomeWin = Outcome("WIN")
+var OutcomeLoss = Outcome("LOSS")
+var OutcomeDraw = Outcome("DRAW")
+var OutcomeForfeit = Outcome("FORFEIT")
+
+// PlayerRating is a player's Glicko-2 rating and record of finished games.
+type PlayerRating struct {
+	Id         string  `json:"id"`
+	Rating     float64 `json:"rating"`
+	RD         float64 `json:"rd"`
+	Volatility float64 `json:"volatility"`
+
+	Games    int `json:"games"`
+	Wins     int `json:"wins"`
+	Losses   int `json:"losses"`
+	Draws    int `json:"draws"`
+	Forfeits int `json:"forfeits"`
+}
+
+func newPlayerRating(id string) *PlayerRating {
+	return &PlayerRating{
+		Id:         id,
+		Rating:     glickoRating,
+		RD:         glickoRD,
+		Volatility: glickoVolatility,
+	}
+}
+
+// Before returns if pr ranks above other on the leaderboard.
+func (pr *PlayerRating) Before(other *PlayerRating) bool {
+	if pr.Rating != other.Rating {
+		return pr.Rating > other.Rating
+	}
+	return pr.Id < other.Id
+}
+
+// gameResult is how a finished game ended for a player, ranked as in the
+// game's standings. Side is the player's team, or the player without teams.
+type gameResult struct {
+	Player  string
+	Side    string
+	Outcome Outcome
+	Rank    int
+	Rated   bool
+}
+
+// results returns the result of the finished game for each player.
+func (g *game) results() []*gameResult {
+	ranks := map[string]int{}
+	for _, s := range g.standings() {
+		ranks[s.Player] = s.Rank
+	}
+	knockedOut := map[string]bool{}
+	for _, out := range g.knockedOut {
+		for _, player := range out {
+			knockedOut[player] = true
+		}
+	}
+	results := []*gameResult{}
+	for _, player := range g.playerList {
+		r := &gameResult{Player: player, Side: g.side(player), Rank: ranks[player], Rated: g.rated[player]}
+		switch {
+		case !g.players[player] && !knockedOut[player]:
+			r.Outcome = OutcomeForfeit
+		case g.winner != "" && g.side(player) == g.side(g.winner):
+			r.Outcome = OutcomeWin
+		case g.winner == "" && r.Rank == 1:
+			r.Outcome = OutcomeDraw
+		default:
+			r.Outcome = OutcomeLoss
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// Ratings holds every player's rating, updated as games finish.
+type Ratings struct {
+	sync.Mutex
+	players map[string]*PlayerRating
+	store   Store
+}
+
+// NewRatings returns the ratings recovered from store.
+func NewRatings(store Store) (*Ratings, error) {
+	saved, err := store.LoadRatings()
+	if err != nil {
+		return nil, err
+	}
+	rt := &Ratings{
+		players: map[string]*PlayerRating{},
+		store:   store,
+	}
+	for _, pr := range saved {
+		rt.players[pr.Id] = pr
+	}
+	return rt, nil
+}
+
+func (rt *Ratings) Get(playerId string) (*PlayerRating, bool) {
+	rt.Lock()
+	defer rt.Unlock()
+	pr, ok := rt.players[playerId]
+	if !ok {
+		return nil, false
+	}
+	copied := *pr
+	return &copied, true
+}
+
+// Leaderboard returns every player from the highest rated down.
+func (rt *Ratings) Leaderboard() []*PlayerRating {
+	rt.Lock()
+	defer rt.Unlock()
+	players := []*PlayerRating{}
+	for _, pr := range rt.players {
+		copied := *pr
+		players = append(players, &copied)
+	}
+	sort.Slice(players, func(i, j int) bool {
+		return players[i].Before(players[j])
+	})
+	return players
+}
+
+// Record updates the ratings of everyone in a finished game. A game of more
+// than two players is rated as a match between each pair of them on opposing
+// sides, scored by who finished ahead. Engine players and players who were
+// not handed their own token are left out, so ratings cannot be farmed.
+func (rt *Ratings) Record(results []*gameResult) {
+	rt.Lock()
+	defer rt.Unlock()
+
+	rated := []*gameResult{}
+	sides := map[string]bool{}
+	for _, r := range results {
+		if r.Rated && !isBot(r.Player) {
+			rated = append(rated, r)
+			sides[r.Side] = true
+		}
+	}
+	if len(sides) < 2 {
+		return
+	}
+	results = rated
+
+	// Every pairing is rated against the ratings from before the game.
+	before := map[string]PlayerRating{}
+	for _, r := range results {
+		pr, ok := rt.players[r.Player]
+		if !ok {
+			pr = newPlayerRating(r.Player)
+			rt.players[r.Player] = pr
+		}
+		before[r.Player] = *pr
+	}
+	for _, r := range results {
+		opponents := []glickoOpponent{}
+		for _, other := range results {
+			if other.Side == r.Side {
+				continue
+			}
+			score := 0.5
+			if r.Rank < other.Rank {
+				score = 1
+			} else if r.Rank > other.Rank {
+				score = 0
+			}
+			opp := before[other.Player]
+			opponents = append(opponents, glickoOpponent{opp.Rating, opp.RD, score})
+		}
+		pr := rt.players[r.Player]
+		pr.Rating, pr.RD, pr.Volatility = glickoUpdate(before[r.Player], opponents)
+		pr.Games++
+		switch r.Outcome {
+		case OutcomeWin:
+			pr.Wins++
+		case OutcomeLoss:
+			pr.Losses++
+		case OutcomeDraw:
+			pr.Draws++
+		case OutcomeForfeit:
+			pr.Forfeits++
+		}
+	}
+
+	saved := []*PlayerRating{}
+	for _, pr := range rt.players {
+		saved = append(saved, pr)
+	}
+	if err := rt.store.SaveRatings(saved); err != nil {
+		LOGGER.Println(fmt.Sprintf("failed to store ratings: %s", err))
+	}
+}
+
+type glickoOpponent struct {
+	rating float64
+	rd     float64
+	score  float64
+}
+
+func glickoG(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
+
+// glickoUpdate returns the rating, rating deviation and volatility of a player
+// after a rating period against opponents, following Glickman's "Example of
+// the Glicko-2 system".
+func glickoUpdate(pr PlayerRating, opponents []glickoOpponent) (float64, float64, float64) {
+	mu := (pr.Rating - glickoRating) / glickoScale
+	phi := pr.RD / glickoScale
+	sigma := pr.Volatility
+	if len(opponents) == 0 {
+		phi = math.Sqrt(phi*phi + sigma*sigma)
+		return pr.Rating, math.Min(phi*glickoScale, glickoRD), sigma
+	}
+
+	var vInv, improvement float64
+	for _, opp := range opponents {
+		muJ := (opp.rating - glickoRating) / glickoScale
+		g := glickoG(opp.rd / glickoScale)
+		e := 1 / (1 + math.Exp(-g*(mu-muJ)))
+		vInv += g * g * e * (1 - e)
+		improvement += g * (opp.score - e)
+	}
+	v := 1 / vInv
+	delta := v * improvement
+
+	// Find the new volatility with the Illinois algorithm.
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-phi*phi-v-ex)/(2*d*d) - (x-a)/(glickoTau*glickoTau)
+	}
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*glickoTau) < 0 {
+			k++
+		}
+		B = a - k*glickoTau
+	}
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > glickoEpsilon {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+	sigma = math.Exp(A / 2)
+
+	phiStar := math.Sqrt(phi*phi + sigma*sigma)
+	phi = 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	mu += phi * phi * improvement
+	return glickoScale*mu + glickoRating, math.Min(glickoScale*phi, glickoRD), sigma
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func Test_glickoUpdate(t *testing.T) {
+	// The worked example from Glickman's "Example of the Glicko-2 system".
+	pr := PlayerRating{Rating: 1500, RD: 200, Volatility: 0.06}
+	rating, rd, vol := glickoUpdate(pr, []glickoOpponent{
+		{1400, 30, 1},
+		{1550, 100, 0},
+		{1700, 300, 0},
+	})
+	if math.Abs(rating-1464.06) > 0.01 || math.Abs(rd-151.52) > 0.01 || math.Abs(vol-0.05999) > 0.00001 {
+		t.Error("expected 1464.06 151.52 0.05999 got ", rating, rd, vol)
+	}
+}
+
+// ratedGame returns a game every player is rated in, as if they had joined.
+func ratedGame(rules *Rules, players ...string) *game {
+	g := CreateGameWithRules(rules, players...)
+	for _, player := range players {
+		g.rated[player] = true
+	}
+	return g
+}
+
+func Test_RatingsRecord(t *testing.T) {
+	rt, _ := NewRatings(&memoryStore{})
+	g := ratedGame(&Rules{Rows: 4, Columns: 4, WinLength: 4, Players: 3}, "a", "b", "c")
+	g.players["c"] = false
+	g.over = true
+	g.winner = "a"
+	rt.Record(g.results())
+
+	a, _ := rt.Get("a")
+	b, _ := rt.Get("b")
+	c, _ := rt.Get("c")
+	if a.Wins != 1 || b.Losses != 1 || c.Forfeits != 1 || a.Games != 1 {
+		t.Error("unexpected records ", *a, *b, *c)
+	}
+	// Each pair is scored by who finished ahead.
+	if !(a.Rating > b.Rating && b.Rating > c.Rating) {
+		t.Error("expected a above b above c got ", a.Rating, b.Rating, c.Rating)
+	}
+	if a.RD >= glickoRD {
+		t.Error("expected the rating deviation to shrink got ", a.RD)
+	}
+
+	g = ratedGame(&Rules{Rows: 4, Columns: 4, WinLength: 4, Players: 2}, "a", "d")
+	g.over = true
+	rt.Record(g.results())
+	d, _ := rt.Get("d")
+	a, _ = rt.Get("a")
+	if a.Draws != 1 || d.Draws != 1 || d.Rating <= glickoRating {
+		t.Error("expected a draw against a stronger player to gain rating got ", d.Rating)
+	}
+
+	board := rt.Leaderboard()
+	if len(board) != 4 || board[0].Id != "a" || board[3].Id != "c" {
+		t.Error("unexpected leaderboard ", board)
+	}
+	// Teammates are only rated against the other team.
+	rt, _ = NewRatings(&memoryStore{})
+	rules := &Rules{Rows: 4, Columns: 4, WinLength: 4, Players: 4, Teams: 2}
+	g = ratedGame(rules, "a", "b", "c", "d")
+	g.over = true
+	g.winner = "a"
+	rt.Record(g.results())
+	fresh := *newPlayerRating("a")
+	rating, _, _ := glickoUpdate(fresh, []glickoOpponent{
+		{fresh.Rating, fresh.RD, 1},
+		{fresh.Rating, fresh.RD, 1},
+	})
+	a, _ = rt.Get("a")
+	c, _ = rt.Get("c")
+	if a.Rating != rating || c.Rating != rating || a.Wins != 1 || c.Wins != 1 {
+		t.Error("expected ", rating, " got ", a.Rating, c.Rating)
+	}
+
+	// Engine players and seats named by whoever created the game are not.
+	rt, _ = NewRatings(&memoryStore{})
+	g = CreateGame(4, 4, 4, "named", "b")
+	g.rated["b"] = true
+	g.over = true
+	g.winner = "b"
+	rt.Record(g.results())
+	g = ratedGame(&Rules{Rows: 4, Columns: 4, WinLength: 4, Players: 2}, "a", "bot:easy")
+	g.over = true
+	g.winner = "a"
+	rt.Record(g.results())
+	if board := rt.Leaderboard(); len(board) != 0 {
+		t.Error("expected nobody to be rated got ", board)
+	}
+}
+
+func Test_gamesAreRated(t *testing.T) {
+	dir := t.TempDir()
+	gc := openTestStore(t, dir)
+
+	g := CreateGameWithRules(&Rules{Rows: 4, Columns: 4, WinLength: 4, Players: 3}, "bot:easy")
+	g.public = true
+	gc.Add(g)
+	g.Join("rated", "")
+	g.Join("joined", "")
+	g.Quit("joined")
+	for !g.over {
+		col := 0
+		for g.board[0][col] != "" {
+			col++
+		}
+		g.Move("rated", col)
+	}
+	pr, ok := gc.ratings.Get("rated")
+	if !ok || pr.Games != 1 {
+		t.Fatal("expected the finished game to be rated")
+	}
+	if _, ok = gc.ratings.Get("bot:easy"); ok {
+		t.Error("expected the engine player not to be rated")
+	}
+
+	// A game that is not held by the container is not rated.
+	other := CreateGame(4, 4, 4, "unrated", "b")
+	other.Quit("b")
+	if _, ok = gc.ratings.Get("unrated"); ok {
+		t.Error("expected a game outside the container not to be rated")
+	}
+	gc.store.Close()
+
+	gc = openTestStore(t, dir)
+	again, ok := gc.ratings.Get("rated")
+	if !ok || *again != *pr {
+		t.Error("expected the rating to be stored got ", again)
+	}
+	gc.store.Close()
+}
+
+func Test_leaderboardHandler(t *testing.T) {
+	for _, players := range [][]string{{"top", "middle"}, {"middle", "bottom"}} {
+		g := ratedGame(&Rules{Rows: 4, Columns: 4, WinLength: 4, Players: 2}, players...)
+		g.id = "leaderboard"
+		GAMES.Add(g)
+		g.Quit(players[1])
+	}
+
+	r := httptest.NewRequest("GET", apiURL("players/top"), nil)
+	r = mux.SetURLVars(r, map[string]string{"playerId": "top"})
+	w := httptest.NewRecorder()
+	playerHandler(w, r)
+	pr := &PlayerRating{}
+	json.NewDecoder(w.Body).Decode(pr)
+	if w.Code != http.StatusOK || pr.Id != "top" || pr.Wins != 1 || pr.Games != 1 {
+		t.Error("unexpected player ", w.Code, *pr)
+	}
+
+	r = httptest.NewRequest("GET", apiURL("players/nobody"), nil)
+	r = mux.SetURLVars(r, map[string]string{"playerId": "nobody"})
+	w = httptest.NewRecorder()
+	playerHandler(w, r)
+	err := expectWithWriter(w, http.StatusNotFound,
+		`{"error":{"code":"UNKNOWN_PLAYER","message":"nobody has not finished a game"}}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// Page through the whole leaderboard one player at a time.
+	expected := GAMES.ratings.Leaderboard()
+	cursor := ""
+	for i, want := range expected {
+		r = httptest.NewRequest("GET", apiURL("leaderboard")+"?limit=1&cursor="+cursor, nil)
+		w = httptest.NewRecorder()
+		leaderboardHandler(w, r)
+		lr := &LeaderboardResponse{}
+		json.NewDecoder(w.Body).Decode(lr)
+		if len(lr.Players) != 1 || lr.Players[0].Id != want.Id || lr.Players[0].Rank != i+1 {
+			t.Fatal("expected ", want.Id, " at ", i+1, " got ", lr.Players)
+		}
+		if (lr.Next == "") != (i == len(expected)-1) {
+			t.Error("expected a next page until the last player")
+		}
+		cursor = lr.Next
+	}
+
+	r = httptest.NewRequest("GET", apiURL("leaderboard")+"?limit=0", nil)
+	w = httptest.NewRecorder()
+	leaderboardHandler(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Error("expected a bad limit to be rejected got ", w.Code)
+	}
+}
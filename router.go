@@ -3,23 +3,65 @@ package main
 import (
 	"fmt"
 	"github.com/gorilla/mux"
+	"net/http"
 )
 
 // configureRouter allows leading URI customization for the API routes.
 func configureRouter(custom string) *mux.Router {
 	r := mux.NewRouter()
 	r.StrictSlash(true)
+	r.Use(metricsMiddleware)
+	r.NotFoundHandler = http.HandlerFunc(notFoundHandler)
+	r.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowedHandler)
+
+	// Prometheus metrics.
+	r.HandleFunc("/metrics", metricsHandler).Methods("GET")
 
 	// GET in-progress games.
 	// POST new game.
 	r.HandleFunc(fmt.Sprintf("/%s", custom), gameHandler).Methods("GET", "POST")
 
-	// Status of a game.
-	r.HandleFunc(fmt.Sprintf("/%s/{gameId}", custom), gameStatusHandler).Methods("GET")
+	// Events of every game.
+	r.HandleFunc(fmt.Sprintf("/%s/events", custom), lobbyEventsHandler).Methods("GET")
+
+	// Create a game from a record.
+	r.HandleFunc(fmt.Sprintf("/%s/import", custom), importHandler).Methods("POST")
+
+	// Wait for a game against players of a similar rating.
+	r.HandleFunc(fmt.Sprintf("/%s/matchmaking", custom), matchmakingHandler).Methods("POST")
+	r.HandleFunc(
+		fmt.Sprintf("/%s/matchmaking/{ticketId}", custom), ticketHandler).Methods("GET", "DELETE")
+
+	// Claim the open seat an invite was made for.
+	r.HandleFunc(fmt.Sprintf("/%s/invites/{invite}", custom), inviteHandler).Methods("POST")
+
+	// Player ratings.
+	r.HandleFunc(fmt.Sprintf("/%s/leaderboard", custom), leaderboardHandler).Methods("GET")
+	r.HandleFunc(fmt.Sprintf("/%s/players/{playerId}", custom), playerHandler).Methods("GET")
+
+	// Counts of games held and removed.
+	r.HandleFunc(fmt.Sprintf("/%s/stats", custom), statsHandler).Methods("GET")
+
+	// GET status of a game.
+	// POST claim an open seat of a public game.
+	r.HandleFunc(fmt.Sprintf("/%s/{gameId}", custom), gameStatusHandler).Methods("GET", "POST")
+
+	// The board of a game as JSON, ASCII, a position string or SVG.
+	r.HandleFunc(fmt.Sprintf("/%s/{gameId}/board", custom), boardHandler).Methods("GET")
+
+	// The record of a game.
+	r.HandleFunc(fmt.Sprintf("/%s/{gameId}/record", custom), recordHandler).Methods("GET")
 
 	// Get all or some moves in a game.
 	r.HandleFunc(fmt.Sprintf("/%s/{gameId}/moves", custom), moveListHandler).Methods("GET")
 
+	// Stream events as they happen over a WebSocket or as Server-Sent Events.
+	r.HandleFunc(fmt.Sprintf("/%s/{gameId}/ws", custom), gameSocketHandler).Methods("GET")
+	r.HandleFunc(fmt.Sprintf("/%s/{gameId}/events", custom), gameEventsHandler).Methods("GET")
+
+	// Moves taken back.
+	r.HandleFunc(fmt.Sprintf("/%s/{gameId}/takebacks", custom), takebackListHandler).Methods("GET")
+
 	// Query a move number
 	r.HandleFunc(
 		fmt.Sprintf("/%s/{gameId}/moves/{move_number}", custom), moveHandler).Methods("GET")
@@ -29,5 +71,11 @@ func configureRouter(custom string) *mux.Router {
 	r.HandleFunc(
 		fmt.Sprintf("/%s/{gameId}/{playerId}", custom), playHandler).Methods("POST", "DELETE")
 
+	// POST ask to take back the last move
+	// PUT accept the pending takeback
+	// DELETE decline the pending takeback
+	r.HandleFunc(
+		fmt.Sprintf("/%s/{gameId}/{playerId}/takeback", custom), takebackHandler).Methods("POST", "PUT", "DELETE")
+
 	return r
 }
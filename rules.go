@@ -0,0 +1,137 @@
+package main
+
+// Rules are the per-game settings chosen when a game is created.
+type Rules struct {
+	Rows      int `json:"rows"`
+	Columns   int `json:"columns"`
+	WinLength int `json:"winLength"`
+	Players   int `json:"players"`
+
+	// Time controls in seconds, zero when untimed. A game either limits
+	// each move or gives each player a clock, which gains the increment
+	// after every move they make.
+	MoveSeconds      int `json:"moveSeconds,omitempty"`
+	ClockSeconds     int `json:"clockSeconds,omitempty"`
+	IncrementSeconds int `json:"incrementSeconds,omitempty"`
+
+	// Pop Out: on their turn a player may remove one of their own coins
+	// from the bottom row instead of dropping one.
+	PopOut bool `json:"popOut,omitempty"`
+
+	// Coins are placed on any empty cell instead of dropping down a column,
+	// for tic-tac-toe, gomoku and the like.
+	FreePlacement bool `json:"freePlacement,omitempty"`
+
+	// How the edges of the board join, omitted for a flat board.
+	Topology Topology `json:"topology,omitempty"`
+
+	// Players are split into this many teams of the same size whose coins
+	// count together. Seat i plays for team i mod Teams, so teams take turns
+	// in seat order. Zero when everyone plays for themselves.
+	Teams int `json:"teams,omitempty"`
+
+	// How the game is won, omitted when the first to complete a line wins.
+	WinCondition WinCondition `json:"winCondition,omitempty"`
+}
+
+// edges returns the Edges of the board laid out by these rules.
+func (rules *Rules) edges() Edges {
+	return Edges{Rows: rules.Rows, Cols: rules.Columns, Topology: rules.Topology}
+}
+
+// maxTimeControl is the longest time control in seconds, a day.
+const maxTimeControl = 24 * 60 * 60
+
+func (rules *Rules) timed() bool {
+	return rules.MoveSeconds > 0 || rules.ClockSeconds > 0
+}
+
+// RuleBounds are the server configured limits a game's Rules must fall within.
+type RuleBounds struct {
+	MinRows, MaxRows           int
+	MinColumns, MaxColumns     int
+	MinWinLength, MaxWinLength int
+	MinPlayers, MaxPlayers     int
+}
+
+func checkBound(name string, val, min, max int) error {
+	if val < min || val > max {
+		return fieldError(name, "%s must be between %d and %d, got %d", name, min, max, val)
+	}
+	return nil
+}
+
+// Validate returns an error describing the first rule outside of the bounds.
+func (rb *RuleBounds) Validate(rules *Rules) error {
+	if err := checkBound("rows", rules.Rows, rb.MinRows, rb.MaxRows); err != nil {
+		return err
+	}
+	if err := checkBound("columns", rules.Columns, rb.MinColumns, rb.MaxColumns); err != nil {
+		return err
+	}
+	if err := checkBound("players", rules.Players, rb.MinPlayers, rb.MaxPlayers); err != nil {
+		return err
+	}
+	if err := checkBound("winLength", rules.WinLength, rb.MinWinLength, rb.MaxWinLength); err != nil {
+		return err
+	}
+	if err := checkBound("moveSeconds", rules.MoveSeconds, 0, maxTimeControl); err != nil {
+		return err
+	}
+	if err := checkBound("clockSeconds", rules.ClockSeconds, 0, maxTimeControl); err != nil {
+		return err
+	}
+	if err := checkBound("incrementSeconds", rules.IncrementSeconds, 0, maxTimeControl); err != nil {
+		return err
+	}
+	if rules.MoveSeconds > 0 && rules.ClockSeconds > 0 {
+		return fieldError("moveSeconds", "moveSeconds and clockSeconds cannot both be set")
+	}
+	if rules.IncrementSeconds > 0 && rules.ClockSeconds == 0 {
+		return fieldError("incrementSeconds", "incrementSeconds requires clockSeconds")
+	}
+	switch rules.Topology {
+	case "", TopologyCylinder, TopologyTorus:
+	default:
+		return fieldError("topology", "topology must be %s or %s, got %s",
+			TopologyCylinder, TopologyTorus, rules.Topology)
+	}
+	if rules.Teams != 0 && (rules.Teams < 2 || rules.Players%rules.Teams != 0 || rules.Players/rules.Teams < 2) {
+		return fieldError("teams", "teams must split %d players evenly into teams of at least 2, got %d",
+			rules.Players, rules.Teams)
+	}
+	switch rules.WinCondition {
+	case "", WinMisere, WinScoring:
+	default:
+		return fieldError("winCondition", "winCondition must be %s or %s, got %s",
+			WinMisere, WinScoring, rules.WinCondition)
+	}
+	// Popping out can complete several lines at once and keep a full board
+	// in play forever.
+	if rules.PopOut && rules.WinCondition != "" {
+		return fieldError("popOut", "popOut cannot be used with winCondition %s", rules.WinCondition)
+	}
+	if rules.PopOut && rules.FreePlacement {
+		return fieldError("popOut", "popOut cannot be used with freePlacement")
+	}
+	// A line longer than both sides of the board can never be made.
+	if rules.WinLength > rules.Rows && rules.WinLength > rules.Columns {
+		return fieldError("winLength", "winLength %d does not fit on a %dx%d board",
+			rules.WinLength, rules.Rows, rules.Columns)
+	}
+	return nil
+}
+
+// serverBounds returns the RuleBounds configured by flags.
+func serverBounds() *RuleBounds {
+	return &RuleBounds{
+		MinRows:      *MIN_ROWS,
+		MaxRows:      *MAX_ROWS,
+		MinColumns:   *MIN_COLUMNS,
+		MaxColumns:   *MAX_COLUMNS,
+		MinWinLength: *MIN_CONSECUTIVE_LENGTH,
+		MaxWinLength: *MAX_CONSECUTIVE_LENGTH,
+		MinPlayers:   *MIN_PLAYERS,
+		MaxPlayers:   *MAX_PLAYERS,
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// WinCondition is how a game is won.
+type WinCondition string
+
+// WinLine is the first to complete a line winning, the same as no win
+// condition.
+var WinLine = WinCondition("LINE")
+
+// WinMisere knocks out whoever completes a line, along with their team. The
+// last side left wins.
+var WinMisere = WinCondition("MISERE")
+
+// WinScoring plays on until the board is full, when the side owning the most
+// lines wins.
+var WinScoring = WinCondition("SCORING")
+
+// Standing is where a player finished a game, from rank 1. Players sharing a
+// rank tied.
+type Standing struct {
+	Player string `json:"player"`
+	Rank   int    `json:"rank"`
+	Score  *int   `json:"score,omitempty"`
+}
+
+// scores returns the number of distinct lines of WinLength coins each player's
+// side owns, which teammates share. A longer run of coins holds a line for
+// every WinLength coins along it.
+func (g *game) scores() map[string]int {
+	edges := g.rules.edges()
+	sides := map[string]int{}
+	found := map[string]bool{}
+	for row := range g.board {
+		for col, owner := range g.board[row] {
+			if owner == "" {
+				continue
+			}
+			side := g.side(owner)
+			for _, direction := range []Direction{Down, Right, DownRight, DownLeft} {
+				cells := []Cell{{row, col}}
+				key := DirectionKey{row, col, direction}
+				for len(cells) < g.sequentialWin {
+					key = mkNextDirectionKey(key.Row, key.Col, direction, edges)
+					if key.Row < 0 || key.Row >= len(g.board) || key.Col < 0 || key.Col >= len(g.board[0]) ||
+						g.board[key.Row][key.Col] == "" || g.side(g.board[key.Row][key.Col]) != side {
+						break
+					}
+					cells = append(cells, Cell{key.Row, key.Col})
+				}
+				if len(cells) < g.sequentialWin {
+					continue
+				}
+				// On joined edges a line may come back around onto itself,
+				// or be found again from each of its coins.
+				sort.Slice(cells, func(i, j int) bool {
+					return cells[i].Row < cells[j].Row || (cells[i].Row == cells[j].Row && cells[i].Col < cells[j].Col)
+				})
+				repeats := false
+				for i := 1; i < len(cells); i++ {
+					repeats = repeats || cells[i] == cells[i-1]
+				}
+				id := fmt.Sprint(LINE_FOR_DIRECTION[direction], cells)
+				if repeats || found[id] {
+					continue
+				}
+				found[id] = true
+				sides[side]++
+			}
+		}
+	}
+	scores := map[string]int{}
+	for _, player := range g.playerList {
+		scores[player] = sides[g.side(player)]
+	}
+	return scores
+}
+
+// topScorer returns the first player still playing on the side with the
+// highest score, or nobody if sides tie for it.
+func (g *game) topScorer() string {
+	scores := g.scores()
+	top := ""
+	tied := false
+	for _, player := range g.currentlyPlaying() {
+		switch {
+		case top == "" || scores[player] > scores[top]:
+			top = player
+			tied = false
+		case scores[player] == scores[top] && g.side(player) != g.side(top):
+			tied = true
+		}
+	}
+	if tied {
+		return ""
+	}
+	return top
+}
+
+// knockOut takes the player's side out of a misère game.
+func (g *game) knockOut(playerId string) {
+	side := g.side(playerId)
+	out := []string{}
+	for _, player := range g.playerList {
+		if g.players[player] && g.side(player) == side {
+			g.players[player] = false
+			out = append(out, player)
+		}
+	}
+	g.knockedOut = append(g.knockedOut, out)
+	g.lastSideWins()
+}
+
+// lastSideWins ends the game once everyone still playing is on the same
+// side, the first of them winning. Engine players left without a person are
+// not made to play the game out, it ends drawn or on points.
+func (g *game) lastSideWins() {
+	playersLeft := g.currentlyPlaying()
+	sides := map[string]bool{}
+	people := 0
+	for _, player := range playersLeft {
+		sides[g.side(player)] = true
+		if _, ok := g.bots[player]; !ok {
+			people++
+		}
+	}
+	switch {
+	case len(sides) == 1:
+		g.over = true
+		g.winner = playersLeft[0]
+	case people == 0:
+		g.over = true
+		if g.rules.WinCondition == WinScoring {
+			g.winner = g.topScorer()
+		}
+	}
+}
+
+// standings ranks the players of a finished game, best first. Players still
+// playing rank above those knocked out, who rank above those who quit. Of
+// the players still playing the winner's side ranks first, or in scoring
+// games the sides with the most lines. Those knocked out later rank above
+// those knocked out earlier.
+func (g *game) standings() []*Standing {
+	var scores map[string]int
+	if g.rules.WinCondition == WinScoring {
+		scores = g.scores()
+	}
+	knockedOut := map[string]int{}
+	for i, out := range g.knockedOut {
+		for _, player := range out {
+			knockedOut[player] = i + 1
+		}
+	}
+	// Places compare by group, then by the value within it.
+	place := func(player string) [2]int {
+		switch {
+		case g.players[player] && scores != nil:
+			return [2]int{2, scores[player]}
+		case g.players[player] && g.winner != "" && g.side(player) == g.side(g.winner):
+			return [2]int{2, 1}
+		case g.players[player]:
+			return [2]int{2, 0}
+		case knockedOut[player] > 0:
+			return [2]int{1, knockedOut[player]}
+		}
+		return [2]int{0, 0}
+	}
+	better := func(a, b [2]int) bool {
+		return a[0] > b[0] || (a[0] == b[0] && a[1] > b[1])
+	}
+
+	standings := []*Standing{}
+	for _, player := range g.playerList {
+		s := &Standing{Player: player, Rank: 1}
+		for _, other := range g.playerList {
+			if better(place(other), place(player)) {
+				s.Rank++
+			}
+		}
+		if scores != nil {
+			score := scores[player]
+			s.Score = &score
+		}
+		standings = append(standings, s)
+	}
+	sort.SliceStable(standings, func(i, j int) bool {
+		return standings[i].Rank < standings[j].Rank
+	})
+	return standings
+}
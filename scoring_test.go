@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func standingsString(standings []*Standing) string {
+	s := ""
+	for _, st := range standings {
+		s += fmt.Sprintf("%s:%d ", st.Player, st.Rank)
+		if st.Score != nil {
+			s += fmt.Sprintf("(%d) ", *st.Score)
+		}
+	}
+	return s
+}
+
+func Test_Misere(t *testing.T) {
+	rules := &Rules{Rows: 6, Columns: 7, WinLength: 4, Players: 2, WinCondition: WinMisere}
+	g := CreateGameWithRules(rules, "a", "b")
+	for _, col := range []int{0, 1, 0, 1, 0, 1, 0} {
+		g.Move(g.nextMove(), col)
+	}
+	if !g.isDone() || g.Winner() != "b" {
+		t.Fatal("expected completing a line to lose got ", g.Winner())
+	}
+	if len(g.moves[6].lines) != 1 || len(g.winningLines) != 0 {
+		t.Error("expected the line on the move only")
+	}
+	if got := standingsString(g.GameStatus().Ranking); got != "b:1 a:2 " {
+		t.Error("unexpected ranking ", got)
+	}
+
+	// With more players those completing a line are knocked out in turn.
+	rules = &Rules{Rows: 6, Columns: 7, WinLength: 4, Players: 3, WinCondition: WinMisere}
+	g = CreateGameWithRules(rules, "a", "b", "c")
+	for _, col := range []int{0, 1, 2, 0, 1, 2, 0, 1, 2, 0} {
+		g.Move(g.nextMove(), col)
+	}
+	if g.isDone() || g.isPlaying("a") || g.nextMove() != "b" {
+		t.Fatal("expected a to be out and b to move got ", g.nextMove())
+	}
+	if _, status := g.Move("a", 3); status != MoveWrongGame {
+		t.Error("expected a knocked out player not to move got ", status)
+	}
+	g.Move("b", 1)
+	if !g.isDone() || g.Winner() != "c" {
+		t.Fatal("expected c to be left got ", g.Winner())
+	}
+	if got := standingsString(g.GameStatus().Ranking); got != "c:1 b:2 a:3 " {
+		t.Error("unexpected ranking ", got)
+	}
+	for _, r := range g.results() {
+		if r.Player != "c" && r.Outcome != OutcomeLoss {
+			t.Error("expected ", r.Player, " to lose got ", r.Outcome)
+		}
+	}
+
+	// A team is knocked out together, its players sharing a place.
+	rules = &Rules{Rows: 6, Columns: 7, WinLength: 4, Players: 6, Teams: 3, WinCondition: WinMisere}
+	g = CreateGameWithRules(rules, "a", "b", "c", "d", "e", "f")
+	for _, col := range []int{0, 1, 2, 0, 1, 2, 0, 1, 2, 0} {
+		g.Move(g.nextMove(), col)
+	}
+	if g.isDone() || g.isPlaying("a") || g.isPlaying("d") || g.nextMove() != "e" {
+		t.Fatal("expected a and d to be out and e to move got ", g.nextMove())
+	}
+	g.Move("e", 1)
+	if !g.isDone() || g.Winner() != "c" {
+		t.Fatal("expected c and f to be left got ", g.Winner())
+	}
+	if got := standingsString(g.GameStatus().Ranking); got != "c:1 f:1 b:3 e:3 a:5 d:5 " {
+		t.Error("unexpected ranking ", got)
+	}
+}
+
+func Test_Scoring(t *testing.T) {
+	/*
+	   x x x
+	   o o x
+	   x o o
+	*/
+	rules := &Rules{Rows: 3, Columns: 3, WinLength: 3, Players: 2, FreePlacement: true, WinCondition: WinScoring}
+	g := CreateGameWithRules(rules, "x", "o")
+	for _, cell := range []Cell{{0, 0}, {1, 0}, {0, 1}, {1, 1}, {0, 2}} {
+		g.Place(g.nextMove(), cell.Row, cell.Col)
+	}
+	if g.isDone() || g.GameStatus().Scores["x"] != 1 {
+		t.Fatal("expected play to go on with x scoring a line")
+	}
+	for _, cell := range []Cell{{2, 1}, {1, 2}, {2, 2}, {2, 0}} {
+		g.Place(g.nextMove(), cell.Row, cell.Col)
+	}
+	if !g.isDone() || g.Winner() != "x" {
+		t.Fatal("expected x to win on points got ", g.Winner())
+	}
+	if got := standingsString(g.GameStatus().Ranking); got != "x:1 (1) o:2 (0) " {
+		t.Error("unexpected ranking ", got)
+	}
+
+	// Sides level on points draw.
+	g = CreateGameWithRules(rules, "x", "o")
+	for _, cell := range []Cell{{0, 0}, {1, 0}, {0, 1}, {1, 1}, {2, 2}, {1, 2}, {0, 2}, {2, 0}, {2, 1}} {
+		g.Place(g.nextMove(), cell.Row, cell.Col)
+	}
+	if !g.isDone() || g.Winner() != "" {
+		t.Fatal("expected a draw got ", g.Winner())
+	}
+	if got := standingsString(g.GameStatus().Ranking); got != "x:1 (1) o:1 (1) " {
+		t.Error("unexpected ranking ", got)
+	}
+}
+
+func Test_scores(t *testing.T) {
+	for _, test := range []struct {
+		topology Topology
+		cols     int
+		expected int
+	}{
+		// Five in a row hold two lines of four.
+		{"", 5, 2},
+		// A row all the way around holds as many lines as coins.
+		{TopologyCylinder, 5, 5},
+		// Unless it is no longer than a line.
+		{TopologyCylinder, 4, 1},
+	} {
+		rules := &Rules{Rows: 4, Columns: test.cols, WinLength: 4, Players: 2, Topology: test.topology}
+		g := CreateGameWithRules(rules, "a", "b")
+		for col := 0; col < test.cols; col++ {
+			g.board[3][col] = "a"
+		}
+		if scores := g.scores(); scores["a"] != test.expected || scores["b"] != 0 {
+			t.Error(test.topology, test.cols, " expected ", test.expected, " got ", scores)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const ssePingPeriod = 30 * time.Second
+
+func writeSSE(w http.ResponseWriter, e *GameEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Id, e.Type, b)
+	return err
+}
+
+// streamSSE writes events as Server-Sent Events until the client goes away,
+// the subscription is dropped or, if untilOver is set, the game is over.
+func streamSSE(w http.ResponseWriter, r *http.Request, backlog []*GameEvent,
+	events chan *GameEvent, untilOver bool) {
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, mkAPIError(http.StatusInternalServerError, ErrServer, "streaming unsupported"))
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, e := range backlog {
+		if err := writeSSE(w, e); err != nil {
+			return
+		}
+		if untilOver && e.Type == EventGameOver {
+			flusher.Flush()
+			return
+		}
+	}
+	flusher.Flush()
+
+	ping := time.NewTicker(ssePingPeriod)
+	defer ping.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				// Fell too far behind, the client reconnects with Last-Event-ID.
+				return
+			}
+			if err := writeSSE(w, e); err != nil {
+				return
+			}
+			flusher.Flush()
+			if untilOver && e.Type == EventGameOver {
+				return
+			}
+		case <-ping.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// gameEventsHandler streams a game's events as Server-Sent Events, replaying
+// those after the Last-Event-ID header. Without one, or with one from before
+// the server restarted, ?from= is the first move number to replay events of.
+func gameEventsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	g, ok := GAMES.Get(vars["gameId"])
+	if !ok {
+		writeError(w, mkAPIError(http.StatusNotFound, ErrUnknownGame, "unknown game").forGame(vars["gameId"]))
+		return
+	}
+	lastId, err := validateLastEventId(r)
+	if err != nil {
+		writeError(w, invalidRequest(ErrInvalidParameter, err))
+		return
+	}
+	from, err := validateFrom(r)
+	if err != nil {
+		writeError(w, invalidRequest(ErrInvalidParameter, err))
+		return
+	}
+	var backlog []*GameEvent
+	var events chan *GameEvent
+	var unsubscribe func()
+	if lastId < 0 {
+		backlog, events, unsubscribe = g.Subscribe(from)
+	} else {
+		backlog, events, unsubscribe = g.SubscribeAfter(lastId, from)
+	}
+	defer unsubscribe()
+	streamSSE(w, r, backlog, events, true)
+}
+
+// lobbyEventsHandler streams game creation and the events of every game as
+// Server-Sent Events, replaying those after the Last-Event-ID header that are
+// still held.
+func lobbyEventsHandler(w http.ResponseWriter, r *http.Request) {
+	lastId, err := validateLastEventId(r)
+	if err != nil {
+		writeError(w, invalidRequest(ErrInvalidParameter, err))
+		return
+	}
+	backlog, events, unsubscribe := GAMES.SubscribeLobby(lastId)
+	defer unsubscribe()
+	streamSSE(w, r, backlog, events, false)
+}
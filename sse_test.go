@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type sseEvent struct {
+	id    string
+	event string
+	data  *GameEvent
+}
+
+// readSSE returns the next event on the stream, skipping comments.
+func readSSE(t *testing.T, rd *bufio.Reader) *sseEvent {
+	e := &sseEvent{}
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			t.Fatal("expected an event got ", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "" && e.data != nil:
+			return e
+		case strings.HasPrefix(line, "id: "):
+			e.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			e.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			e.data = &GameEvent{}
+			err = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), e.data)
+			if err != nil {
+				t.Fatal("invalid event data ", line)
+			}
+		}
+	}
+}
+
+func openSSE(t *testing.T, ctx context.Context, url, lastId string) (*http.Response, *bufio.Reader) {
+	req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if lastId != "" {
+		req.Header.Set("Last-Event-ID", lastId)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("unable to open ", url, " ", err)
+	}
+	return resp, bufio.NewReader(resp.Body)
+}
+
+func expectSSE(t *testing.T, rd *bufio.Reader, id string, typ EventType, gameId string) *GameEvent {
+	e := readSSE(t, rd)
+	if e.id != id || e.event != string(typ) || e.data.GameId != gameId {
+		t.Error("expected event ", id, " ", typ, " for ", gameId, " got ",
+			e.id, " ", e.event, " for ", e.data.GameId)
+	}
+	return e.data
+}
+
+func Test_gameEventsHandler(t *testing.T) {
+	server := httptest.NewServer(configureRouter(*API_PREFIX))
+	defer server.Close()
+	url := server.URL + "/" + *API_PREFIX + "/sse/events"
+
+	g := CreateGame(4, 4, 4, "a", "b")
+	g.id = "sse"
+	GAMES.Add(g)
+	g.Move("a", 0)
+	g.Move("b", 1)
+
+	resp, rd := openSSE(t, context.Background(), url, "0")
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Error("expected text/event-stream got", resp.Header.Get("Content-Type"))
+	}
+	e := expectSSE(t, rd, "1", EventMove, "sse")
+	if e.Player != "b" {
+		t.Error("expected move by b got", e.Player)
+	}
+
+	g.Quit("a")
+	expectSSE(t, rd, "2", EventQuit, "sse")
+	e = expectSSE(t, rd, "3", EventGameOver, "sse")
+	if e.Winner != "b" {
+		t.Error("expected b to win got", e.Winner)
+	}
+	// The stream ends with the game.
+	_, err := rd.ReadString('\n')
+	if err == nil {
+		t.Error("expected the stream to end")
+	}
+
+	// Clients without an event id from this server ask for move numbers.
+	for _, lastId := range []string{"", "99"} {
+		resp, rd = openSSE(t, context.Background(), url+"?from=1", lastId)
+		expectSSE(t, rd, "1", EventMove, "sse")
+		expectSSE(t, rd, "2", EventQuit, "sse")
+		resp.Body.Close()
+	}
+
+	resp, _ = openSSE(t, context.Background(), url, "nope")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Error("expected 400 got", resp.StatusCode)
+	}
+	resp, _ = openSSE(t, context.Background(), server.URL+"/"+*API_PREFIX+"/dogs/events", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Error("expected 404 got", resp.StatusCode)
+	}
+}
+
+func Test_lobbyEventsHandler(t *testing.T) {
+	old := GAMES
+	GAMES, _ = NewGamesContainer(&memoryStore{})
+	defer func() {
+		GAMES = old
+	}()
+
+	server := httptest.NewServer(configureRouter(*API_PREFIX))
+	defer server.Close()
+	url := server.URL + "/" + *API_PREFIX + "/events"
+
+	g := CreateGame(4, 4, 4, "a", "b")
+	g.id = "lobby-one"
+	GAMES.Add(g)
+	g.Move("a", 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	resp, rd := openSSE(t, ctx, url, "")
+	defer resp.Body.Close()
+
+	e := expectSSE(t, rd, "0", EventGameCreated, "lobby-one")
+	if len(e.Players) != 2 {
+		t.Error("expected 2 players got", e.Players)
+	}
+	expectSSE(t, rd, "1", EventMove, "lobby-one")
+
+	other := CreateGame(4, 4, 4, "c", "d")
+	other.id = "lobby-two"
+	GAMES.Add(other)
+	expectSSE(t, rd, "2", EventGameCreated, "lobby-two")
+	other.Move("c", 2)
+	expectSSE(t, rd, "3", EventMove, "lobby-two")
+	g.Quit("b")
+	expectSSE(t, rd, "4", EventQuit, "lobby-one")
+	expectSSE(t, rd, "5", EventGameOver, "lobby-one")
+	cancel()
+
+	// Reconnecting replays the events after Last-Event-ID.
+	resp, rd = openSSE(t, context.Background(), url, "3")
+	expectSSE(t, rd, "4", EventQuit, "lobby-one")
+	expectSSE(t, rd, "5", EventGameOver, "lobby-one")
+	resp.Body.Close()
+}
@@ -0,0 +1,594 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Store persists games so they survive a restart of the server.
+type Store interface {
+	// Create records a newly created game.
+	Create(g *game) error
+
+	// Append records the move at index num of a game's move list.
+	Append(gameId string, num int, move *Move) error
+
+	// Join records a player claiming an open seat at a time, with the invite
+	// used if any.
+	Join(gameId, playerId, token, invite string, at time.Time) error
+
+	// Undo records the last move of a game being taken back, the index'th
+	// takeback of the game.
+	Undo(gameId string, index int, um *UndoneMove) error
+
+	// Archive keeps a finished game aside and drops it from the games Load
+	// rebuilds.
+	Archive(g *game) error
+
+	// Load rebuilds every stored game.
+	Load() ([]*game, error)
+
+	// Snapshot compacts the store so it only needs the games currently
+	// returned by games to recover.
+	Snapshot(games func() []*game) error
+
+	// SaveRatings replaces the stored player ratings.
+	SaveRatings(players []*PlayerRating) error
+
+	// LoadRatings returns the stored player ratings.
+	LoadRatings() ([]*PlayerRating, error)
+
+	Close() error
+}
+
+// memoryStore keeps nothing; games live only as long as the process.
+type memoryStore struct{}
+
+func (ms *memoryStore) Create(g *game) error                                            { return nil }
+func (ms *memoryStore) Append(gameId string, num int, m *Move) error                    { return nil }
+func (ms *memoryStore) Join(gameId, playerId, token, invite string, at time.Time) error { return nil }
+func (ms *memoryStore) Undo(gameId string, index int, um *UndoneMove) error             { return nil }
+func (ms *memoryStore) Archive(g *game) error                                           { return nil }
+func (ms *memoryStore) Load() ([]*game, error)                                          { return []*game{}, nil }
+func (ms *memoryStore) Snapshot(games func() []*game) error                             { return nil }
+func (ms *memoryStore) SaveRatings(players []*PlayerRating) error                       { return nil }
+func (ms *memoryStore) LoadRatings() ([]*PlayerRating, error)                           { return []*PlayerRating{}, nil }
+func (ms *memoryStore) Close() error                                                    { return nil }
+
+type moveRecord struct {
+	Number int      `json:"number"`
+	Type   MoveType `json:"type"`
+	Player string   `json:"player"`
+	Column int      `json:"column"`
+
+	// Only needed to replay games with free placement.
+	Row int `json:"row,omitempty"`
+
+	// Zero in logs written before moves were timed.
+	At time.Time `json:"at"`
+
+	// Takebacks made before the move, nil in logs written before it was
+	// recorded.
+	Undone *int `json:"undone,omitempty"`
+}
+
+type joinRecord struct {
+	Player string `json:"player"`
+	Token  string `json:"token"`
+	Invite string `json:"invite,omitempty"`
+
+	At time.Time `json:"at"`
+}
+
+type undoRecord struct {
+	Index int         `json:"index"`
+	Move  *UndoneMove `json:"move"`
+}
+
+// gameRecord is everything needed to rebuild a game by replaying its moves.
+type gameRecord struct {
+	Id      string            `json:"id"`
+	Created time.Time         `json:"created"`
+	Rules   *Rules            `json:"rules"`
+	Players []string          `json:"players"`
+	Tokens  map[string]string `json:"tokens"`
+	Invites []string          `json:"invites,omitempty"`
+	Public  bool              `json:"public,omitempty"`
+	Rated   []string          `json:"rated,omitempty"`
+	Moves   []*moveRecord     `json:"moves"`
+	Undone  []*UndoneMove     `json:"undone,omitempty"`
+}
+
+// logEntry is a single line of the append-only log. Exactly one of Game,
+// Move, Join, Undo or Archived is set.
+type logEntry struct {
+	GameId   string      `json:"gameId"`
+	Game     *gameRecord `json:"game,omitempty"`
+	Move     *moveRecord `json:"move,omitempty"`
+	Join     *joinRecord `json:"join,omitempty"`
+	Undo     *undoRecord `json:"undo,omitempty"`
+	Archived bool        `json:"archived,omitempty"`
+}
+
+func mkMoveRecord(num int, move *Move) *moveRecord {
+	undone := move.undone
+	return &moveRecord{
+		Number: num,
+		Type:   move.Type,
+		Player: move.player,
+		Column: move.col,
+		Row:    move.row,
+		At:     move.at,
+		Undone: &undone,
+	}
+}
+
+// record returns the gameRecord for this game.
+func (g *game) record() *gameRecord {
+	g.RLock()
+	defer g.RUnlock()
+	rules := g.rules
+	gr := &gameRecord{
+		Id:      g.id,
+		Created: g.created,
+		Rules:   &rules,
+		Players: append([]string{}, g.playerList...),
+		Tokens:  map[string]string{},
+		Public:  g.public,
+		Moves:   []*moveRecord{},
+	}
+	for player, token := range g.tokens {
+		gr.Tokens[player] = token
+	}
+	for invite := range g.invites {
+		gr.Invites = append(gr.Invites, invite)
+	}
+	sort.Strings(gr.Invites)
+	for player := range g.rated {
+		gr.Rated = append(gr.Rated, player)
+	}
+	sort.Strings(gr.Rated)
+	for i, m := range g.moves {
+		gr.Moves = append(gr.Moves, mkMoveRecord(i, m))
+	}
+	gr.Undone = append(gr.Undone, g.undone...)
+	return gr
+}
+
+// replay applies a stored move. Moves already applied are skipped so log
+// entries that are also part of a snapshot are harmless. A move made before
+// a takeback the game already has was applied, or taken back, even if its
+// number is free again. Engine players do not move by themselves, their
+// moves are in the log too.
+func (g *game) replay(mr *moveRecord) error {
+	g.Lock()
+	defer g.Unlock()
+	if mr.Undone != nil && *mr.Undone < len(g.undone) {
+		return nil
+	}
+	if mr.Undone != nil && *mr.Undone > len(g.undone) {
+		return fmt.Errorf("game %s missing takeback %d", g.id, len(g.undone))
+	}
+	if mr.Number < len(g.moves) {
+		return nil
+	}
+	return g.applyRecord(mr)
+}
+
+// applyRecord makes a stored move, which must be the next move of the game.
+func (g *game) applyRecord(mr *moveRecord) error {
+	if mr.Number != len(g.moves) {
+		return fmt.Errorf("game %s missing move %d", g.id, len(g.moves))
+	}
+	defer g.replayingAt(mr.At)()
+	switch mr.Type {
+	case MoveMove, MovePop:
+		_, status := g.move(mr.Player, mr.Type, mr.Row, mr.Column)
+		if status != MoveOK {
+			return fmt.Errorf("game %s move %d: %s", g.id, mr.Number, status)
+		}
+	case MoveQuit:
+		status := g.quit(mr.Player)
+		if status != STATUS_LEFT_GAME {
+			return fmt.Errorf("game %s move %d: %s", g.id, mr.Number, status)
+		}
+	default:
+		return fmt.Errorf("game %s move %d: unknown move type %s", g.id, mr.Number, mr.Type)
+	}
+	if mr.Undone != nil {
+		g.moves[mr.Number].undone = *mr.Undone
+	}
+	return nil
+}
+
+// replayUndo takes back a stored move, publishing the takeback as it was.
+// Takebacks already in the game's audit trail are skipped as for moves.
+func (g *game) replayUndo(ur *undoRecord) error {
+	g.Lock()
+	defer g.Unlock()
+	if ur.Index < len(g.undone) {
+		return nil
+	}
+	num := len(g.moves) - 1
+	if ur.Index != len(g.undone) || num != ur.Move.Number || g.moves[num].player != ur.Move.Player {
+		return fmt.Errorf("game %s cannot take back move %d", g.id, ur.Move.Number)
+	}
+	g.popMove()
+	g.undone = append(g.undone, ur.Move)
+	g.turnStarted = ur.Move.Undone
+	g.lastActivity = ur.Move.Undone
+	g.publish(&GameEvent{
+		Type:   EventTakeback,
+		GameId: g.id,
+		Move:   num,
+		Player: ur.Move.Player,
+	})
+	return nil
+}
+
+// replayingAt sets the game's clock to when a stored move was made, so time
+// controls are charged as they were, returning the func that restores it.
+func (g *game) replayingAt(at time.Time) func() {
+	if at.IsZero() {
+		return func() {}
+	}
+	now := g.now
+	g.now = func() time.Time { return at }
+	return func() { g.now = now }
+}
+
+// replayJoin seats a stored player. Players already seated are skipped as
+// for moves.
+func (g *game) replayJoin(jr *joinRecord) error {
+	g.Lock()
+	defer g.Unlock()
+	if _, ok := g.players[jr.Player]; ok {
+		return nil
+	}
+	if g.openSeats() == 0 {
+		return fmt.Errorf("game %s has no open seat for %s", g.id, jr.Player)
+	}
+	defer g.replayingAt(jr.At)()
+	g.join(jr.Player, jr.Token, jr.Invite)
+	return nil
+}
+
+// rebuild creates the game described by a record and replays its moves.
+func (gr *gameRecord) rebuild() (*game, error) {
+	g := CreateGameWithRules(gr.Rules, gr.Players...)
+	g.id = gr.Id
+	if !gr.Created.IsZero() {
+		g.created = gr.Created
+		g.lastActivity = gr.Created
+		g.turnStarted = gr.Created
+	}
+	for player, token := range gr.Tokens {
+		g.tokens[player] = token
+	}
+	g.public = gr.Public
+	g.invites = map[string]bool{}
+	for _, invite := range gr.Invites {
+		g.invites[invite] = true
+	}
+	for _, player := range gr.Rated {
+		g.rated[player] = true
+	}
+	// The moves of a record are all still in the game, whatever takebacks
+	// came before them.
+	for _, mr := range gr.Moves {
+		if err := g.applyRecord(mr); err != nil {
+			return nil, err
+		}
+	}
+	g.undone = gr.Undone
+	return g, nil
+}
+
+const (
+	snapshotFile = "snapshot.json"
+	logFile      = "moves.log"
+	oldLogFile   = "moves.log.old"
+	archiveFile  = "archive.log"
+	ratingsFile  = "ratings.json"
+)
+
+// FileStore writes every game creation and move to an append-only log in
+// dir. Snapshot writes all games to a snapshot file and starts a fresh log
+// so recovery only replays moves made since the last snapshot. Archived games
+// are appended to an archive file, one game per line, and are not recovered.
+// Player ratings are rewritten to their own file whenever they change.
+// The files hold seat tokens so are only readable by their owner.
+type FileStore struct {
+	sync.Mutex
+
+	dir string
+	log *os.File
+}
+
+func OpenFileStore(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	fs := &FileStore{dir: dir}
+	fs.log, err = fs.openLog()
+	if err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+func (fs *FileStore) path(name string) string {
+	return filepath.Join(fs.dir, name)
+}
+
+func (fs *FileStore) openLog() (*os.File, error) {
+	return os.OpenFile(fs.path(logFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+}
+
+func (fs *FileStore) write(entry *logEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	fs.Lock()
+	defer fs.Unlock()
+	_, err = fs.log.Write(append(b, '\n'))
+	if err != nil {
+		return err
+	}
+	return fs.log.Sync()
+}
+
+func (fs *FileStore) Create(g *game) error {
+	gr := g.record()
+	return fs.write(&logEntry{GameId: gr.Id, Game: gr})
+}
+
+func (fs *FileStore) Append(gameId string, num int, move *Move) error {
+	return fs.write(&logEntry{GameId: gameId, Move: mkMoveRecord(num, move)})
+}
+
+func (fs *FileStore) Join(gameId, playerId, token, invite string, at time.Time) error {
+	return fs.write(&logEntry{GameId: gameId, Join: &joinRecord{playerId, token, invite, at}})
+}
+
+func (fs *FileStore) Undo(gameId string, index int, um *UndoneMove) error {
+	return fs.write(&logEntry{GameId: gameId, Undo: &undoRecord{index, um}})
+}
+
+func (fs *FileStore) Archive(g *game) error {
+	b, err := json.Marshal(g.record())
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(fs.path(archiveFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(b, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return fs.write(&logEntry{GameId: g.id, Archived: true})
+}
+
+// Load reads the last snapshot and replays the logs written after it.
+func (fs *FileStore) Load() ([]*game, error) {
+	games := []*game{}
+	byId := map[string]*game{}
+
+	records := []*gameRecord{}
+	f, err := os.Open(fs.path(snapshotFile))
+	if err == nil {
+		err = json.NewDecoder(f).Decode(&records)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading snapshot: %s", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, gr := range records {
+		g, err := gr.rebuild()
+		if err != nil {
+			return nil, err
+		}
+		games = append(games, g)
+		byId[g.id] = g
+	}
+
+	for _, name := range []string{oldLogFile, logFile} {
+		err = fs.replayLog(name, func(entry *logEntry) error {
+			g, ok := byId[entry.GameId]
+			if entry.Archived {
+				// The game may already be missing from the snapshot.
+				delete(byId, entry.GameId)
+				return nil
+			}
+			if entry.Game != nil {
+				if ok {
+					return nil
+				}
+				g, err := entry.Game.rebuild()
+				if err != nil {
+					return err
+				}
+				games = append(games, g)
+				byId[g.id] = g
+				return nil
+			}
+			if !ok {
+				return fmt.Errorf("move for unknown game %s", entry.GameId)
+			}
+			if entry.Join != nil {
+				return g.replayJoin(entry.Join)
+			}
+			if entry.Undo != nil {
+				return g.replayUndo(entry.Undo)
+			}
+			return g.replay(entry.Move)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	kept := []*game{}
+	for _, g := range games {
+		if byId[g.id] == g {
+			kept = append(kept, g)
+		}
+	}
+	return kept, nil
+}
+
+func (fs *FileStore) replayLog(name string, apply func(*logEntry) error) error {
+	f, err := os.Open(fs.path(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rd := bufio.NewReader(f)
+	var offset int64
+	for line := 1; ; line++ {
+		b, err := rd.ReadBytes('\n')
+		if err == io.EOF {
+			if len(b) == 0 {
+				return nil
+			}
+			// A partial last line is a write cut short by a crash, drop it
+			// so new entries are not appended to it.
+			return os.Truncate(fs.path(name), offset)
+		}
+		if err != nil {
+			return err
+		}
+		offset += int64(len(b))
+		entry := &logEntry{}
+		if err = json.Unmarshal(b, entry); err != nil {
+			return fmt.Errorf("%s line %d: %s", name, line, err)
+		}
+		if entry.Game == nil && entry.Move == nil && entry.Join == nil && entry.Undo == nil && !entry.Archived {
+			return fmt.Errorf("%s line %d: empty entry", name, line)
+		}
+		if err = apply(entry); err != nil {
+			return fmt.Errorf("%s line %d: %s", name, line, err)
+		}
+	}
+}
+
+// rotate moves the current log aside so the snapshot can replace it. If a
+// previous snapshot failed its old log is still needed, so the current log
+// is left in place until the next snapshot.
+func (fs *FileStore) rotate() error {
+	fs.Lock()
+	defer fs.Unlock()
+	_, err := os.Stat(fs.path(oldLogFile))
+	if err == nil {
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	if err = fs.log.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(fs.path(logFile), fs.path(oldLogFile)); err != nil {
+		return err
+	}
+	fs.log, err = fs.openLog()
+	return err
+}
+
+func (fs *FileStore) Snapshot(games func() []*game) error {
+	err := fs.rotate()
+	if err != nil {
+		return err
+	}
+
+	// Every entry in the old log was written before the games are listed
+	// and recorded below, so the snapshot includes all of them.
+	records := []*gameRecord{}
+	for _, g := range games() {
+		records = append(records, g.record())
+	}
+
+	if err = fs.replaceFile(snapshotFile, records); err != nil {
+		return err
+	}
+	err = os.Remove(fs.path(oldLogFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// replaceFile writes v as JSON to a temporary file then renames it over name,
+// so name always holds a complete file.
+func (fs *FileStore) replaceFile(name string, v interface{}) error {
+	tmp := fs.path(name + ".tmp")
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(f).Encode(v)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, fs.path(name))
+}
+
+func (fs *FileStore) SaveRatings(players []*PlayerRating) error {
+	return fs.replaceFile(ratingsFile, players)
+}
+
+func (fs *FileStore) LoadRatings() ([]*PlayerRating, error) {
+	players := []*PlayerRating{}
+	f, err := os.Open(fs.path(ratingsFile))
+	if os.IsNotExist(err) {
+		return players, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err = json.NewDecoder(f).Decode(&players); err != nil {
+		return nil, fmt.Errorf("reading ratings: %s", err)
+	}
+	return players, nil
+}
+
+func (fs *FileStore) Close() error {
+	fs.Lock()
+	defer fs.Unlock()
+	if fs.log == nil {
+		return errors.New("store already closed")
+	}
+	err := fs.log.Close()
+	fs.log = nil
+	return err
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openTestStore(t *testing.T, dir string) *GamesContainer {
+	fs, err := OpenFileStore(dir)
+	if err != nil {
+		t.Fatal("unable to open store ", err)
+	}
+	gc, err := NewGamesContainer(fs)
+	if err != nil {
+		t.Fatal("unable to load games ", err)
+	}
+	return gc
+}
+
+func expectSameGame(t *testing.T, got, expected *game) {
+	if got == nil {
+		t.Fatal("expected game ", expected.id)
+	}
+	if len(got.moves) != len(expected.moves) {
+		t.Fatal("expected ", len(expected.moves), " moves got ", len(got.moves))
+	}
+	for i, m := range expected.moves {
+		gm := got.moves[i]
+		if gm.player != m.player || gm.row != m.row || gm.col != m.col || gm.Type != m.Type {
+			t.Error("expected move ", i, " to be ", *m, " got ", *gm)
+		}
+		if len(gm.lines) != len(m.lines) {
+			t.Error("expected move ", i, " to complete ", len(m.lines), " lines")
+		}
+	}
+	for i, row := range expected.board {
+		for j, space := range row {
+			if got.board[i][j] != space {
+				t.Error("expected ", space, " at ", i, j, " got ", got.board[i][j])
+			}
+			if space != "" && !got.playerGraphs[space].Get(i, j) {
+				t.Error("expected coin for ", space, " at ", i, j)
+			}
+		}
+	}
+	if got.over != expected.over || got.winner != expected.winner {
+		t.Error("expected over ", expected.over, " winner ", expected.winner)
+	}
+	if got.rules != expected.rules {
+		t.Error("expected rules ", expected.rules, " got ", got.rules)
+	}
+	if !got.created.Equal(expected.created) {
+		t.Error("expected created ", expected.created, " got ", got.created)
+	}
+	for player, token := range expected.tokens {
+		if !got.Authorize(player, token) {
+			t.Error("expected token of ", player, " to be kept")
+		}
+	}
+	if len(got.rated) != len(expected.rated) {
+		t.Error("expected rated players ", expected.rated, " got ", got.rated)
+	}
+}
+
+func Test_FileStoreReplay(t *testing.T) {
+	dir := t.TempDir()
+	gc := openTestStore(t, dir)
+
+	g := CreateGameWithRules(&Rules{Rows: 5, Columns: 6, WinLength: 4, Players: 3}, "a", "b", "c")
+	g.id = "replay"
+	gc.Add(g)
+	g.Move("a", 1)
+	g.Move("b", 1)
+	g.Move("c", 2)
+	g.Quit("b")
+	g.Move("a", 5)
+	gc.store.Close()
+
+	gc = openTestStore(t, dir)
+	got, _ := gc.Get("replay")
+	expectSameGame(t, got, g)
+
+	// Recovered games keep recording moves.
+	got.Move("c", 3)
+	gc.store.Close()
+
+	gc = openTestStore(t, dir)
+	again, _ := gc.Get("replay")
+	expectSameGame(t, again, got)
+	gc.store.Close()
+}
+
+func Test_FileStoreJoins(t *testing.T) {
+	dir := t.TempDir()
+	gc := openTestStore(t, dir)
+
+	g := CreateGameWithRules(&Rules{Rows: 4, Columns: 4, WinLength: 4, Players: 4}, "a")
+	g.id = "joins"
+	g.public = true
+	gc.Add(g)
+	invites := g.Invites()
+	g.Join("b", invites[0])
+	g.Join("c", "")
+	gc.store.Close()
+
+	gc = openTestStore(t, dir)
+	got, _ := gc.Get("joins")
+	expectSameGame(t, got, g)
+	if strings.Join(got.playerList, ",") != "a,b,c" || !got.public || !got.hasInvite(invites[1]) || got.hasInvite(invites[0]) {
+		t.Fatal("expected the seats claimed to be recovered got ", got.playerList)
+	}
+
+	// The last seat is claimed after a snapshot.
+	if err := gc.Snapshot(); err != nil {
+		t.Fatal("snapshot failed ", err)
+	}
+	got.Join("d", invites[2])
+	got.Move("a", 0)
+	gc.store.Close()
+
+	gc = openTestStore(t, dir)
+	again, _ := gc.Get("joins")
+	expectSameGame(t, again, got)
+	if len(again.Invites()) != 0 || again.GameStatus().Status != STATUS_IN_PROGRESS {
+		t.Error("expected every seat to be claimed")
+	}
+	gc.store.Close()
+}
+
+func Test_FileStoreClocks(t *testing.T) {
+	dir := t.TempDir()
+	gc := openTestStore(t, dir)
+
+	g := CreateGameWithRules(&Rules{Rows: 4, Columns: 4, WinLength: 4, Players: 2, ClockSeconds: 60, IncrementSeconds: 2}, "a", "b")
+	g.id = "clocks"
+	now := g.created
+	g.now = func() time.Time { return now }
+	gc.Add(g)
+	now = now.Add(10 * time.Second)
+	g.Move("a", 0)
+	now = now.Add(25 * time.Second)
+	g.Move("b", 0)
+	gc.store.Close()
+
+	// Clocks are charged for when moves were made, not when they are replayed.
+	gc = openTestStore(t, dir)
+	got, _ := gc.Get("clocks")
+	expectSameGame(t, got, g)
+	got.Lock()
+	if got.clocks["a"] != 52*time.Second || got.clocks["b"] != 37*time.Second || !got.turnStarted.Equal(now) {
+		t.Error("expected 52s and 37s got ", got.clocks)
+	}
+	got.Unlock()
+	gc.store.Close()
+}
+
+func Test_FileStoreTakebacks(t *testing.T) {
+	dir := t.TempDir()
+	gc := openTestStore(t, dir)
+
+	g := CreateGame(4, 4, 4, "a", "b")
+	g.id = "takeback"
+	gc.Add(g)
+	g.Move("a", 0)
+	g.RequestTakeback("a")
+	g.AnswerTakeback("b", true)
+	g.Move("a", 2)
+	if err := gc.Snapshot(); err != nil {
+		t.Fatal("snapshot failed ", err)
+	}
+	g.Move("b", 2)
+	g.RequestTakeback("b")
+	g.AnswerTakeback("a", true)
+	gc.store.Close()
+
+	// Takebacks already in the snapshot are not replayed from the log again.
+	gc = openTestStore(t, dir)
+	got, _ := gc.Get("takeback")
+	expectSameGame(t, got, g)
+	undone := got.Undone()
+	if len(undone) != 2 || undone[0].Column != 0 || undone[1].Player != "b" || got.MoveCount() != 1 {
+		t.Error("expected both takebacks to be kept got ", undone)
+	}
+	// The takeback replayed from the log is published as it was.
+	events, _, unsubscribe := got.Subscribe(0)
+	unsubscribe()
+	last := events[len(events)-1]
+	if last.Type != EventTakeback || last.Move != 1 || last.Player != "b" {
+		t.Error("expected the takeback event got ", *last)
+	}
+	gc.store.Close()
+}
+
+func Test_FileStoreTakebackDuringSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	gc := openTestStore(t, dir)
+
+	g := CreateGame(4, 4, 4, "a", "b")
+	g.id = "reused"
+	gc.Add(g)
+	g.Move("a", 0)
+	g.Move("b", 1)
+	// Move 2 is made and taken back after the log is rotated but before the
+	// game is recorded in the snapshot.
+	err := gc.store.Snapshot(func() []*game {
+		g.Move("a", 2)
+		g.RequestTakeback("a")
+		g.AnswerTakeback("b", true)
+		return []*game{g}
+	})
+	if err != nil {
+		t.Fatal("snapshot failed ", err)
+	}
+	g.Move("a", 3)
+	gc.store.Close()
+
+	gc = openTestStore(t, dir)
+	got, _ := gc.Get("reused")
+	expectSameGame(t, got, g)
+	if got.moves[2].col != 3 || len(got.Undone()) != 1 {
+		t.Error("expected the move made after the takeback got column ", got.moves[2].col)
+	}
+	gc.store.Close()
+}
+
+func Test_FileStoreFreePlacement(t *testing.T) {
+	dir := t.TempDir()
+	gc := openTestStore(t, dir)
+
+	g := CreateGameWithRules(&Rules{Rows: 3, Columns: 3, WinLength: 3, Players: 2, FreePlacement: true}, "x", "o")
+	g.id = "placed"
+	gc.Add(g)
+	g.Place("x", 0, 1)
+	g.Place("o", 2, 1)
+	gc.store.Close()
+
+	gc = openTestStore(t, dir)
+	got, _ := gc.Get("placed")
+	expectSameGame(t, got, g)
+	if got.board[0][1] != "x" || got.board[2][1] != "o" {
+		t.Error("expected the coins where they were placed got ", got.board)
+	}
+	gc.store.Close()
+}
+
+func Test_FileStoreSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	gc := openTestStore(t, dir)
+
+	g := CreateGame(4, 4, 4, "a", "b")
+	g.id = "snap"
+	gc.Add(g)
+	g.Move("a", 3)
+	g.Move("b", 1)
+
+	err := gc.Snapshot()
+	if err != nil {
+		t.Fatal("snapshot failed ", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, oldLogFile)); !os.IsNotExist(err) {
+		t.Error("expected old log to be removed")
+	}
+
+	// Moves after the snapshot are only in the log.
+	g.Move("a", 3)
+	other := CreateGame(4, 4, 4, "c", "d")
+	other.id = "after"
+	gc.Add(other)
+	other.Move("c", 0)
+	gc.store.Close()
+
+	gc = openTestStore(t, dir)
+	got, _ := gc.Get("snap")
+	expectSameGame(t, got, g)
+	got, _ = gc.Get("after")
+	expectSameGame(t, got, other)
+	gc.store.Close()
+}
+
+func Test_FileStorePartialWrite(t *testing.T) {
+	dir := t.TempDir()
+	gc := openTestStore(t, dir)
+
+	g := CreateGame(4, 4, 4, "a", "b")
+	g.id = "partial"
+	gc.Add(g)
+	g.Move("a", 0)
+	gc.store.Close()
+
+	// Simulate a crash part way through writing a move.
+	f, _ := os.OpenFile(filepath.Join(dir, logFile), os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write([]byte(`{"gameId":"partial","move":{"num`))
+	f.Close()
+
+	gc = openTestStore(t, dir)
+	got, _ := gc.Get("partial")
+	expectSameGame(t, got, g)
+	got.Move("b", 1)
+	gc.store.Close()
+
+	gc = openTestStore(t, dir)
+	again, _ := gc.Get("partial")
+	expectSameGame(t, again, got)
+	gc.store.Close()
+}
+
+func Test_ReapArchives(t *testing.T) {
+	dir := t.TempDir()
+	gc := openTestStore(t, dir)
+
+	done := CreateGame(4, 4, 4, "a", "b")
+	done.id = "done"
+	gc.Add(done)
+	done.Quit("b")
+
+	idle := CreateGame(4, 4, 4, "a", "b", "c")
+	idle.id = "idle"
+	gc.Add(idle)
+	idle.Move("a", 0)
+
+	// Nothing has been idle or finished for long enough yet.
+	now := time.Now()
+	done.lastActivity = now.Add(-2 * time.Hour)
+	gc.Reap(now, 3*time.Hour, time.Hour)
+	if stats := gc.Stats(); stats.Games != 2 || stats.Forfeits != 0 || stats.Evictions != 0 {
+		t.Fatal("expected nothing reaped got ", *stats)
+	}
+
+	// Only the player on turn forfeits.
+	gc.Reap(now.Add(time.Hour), 3*time.Hour, time.Hour)
+	if idle.players["b"] || !idle.players["c"] || idle.over {
+		t.Error("expected b to forfeit")
+	}
+	if _, ok := gc.Get("done"); ok {
+		t.Error("expected finished game to be evicted")
+	}
+
+	gc.Reap(now.Add(2*time.Hour), 3*time.Hour, time.Hour)
+	if !idle.over || idle.winner != "a" {
+		t.Error("expected c to forfeit and a to win")
+	}
+	if _, ok := gc.Get("idle"); !ok {
+		t.Error("expected just finished game to be kept")
+	}
+	stats := gc.Stats()
+	if stats.Games != 1 || stats.Finished != 1 || stats.Forfeits != 2 || stats.Evictions != 1 {
+		t.Error("unexpected stats ", *stats)
+	}
+	gc.store.Close()
+
+	// Archived games are not recovered, whether or not a snapshot was taken.
+	gc = openTestStore(t, dir)
+	if _, ok := gc.Get("done"); ok {
+		t.Error("expected archived game to stay removed")
+	}
+	got, _ := gc.Get("idle")
+	expectSameGame(t, got, idle)
+	if err := gc.Snapshot(); err != nil {
+		t.Fatal("snapshot failed ", err)
+	}
+	gc.Reap(now.Add(6*time.Hour), 3*time.Hour, time.Hour)
+	gc.store.Close()
+
+	gc = openTestStore(t, dir)
+	if games := gc.GetGames(); len(games) != 0 {
+		t.Error("expected every game to be archived got ", games)
+	}
+	gc.store.Close()
+
+	b, err := os.ReadFile(filepath.Join(dir, archiveFile))
+	if err != nil {
+		t.Fatal("unable to read archive ", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatal("expected 2 archived games got ", len(lines))
+	}
+	gr := &gameRecord{}
+	if err = json.Unmarshal([]byte(lines[1]), gr); err != nil {
+		t.Fatal("unable to decode archived game ", err)
+	}
+	archived, err := gr.rebuild()
+	if err != nil {
+		t.Fatal("unable to rebuild archived game ", err)
+	}
+	expectSameGame(t, archived, idle)
+}
+
+// snapshotOnArchive takes a snapshot whenever a game is archived.
+type snapshotOnArchive struct {
+	Store
+	gc *GamesContainer
+	t  *testing.T
+}
+
+func (s *snapshotOnArchive) Archive(g *game) error {
+	if !s.gc.TryLock() {
+		s.t.Error("expected the container to be unlocked while archiving")
+		return s.Store.Archive(g)
+	}
+	s.gc.Unlock()
+	if err := s.gc.Snapshot(); err != nil {
+		s.t.Error("snapshot failed ", err)
+	}
+	return s.Store.Archive(g)
+}
+
+func Test_ReapSnapshotDuringArchive(t *testing.T) {
+	dir := t.TempDir()
+	gc := openTestStore(t, dir)
+	gc.store = &snapshotOnArchive{gc.store, gc, t}
+
+	g := CreateGame(4, 4, 4, "a", "b")
+	g.id = "done"
+	gc.Add(g)
+	g.Quit("b")
+	gc.Reap(time.Now().Add(time.Hour), time.Minute, 0)
+	if stats := gc.Stats(); stats.Games != 0 || stats.Evictions != 1 {
+		t.Error("expected the game to be archived got ", *stats)
+	}
+	gc.store.Close()
+
+	gc = openTestStore(t, dir)
+	if _, ok := gc.Get("done"); ok {
+		t.Error("expected the archived game to stay removed")
+	}
+	gc.store.Close()
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// TakebackStatus is the outcome of asking for or answering a takeback.
+type TakebackStatus string
+
+var TakebackOK = TakebackStatus("OK")
+var TakebackWrongGame = TakebackStatus("WRONG_GAME")
+var TakebackGameOver = TakebackStatus("GAME_OVER")
+var TakebackNoMove = TakebackStatus("NO_MOVE")
+var TakebackPending = TakebackStatus("TAKEBACK_PENDING")
+var TakebackNone = TakebackStatus("NO_TAKEBACK")
+var TakebackOwn = TakebackStatus("OWN_TAKEBACK")
+
+// takebackRequest is a player asking to take back their last move. It is
+// granted once every other player still playing accepts, and dropped if
+// anyone declines or another move is made. Requests are not stored, so one
+// pending when the server restarts is forgotten.
+type takebackRequest struct {
+	player   string
+	move     int
+	accepted map[string]bool
+}
+
+// UndoneMove is a move that was taken back. Undone moves leave the move list
+// but are kept as an audit trail.
+type UndoneMove struct {
+	Number int       `json:"number"`
+	Player string    `json:"player"`
+	Type   MoveType  `json:"type"`
+	Row    int       `json:"row"`
+	Column int       `json:"column"`
+	Made   time.Time `json:"made"`
+	Undone time.Time `json:"undone"`
+
+	// Players who agreed to the takeback, engine players included.
+	AcceptedBy []string `json:"acceptedBy"`
+}
+
+// TakebackResponse is a pending takeback request.
+type TakebackResponse struct {
+	Move       int      `json:"move"`
+	Player     string   `json:"player"`
+	AcceptedBy []string `json:"acceptedBy"`
+	WaitingFor []string `json:"waitingFor"`
+}
+
+// RequestTakeback asks the other players to let playerId take back the last
+// move, which must be theirs. Engine players accept straight away.
+func (g *game) RequestTakeback(playerId string) TakebackStatus {
+	g.Lock()
+	defer g.Unlock()
+	if !g.isPlaying(playerId) {
+		return TakebackWrongGame
+	}
+	if g.over {
+		return TakebackGameOver
+	}
+	if g.takeback != nil {
+		return TakebackPending
+	}
+	if len(g.moves) == 0 {
+		return TakebackNoMove
+	}
+	last := g.moves[len(g.moves)-1]
+	if last.Type == MoveQuit || last.player != playerId {
+		return TakebackNoMove
+	}
+
+	g.takeback = &takebackRequest{
+		player:   playerId,
+		move:     len(g.moves) - 1,
+		accepted: map[string]bool{},
+	}
+	for _, player := range g.currentlyPlaying() {
+		if _, ok := g.bots[player]; ok {
+			g.takeback.accepted[player] = true
+		}
+	}
+	g.publish(&GameEvent{
+		Type:   EventTakebackRequested,
+		GameId: g.id,
+		Move:   g.takeback.move,
+		Player: playerId,
+	})
+	if len(g.takebackWaitingFor()) == 0 {
+		g.undo()
+	}
+	return TakebackOK
+}
+
+// AnswerTakeback accepts or declines the pending takeback for playerId. The
+// player asking may decline to withdraw it.
+func (g *game) AnswerTakeback(playerId string, accept bool) TakebackStatus {
+	g.Lock()
+	defer g.Unlock()
+	if !g.isPlaying(playerId) {
+		return TakebackWrongGame
+	}
+	if g.takeback == nil {
+		return TakebackNone
+	}
+	if !accept {
+		g.dropTakeback()
+		return TakebackOK
+	}
+	if playerId == g.takeback.player {
+		return TakebackOwn
+	}
+	g.takeback.accepted[playerId] = true
+	if len(g.takebackWaitingFor()) == 0 {
+		g.undo()
+	}
+	return TakebackOK
+}
+
+// takebackWaitingFor returns the players yet to accept the pending takeback.
+func (g *game) takebackWaitingFor() []string {
+	waiting := []string{}
+	for _, player := range g.currentlyPlaying() {
+		if player != g.takeback.player && !g.takeback.accepted[player] {
+			waiting = append(waiting, player)
+		}
+	}
+	return waiting
+}
+
+// dropTakeback declines the pending takeback, if there is one.
+func (g *game) dropTakeback() {
+	if g.takeback == nil {
+		return
+	}
+	g.publish(&GameEvent{
+		Type:   EventTakebackDeclined,
+		GameId: g.id,
+		Move:   g.takeback.move,
+		Player: g.takeback.player,
+	})
+	g.takeback = nil
+}
+
+// undo takes back the last move, which the pending takeback was asked for,
+// and gives its player their turn again.
+func (g *game) undo() {
+	accepted := []string{}
+	for _, player := range g.playerList {
+		if g.takeback.accepted[player] {
+			accepted = append(accepted, player)
+		}
+	}
+	g.takeback = nil
+
+	num := len(g.moves) - 1
+	last := g.moves[num]
+	now := g.now()
+	g.popMove()
+	um := &UndoneMove{
+		Number:     num,
+		Player:     last.player,
+		Type:       last.Type,
+		Row:        last.row,
+		Column:     last.col,
+		Made:       last.at,
+		Undone:     now,
+		AcceptedBy: accepted,
+	}
+	g.undone = append(g.undone, um)
+	// Time spent on the move stays off a clock, the new turn starts now.
+	g.turnStarted = now
+	g.lastActivity = now
+
+	if g.store != nil {
+		err := g.store.Undo(g.id, len(g.undone)-1, um)
+		if err != nil {
+			LOGGER.Println(fmt.Sprintf("failed to store takeback of move %d of game %s: %s", num, g.id, err))
+		}
+	}
+	g.publish(&GameEvent{
+		Type:   EventTakeback,
+		GameId: g.id,
+		Move:   num,
+		Player: last.player,
+	})
+	g.armFlag()
+	g.changed.Broadcast()
+}
+
+// popMove removes the last move from the move list, the board and its
+// player's coins, along with the increment it added to their clock. A coin
+// popped out is put back, shifting its column up.
+func (g *game) popMove() {
+	last := g.moves[len(g.moves)-1]
+	g.moves = g.moves[:len(g.moves)-1]
+	if g.rules.ClockSeconds > 0 {
+		g.clocks[last.player] -= time.Duration(g.rules.IncrementSeconds) * time.Second
+	}
+	if last.Type == MovePop {
+		column := []string{}
+		for row := 1; row < len(g.board); row++ {
+			column = append(column, g.board[row][last.col])
+		}
+		g.setColumn(last.col, append(column, last.player))
+		return
+	}
+	g.board[last.row][last.col] = ""
+	g.playerGraphs[last.player].Remove(last.row, last.col)
+}
+
+// Takeback returns the pending takeback request, if there is one.
+func (g *game) Takeback() *TakebackResponse {
+	g.RLock()
+	defer g.RUnlock()
+	return g.takebackResponse()
+}
+
+func (g *game) takebackResponse() *TakebackResponse {
+	if g.takeback == nil {
+		return nil
+	}
+	tr := &TakebackResponse{
+		Move:       g.takeback.move,
+		Player:     g.takeback.player,
+		AcceptedBy: []string{},
+		WaitingFor: g.takebackWaitingFor(),
+	}
+	for _, player := range g.playerList {
+		if g.takeback.accepted[player] {
+			tr.AcceptedBy = append(tr.AcceptedBy, player)
+		}
+	}
+	return tr
+}
+
+// Undone returns the moves taken back, oldest first.
+func (g *game) Undone() []*UndoneMove {
+	g.RLock()
+	defer g.RUnlock()
+	return append([]*UndoneMove{}, g.undone...)
+}
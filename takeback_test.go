@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func Test_Takeback(t *testing.T) {
+	g := CreateGame(4, 4, 4, "a", "b", "bot:easy")
+	g.Move("a", 1)
+	g.Move("b", 2)
+
+	if status := g.RequestTakeback("a"); status != TakebackNoMove {
+		t.Error("expected only the last mover to ask got ", status)
+	}
+	if status := g.RequestTakeback("b"); status != TakebackNoMove {
+		t.Error("expected the engine reply to be the last move got ", status)
+	}
+
+	g = CreateGame(4, 4, 4, "a", "bot:easy", "c")
+	g.Move("a", 1)
+	g.Move("c", 1)
+	last := g.moves[2]
+	if status := g.RequestTakeback("c"); status != TakebackOK {
+		t.Fatal("expected the takeback to be asked for got ", status)
+	}
+	if status := g.RequestTakeback("c"); status != TakebackPending {
+		t.Error("expected one takeback at a time got ", status)
+	}
+	if status := g.AnswerTakeback("c", true); status != TakebackOwn {
+		t.Error("expected c not to accept their own takeback got ", status)
+	}
+	tr := g.Takeback()
+	if strings.Join(tr.AcceptedBy, ",") != "bot:easy" || strings.Join(tr.WaitingFor, ",") != "a" {
+		t.Error("expected the engine player to accept got ", *tr)
+	}
+
+	g.AnswerTakeback("a", true)
+	if len(g.moves) != 2 || g.board[last.row][1] != "" || g.playerGraphs["c"].Get(last.row, 1) || g.nextMove() != "c" {
+		t.Fatal("expected the move to be taken back")
+	}
+	undone := g.Undone()
+	if len(undone) != 1 || undone[0].Number != 2 || undone[0].Column != 1 ||
+		strings.Join(undone[0].AcceptedBy, ",") != "a,bot:easy" || g.Takeback() != nil {
+		t.Error("unexpected audit trail ", undone)
+	}
+
+	// Moving on declines the takeback.
+	g.Move("c", 0)
+	g.RequestTakeback("c")
+	g.Move("a", 3)
+	if g.Takeback() != nil || len(g.Undone()) != 1 {
+		t.Error("expected the takeback to be dropped")
+	}
+	if status := g.AnswerTakeback("a", false); status != TakebackNone {
+		t.Error("expected no takeback to answer got ", status)
+	}
+}
+
+func Test_TakebackClock(t *testing.T) {
+	g := CreateGameWithRules(&Rules{Rows: 4, Columns: 4, WinLength: 4, Players: 2, ClockSeconds: 60, IncrementSeconds: 5}, "a", "b")
+	now := g.created
+	g.now = func() time.Time { return now }
+
+	// Moving and taking it back gains no time, however often it is done.
+	for i := 0; i < 3; i++ {
+		now = now.Add(10 * time.Second)
+		g.Move("a", 0)
+		g.RequestTakeback("a")
+		g.AnswerTakeback("b", true)
+	}
+	if clocks := g.Clocks(); clocks["a"] != 30*time.Second || clocks["b"] != 60*time.Second {
+		t.Error("expected 30s and 60s got ", clocks)
+	}
+}
+
+func takebackCall(method, player, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, apiURL("takebacks/"+player+"/takeback"), nil)
+	r = mux.SetURLVars(r, map[string]string{"gameId": "takebacks", "playerId": player})
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	takebackHandler(w, r)
+	return w
+}
+
+func Test_takebackHandler(t *testing.T) {
+	g := CreateGame(4, 4, 4, "a", "b")
+	g.id = "takebacks"
+	GAMES.Add(g)
+	g.Move("a", 0)
+
+	err := expectWithWriter(takebackCall("POST", "a", ""), http.StatusUnauthorized,
+		`{"error":{"code":"MISSING_TOKEN","message":"missing bearer token","field":"Authorization","gameId":"takebacks"}}`)
+	if err != nil {
+		t.Error(err)
+	}
+	err = expectWithWriter(takebackCall("POST", "b", "secret"), http.StatusConflict,
+		`{"error":{"code":"NO_MOVE","message":"the last move is not b's to take back","gameId":"takebacks"}}`)
+	if err != nil {
+		t.Error(err)
+	}
+	if w := takebackCall("POST", "a", "secret"); w.Code != http.StatusAccepted {
+		t.Fatal("expected 202 got ", w.Code)
+	}
+	if status := g.GameStatus(); status.Takeback == nil || status.Takeback.Player != "a" {
+		t.Error("expected the pending takeback in the status")
+	}
+
+	// Declining drops the request.
+	if w := takebackCall("DELETE", "b", "secret"); w.Code != http.StatusAccepted {
+		t.Fatal("expected 202 got ", w.Code)
+	}
+	err = expectWithWriter(takebackCall("PUT", "b", "secret"), http.StatusConflict,
+		`{"error":{"code":"NO_TAKEBACK","message":"no takeback is pending","gameId":"takebacks"}}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	takebackCall("POST", "a", "secret")
+	if w := takebackCall("PUT", "b", "secret"); w.Code != http.StatusAccepted {
+		t.Fatal("expected 202 got ", w.Code)
+	}
+
+	r := httptest.NewRequest("GET", apiURL("takebacks/takebacks"), nil)
+	r = mux.SetURLVars(r, map[string]string{"gameId": "takebacks"})
+	w := httptest.NewRecorder()
+	takebackListHandler(w, r)
+	tl := &TakebackList{}
+	json.NewDecoder(w.Body).Decode(tl)
+	if len(tl.Takebacks) != 1 || tl.Takebacks[0].Player != "a" || tl.Takebacks[0].Row != 3 || g.MoveCount() != 0 {
+		t.Error("expected the move in the audit trail got ", tl.Takebacks)
+	}
+
+	g.Quit("b")
+	err = expectWithWriter(takebackCall("POST", "a", "secret"), http.StatusGone,
+		`{"error":{"code":"GAME_OVER","message":"game is over","gameId":"takebacks"}}`)
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"encoding/json"
 	"io/ioutil"
@@ -15,7 +17,11 @@ type MoveResponse struct {
 	Type   MoveType `json:"type"`
 	Player string   `json:"player"`
 
-	Column int `json:"column,omitempty"`
+	Row    *int `json:"row,omitempty"`
+	Column *int `json:"column,omitempty"`
+
+	// Set on the move that won the game.
+	WinningLines []*WinningLine `json:"winningLines,omitempty"`
 }
 type MovesRangeResponse struct {
 	Moves []MoveResponse `json:"moves"`
@@ -24,108 +30,753 @@ type MovesRangeResponse struct {
 type MovesRangeRequest struct {
 	Start int
 	Until int
+
+	// How long to wait for a move after Start, if there is none yet.
+	Wait time.Duration
 }
 
 type GameStatusResponse struct {
 	Players []string   `json:"players"`
 	Status  GameStatus `json:"state"`
 	Winner  string     `json:"winner,omitempty"`
+	Rules   *Rules     `json:"rules"`
+
+	// Seats yet to be claimed while the game is WAITING.
+	OpenSeats int `json:"openSeats,omitempty"`
+
+	// Milliseconds each player still playing has left, for timed games.
+	Clocks map[string]int64 `json:"clocks,omitempty"`
+
+	Takeback *TakebackResponse `json:"takeback,omitempty"`
+
+	// Players seated on each team, in games with teams.
+	Teams [][]string `json:"teams,omitempty"`
+
+	// Every player on the winner's team, quit or not.
+	WinningTeam []string `json:"winningTeam,omitempty"`
+
+	// Lines each player's side owns so far, in scoring games.
+	Scores map[string]int `json:"scores,omitempty"`
+
+	// Every player's place once the game is DONE.
+	Ranking []*Standing `json:"ranking,omitempty"`
+
+	WinningLines []*WinningLine `json:"winningLines,omitempty"`
 }
 
+// CreateGameRequest describes a new game. Omitted board dimensions and win
+// length fall back to the server defaults. Seats beyond the players named are
+// left open for others to claim, by invite or by anyone if the game is public.
+//
+// With teams, seat i plays for team i mod teams, so players named in order
+// a, b, c, d make teams [a c] and [b d]. TeamPlayers names every player by
+// team instead, each team listed in the order its players take turns, and
+// sets players and teams to match.
 type CreateGameRequest struct {
-	Players []string `json:"players"`
-	Columns int      `json:"columns"`
-	Rows    int      `json:"rows"`
+	Players   []string `json:"players"`
+	Seats     int      `json:"seats"`
+	Public    bool     `json:"public"`
+	Columns   int      `json:"columns"`
+	Rows      int      `json:"rows"`
+	WinLength int      `json:"winLength"`
+
+	MoveSeconds      int `json:"moveSeconds"`
+	ClockSeconds     int `json:"clockSeconds"`
+	IncrementSeconds int `json:"incrementSeconds"`
+
+	PopOut        bool     `json:"popOut"`
+	FreePlacement bool     `json:"freePlacement"`
+	Topology      Topology `json:"topology"`
+	Teams         int      `json:"teams"`
+
+	WinCondition WinCondition `json:"winCondition"`
+
+	TeamPlayers [][]string `json:"teamPlayers"`
 }
 
+// Rules returns the game rules requested, filling in server defaults.
+func (cgr *CreateGameRequest) Rules() *Rules {
+	rules := &Rules{
+		Rows:      cgr.Rows,
+		Columns:   cgr.Columns,
+		WinLength: cgr.WinLength,
+		Players:   cgr.Seats,
+
+		MoveSeconds:      cgr.MoveSeconds,
+		ClockSeconds:     cgr.ClockSeconds,
+		IncrementSeconds: cgr.IncrementSeconds,
+
+		PopOut:        cgr.PopOut,
+		FreePlacement: cgr.FreePlacement,
+		Topology:      cgr.Topology,
+		Teams:         cgr.Teams,
+
+		WinCondition: cgr.WinCondition,
+	}
+	if rules.Topology == TopologyFlat {
+		rules.Topology = ""
+	}
+	if rules.WinCondition == WinLine {
+		rules.WinCondition = ""
+	}
+	if rules.Players == 0 {
+		rules.Players = len(cgr.Players)
+	}
+	if rules.Rows == 0 {
+		rules.Rows = *BOARD_WIDTH
+	}
+	if rules.Columns == 0 {
+		rules.Columns = *BOARD_LENGTH
+	}
+	if rules.WinLength == 0 {
+		rules.WinLength = *CONSECUTIVE_LENGTH
+	}
+	return rules
+}
+
+// CreateGameResponse is the only place seat tokens are given out, to those
+// creating a game for the players named and to each player claiming a seat.
 type CreateGameResponse struct {
-	GameId string `json:"gameId"`
+	GameId string            `json:"gameId"`
+	Tokens map[string]string `json:"tokens"`
+
+	// Single-use invites to the open seats.
+	Invites []string `json:"invites,omitempty"`
+}
+
+// TakebackList is the audit trail of moves taken back in a game.
+type TakebackList struct {
+	Takebacks []*UndoneMove `json:"takebacks"`
+}
+
+// JoinGameRequest claims an open seat.
+type JoinGameRequest struct {
+	Player string `json:"player"`
 }
 type MoveRequest struct {
 	Column int `json:"column"`
+
+	// Required in games with free placement, ignored otherwise.
+	Row *int `json:"row"`
+
+	// MOVE drops a coin, POP pops one out. Omitted it is MOVE.
+	Type MoveType `json:"type"`
 }
 
 type GameList struct {
 	Games []string `json:"games"`
+
+	// Set when the request asks for summaries, in the same order as Games.
+	Summaries []*GameSummary `json:"summaries,omitempty"`
+
+	// Cursor of the next page, empty on the last page.
+	Next string `json:"next,omitempty"`
+}
+
+// GameSummary is enough of a game to list it without asking for its status.
+type GameSummary struct {
+	Id      string     `json:"id"`
+	Created time.Time  `json:"created"`
+	Players []string   `json:"players"`
+	Status  GameStatus `json:"state"`
+	Rules   *Rules     `json:"rules"`
+	Moves   int        `json:"moves"`
+	Winner  string     `json:"winner,omitempty"`
+
+	OpenSeats int `json:"openSeats,omitempty"`
+}
+
+const defaultLeaderboardLimit = 100
+const maxGameListLimit = 1000
+
+// GameListRequest filters and pages the list of games. Zero values match
+// every game.
+type GameListRequest struct {
+	Status        GameStatus
+	Player        string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+
+	// Games listed after this one.
+	After *GameCursor
+
+	// Most games listed, every game when zero.
+	Limit   int
+	Summary bool
+}
+
+// GameCursor is the position of a game in the list, ordered by creation time
+// and then id.
+type GameCursor struct {
+	Created time.Time
+	Id      string
+}
+
+func (gc *GameCursor) String() string {
+	raw := fmt.Sprintf("%d/%s", gc.Created.UnixNano(), gc.Id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func parseGameCursor(cursor string) (*GameCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fieldError("cursor", "invalid cursor")
+	}
+	parts := strings.SplitN(string(raw), "/", 2)
+	if len(parts) != 2 {
+		return nil, fieldError("cursor", "invalid cursor")
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, fieldError("cursor", "invalid cursor")
+	}
+	return &GameCursor{Created: time.Unix(0, nanos), Id: parts[1]}, nil
+}
+
+// Before returns if the game at gc is listed before the one at other.
+func (gc *GameCursor) Before(other *GameCursor) bool {
+	if !gc.Created.Equal(other.Created) {
+		return gc.Created.Before(other.Created)
+	}
+	return gc.Id < other.Id
+}
+
+// LeaderboardEntry is a player's rating and place on the leaderboard,
+// counting from 1.
+type LeaderboardEntry struct {
+	Rank int `json:"rank"`
+	*PlayerRating
+}
+
+type LeaderboardResponse struct {
+	Players []*LeaderboardEntry `json:"players"`
+
+	// Cursor of the next page, empty on the last page.
+	Next string `json:"next,omitempty"`
+}
+
+// LeaderboardRequest pages through the leaderboard.
+type LeaderboardRequest struct {
+	// Players listed after this one.
+	After *PlayerRating
+
+	Limit int
+}
+
+// MatchRequest asks for a game with the given rules against whoever else is
+// waiting for one. Omitted rules fall back to the server defaults, with the
+// minimum number of players.
+type MatchRequest struct {
+	Player    string `json:"player"`
+	Players   int    `json:"players"`
+	Columns   int    `json:"columns"`
+	Rows      int    `json:"rows"`
+	WinLength int    `json:"winLength"`
+
+	MoveSeconds      int `json:"moveSeconds"`
+	ClockSeconds     int `json:"clockSeconds"`
+	IncrementSeconds int `json:"incrementSeconds"`
+
+	PopOut        bool     `json:"popOut"`
+	FreePlacement bool     `json:"freePlacement"`
+	Topology      Topology `json:"topology"`
+	Teams         int      `json:"teams"`
+
+	WinCondition WinCondition `json:"winCondition"`
+}
+
+// MatchTicketResponse is a player's place in the matchmaking queue. The game
+// and the player's seat token are filled in once they are matched.
+type MatchTicketResponse struct {
+	Ticket string       `json:"ticket"`
+	Player string       `json:"player"`
+	Rules  *Rules       `json:"rules"`
+	Status TicketStatus `json:"status"`
+	GameId string       `json:"gameId,omitempty"`
+	Token  string       `json:"token,omitempty"`
+}
+
+func ratingCursor(pr *PlayerRating) string {
+	raw := strconv.FormatFloat(pr.Rating, 'g', -1, 64) + "/" + pr.Id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func parseRatingCursor(cursor string) (*PlayerRating, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fieldError("cursor", "invalid cursor")
+	}
+	parts := strings.SplitN(string(raw), "/", 2)
+	if len(parts) != 2 {
+		return nil, fieldError("cursor", "invalid cursor")
+	}
+	rating, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return nil, fieldError("cursor", "invalid cursor")
+	}
+	return &PlayerRating{Id: parts[1], Rating: rating}, nil
+}
+
+// validateLeaderboard reads the cursor and limit of a leaderboard request.
+func validateLeaderboard(r *http.Request) (*LeaderboardRequest, error) {
+	vals := r.URL.Query()
+	lr := &LeaderboardRequest{Limit: defaultLeaderboardLimit}
+	var err error
+	if cursor := strings.TrimSpace(vals.Get("cursor")); cursor != "" {
+		lr.After, err = parseRatingCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if limit := strings.TrimSpace(vals.Get("limit")); limit != "" {
+		lr.Limit, err = strconv.Atoi(limit)
+		if err != nil || lr.Limit < 1 || lr.Limit > maxGameListLimit {
+			return nil, fieldError("limit", "limit must be between 1 and %d", maxGameListLimit)
+		}
+	}
+	return lr, nil
+}
+
+// validateGameList reads the filters of a game list request: state, player,
+// created_after and created_before as RFC 3339 times, cursor, limit and
+// summary.
+func validateGameList(r *http.Request) (*GameListRequest, error) {
+	vals := r.URL.Query()
+	glr := &GameListRequest{}
+
+	state := strings.TrimSpace(vals.Get("state"))
+	switch strings.ToUpper(state) {
+	case "":
+	case string(STATUS_WAITING):
+		glr.Status = STATUS_WAITING
+	case string(STATUS_IN_PROGRESS):
+		glr.Status = STATUS_IN_PROGRESS
+	case string(STATUS_DONE):
+		glr.Status = STATUS_DONE
+	default:
+		return nil, fieldError("state", "unknown state %s", state)
+	}
+	glr.Player = strings.TrimSpace(vals.Get("player"))
+
+	var err error
+	if after := strings.TrimSpace(vals.Get("created_after")); after != "" {
+		glr.CreatedAfter, err = time.Parse(time.RFC3339, after)
+		if err != nil {
+			return nil, fieldError("created_after", "invalid created_after time")
+		}
+	}
+	if before := strings.TrimSpace(vals.Get("created_before")); before != "" {
+		glr.CreatedBefore, err = time.Parse(time.RFC3339, before)
+		if err != nil {
+			return nil, fieldError("created_before", "invalid created_before time")
+		}
+	}
+
+	if cursor := strings.TrimSpace(vals.Get("cursor")); cursor != "" {
+		glr.After, err = parseGameCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if limit := strings.TrimSpace(vals.Get("limit")); limit != "" {
+		glr.Limit, err = strconv.Atoi(limit)
+		if err != nil || glr.Limit < 1 || glr.Limit > maxGameListLimit {
+			return nil, fieldError("limit", "limit must be between 1 and %d", maxGameListLimit)
+		}
+	}
+	if summary := strings.TrimSpace(vals.Get("summary")); summary != "" {
+		glr.Summary, err = strconv.ParseBool(summary)
+		if err != nil {
+			return nil, fieldError("summary", "invalid summary flag")
+		}
+	}
+	return glr, nil
 }
 
 // validateMoveList returns a range between 0 and -1, where -1 means to the end of the list.
+// Long polling clients instead pass since, the number of moves they have
+// seen, and how long to wait for the next one.
 func validateMoveList(r *http.Request) (*MovesRangeRequest, error) {
 	var err error
 
 	vals := r.URL.Query()
 	if len(vals) == 0 {
-		return &MovesRangeRequest{0, -1}, nil
+		return &MovesRangeRequest{Start: 0, Until: -1}, nil
 	}
 	start := 0
 	until := -1
 
-	startStrings, ok := vals["start"]
-	if ok {
+	startStrings, hasStart := vals["start"]
+	if hasStart {
 		startStr := strings.TrimSpace(startStrings[0])
 		start, err = strconv.Atoi(startStr)
 		if err != nil {
 			// Invalid query parameter value.
-			return nil, errors.New("invalid start conversion")
+			return nil, fieldError("start", "invalid start conversion")
 		}
 	}
-	untilStrings, ok := vals["until"]
-	if ok {
+	untilStrings, hasUntil := vals["until"]
+	if hasUntil {
 		untilStr := strings.TrimSpace(untilStrings[0])
 		until, err = strconv.Atoi(untilStr)
 		if err != nil {
 			// Invalid query parameter value.
-			return nil, errors.New("invalid until conversion")
+			return nil, fieldError("until", "invalid until conversion")
 		}
 	}
-	if start < 0 || start > until {
-		return nil, errors.New("bad range request")
+
+	sinceStrings, hasSince := vals["since"]
+	waitStrings, hasWait := vals["wait"]
+	if hasWait && !hasSince {
+		return nil, fieldError("wait", "wait requires since")
+	}
+	if hasSince {
+		if hasStart || hasUntil {
+			return nil, fieldError("since", "since cannot be combined with start or until")
+		}
+		sinceStr := strings.TrimSpace(sinceStrings[0])
+		start, err = strconv.Atoi(sinceStr)
+		if err != nil || start < 0 {
+			return nil, fieldError("since", "invalid since conversion")
+		}
+	} else if start < 0 || start > until {
+		return nil, fieldError("start", "bad range request")
 	}
 
 	rangeReq := &MovesRangeRequest{
 		Start: start,
 		Until: until,
 	}
+	if hasWait {
+		rangeReq.Wait, err = parseWait(strings.TrimSpace(waitStrings[0]))
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	return rangeReq, nil
 }
 
-// validateCreateGame takes an
+// parseWait accepts a duration such as 30s or a number of seconds, capped at
+// the configured maximum.
+func parseWait(waitStr string) (time.Duration, error) {
+	wait, err := time.ParseDuration(waitStr)
+	if err != nil {
+		var secs int
+		secs, err = strconv.Atoi(waitStr)
+		wait = time.Duration(secs) * time.Second
+	}
+	if err != nil || wait < 0 {
+		return 0, fieldError("wait", "invalid wait conversion")
+	}
+	if wait > *MAX_WAIT {
+		wait = *MAX_WAIT
+	}
+	return wait, nil
+}
+
+// validateFrom returns the move number a client wants events from, 0 if unset.
+func validateFrom(r *http.Request) (int, error) {
+	fromStr := strings.TrimSpace(r.URL.Query().Get("from"))
+	if fromStr == "" {
+		return 0, nil
+	}
+	from, err := strconv.Atoi(fromStr)
+	if err != nil || from < 0 {
+		return 0, fieldError("from", "invalid from")
+	}
+	return from, nil
+}
+
+// validateLastEventId returns the id of the last event a reconnecting event
+// stream client saw, -1 if it has seen none.
+func validateLastEventId(r *http.Request) (int, error) {
+	idStr := strings.TrimSpace(r.Header.Get("Last-Event-ID"))
+	if idStr == "" {
+		return -1, nil
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id < 0 {
+		return 0, fieldError("Last-Event-ID", "invalid Last-Event-ID")
+	}
+	return id, nil
+}
+
+// validateBoardFormat returns the ?format= asked for, or else the first of
+// the Accept header's types that a board can be rendered as, JSON by default.
+func validateBoardFormat(r *http.Request) (BoardFormat, error) {
+	format := BoardFormat(strings.TrimSpace(r.URL.Query().Get("format")))
+	if format != "" {
+		if _, ok := BOARD_CONTENT_TYPES[format]; !ok {
+			return "", fieldError("format", "unknown format %s", format)
+		}
+		return format, nil
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType := strings.TrimSpace(strings.Split(accept, ";")[0])
+		switch mediaType {
+		case "application/json":
+			return BoardJSON, nil
+		case "image/svg+xml":
+			return BoardSVG, nil
+		case "text/plain":
+			return BoardASCII, nil
+		}
+	}
+	return BoardJSON, nil
+}
+
+// validateBearer returns the token of an "Authorization: Bearer" header.
+func validateBearer(r *http.Request) (string, bool) {
+	auth := strings.TrimSpace(r.Header.Get("Authorization"))
+	parts := strings.SplitN(auth, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return "", false
+	}
+	token := strings.TrimSpace(parts[1])
+	return token, token != ""
+}
+
+// validateMakeMove parses the cell and type of a move from the request body.
 func validateMakeMove(r *http.Request) (*MoveRequest, *APIError) {
 	b, err := ioutil.ReadAll(r.Body)
 	defer r.Body.Close()
 	if err != nil {
 		LOGGER.Println(fmt.Sprintf("failed to read body %s", err))
-		return nil, &APIError{"server error", http.StatusInternalServerError}
+		return nil, serverError()
 	}
 	mr := &MoveRequest{}
 	err = json.Unmarshal(b, mr)
 	if err != nil {
-		return nil, &APIError{"malformed input", http.StatusBadRequest}
+		return nil, malformedInput(err)
+	}
+	switch mr.Type {
+	case "":
+		mr.Type = MoveMove
+	case MoveMove, MovePop:
+	default:
+		return nil, invalidRequest(ErrInvalidParameter,
+			fieldError("type", "type must be %s or %s, got %s", MoveMove, MovePop, mr.Type))
 	}
 	return mr, nil
 }
 
-// validateCreateGame takes an
+// validateCreateGame parses a CreateGameRequest and checks its rules are within
+// the server bounds.
 func validateCreateGame(r *http.Request) (*CreateGameRequest, *APIError) {
 	b, err := ioutil.ReadAll(r.Body)
 	defer r.Body.Close()
 	if err != nil {
-		return nil, &APIError{"server error", http.StatusInternalServerError}
+		return nil, serverError()
 	}
 	cgr := &CreateGameRequest{}
 	err = json.Unmarshal(b, cgr)
 	if err != nil {
-		return nil, &APIError{"malformed input", http.StatusBadRequest}
+		return nil, malformedInput(err)
+	}
+	APIerr := cgr.seatTeams()
+	if APIerr != nil {
+		return nil, APIerr
 	}
-	if len(cgr.Players) != *NUM_PLAYERS {
-		return nil, &APIError{fmt.Sprintf("num players is not %d", *NUM_PLAYERS),
-			http.StatusBadRequest}
+	APIerr = validatePlayers(cgr.Players)
+	if APIerr != nil {
+		return nil, APIerr
 	}
-	if cgr.Rows != *BOARD_WIDTH || cgr.Columns != *BOARD_LENGTH {
-		msg := fmt.Sprintf("expecting 4 rows and 4 columns, got rows %d cols %d",
-			cgr.Rows, cgr.Columns)
-		return nil, &APIError{msg, http.StatusBadRequest}
+	if cgr.Seats != 0 && cgr.Seats < len(cgr.Players) {
+		return nil, invalidRequest(ErrInvalidPlayers,
+			fieldError("seats", "%d seats is fewer than the %d players named", cgr.Seats, len(cgr.Players)))
+	}
+	err = serverBounds().Validate(cgr.Rules())
+	if err != nil {
+		return nil, invalidRequest(ErrInvalidRules, err)
 	}
 	return cgr, nil
 }
+
+// seatTeams sets the players and teams of a request naming the players of each
+// team, seating the teams in turn.
+func (cgr *CreateGameRequest) seatTeams() *APIError {
+	if len(cgr.TeamPlayers) == 0 {
+		return nil
+	}
+	if len(cgr.Players) > 0 || cgr.Seats != 0 || cgr.Teams != 0 {
+		return invalidRequest(ErrInvalidPlayers,
+			fieldError("teamPlayers", "teamPlayers is given instead of players, seats and teams"))
+	}
+	size := len(cgr.TeamPlayers[0])
+	for team, players := range cgr.TeamPlayers {
+		if len(players) != size {
+			return invalidRequest(ErrInvalidPlayers,
+				fieldError("teamPlayers", "team %d has %d players, team 0 has %d", team, len(players), size))
+		}
+	}
+	for seat := 0; seat < size; seat++ {
+		for _, players := range cgr.TeamPlayers {
+			cgr.Players = append(cgr.Players, players[seat])
+		}
+	}
+	cgr.Teams = len(cgr.TeamPlayers)
+	return nil
+}
+
+// validatePlayers checks player ids are unique, engine players have a known
+// level and there is at least one person.
+func validatePlayers(players []string) *APIError {
+	seen := map[string]bool{}
+	people := 0
+	for _, player := range players {
+		if player == "" {
+			return invalidRequest(ErrInvalidPlayers, fieldError("players", "empty player id"))
+		}
+		if seen[player] {
+			return invalidRequest(ErrInvalidPlayers, fieldError("players", "duplicate player %s", player))
+		}
+		seen[player] = true
+		if !isBot(player) {
+			people++
+			continue
+		}
+		if _, ok := parseBot(player); !ok {
+			return invalidRequest(ErrInvalidPlayers, fieldError("players", "unknown bot %s", player))
+		}
+	}
+	if people == 0 && len(players) > 0 {
+		return invalidRequest(ErrInvalidPlayers,
+			fieldError("players", "at least one player must not be a bot"))
+	}
+	return nil
+}
+
+// validateImportGame parses a game record and checks its players and rules
+// could have made a new game.
+func validateImportGame(r *http.Request) (*Notation, *APIError) {
+	b, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		return nil, serverError()
+	}
+	n, err := ParseNotation(string(b))
+	if err != nil {
+		return nil, recordRejection(err)
+	}
+	APIerr := validatePlayers(n.Players)
+	if APIerr != nil {
+		return nil, APIerr
+	}
+	// Rules left out fall back to the server defaults as when creating a game.
+	cgr := &CreateGameRequest{
+		Players:   n.Players,
+		Rows:      n.Rules.Rows,
+		Columns:   n.Rules.Columns,
+		WinLength: n.Rules.WinLength,
+
+		MoveSeconds:      n.Rules.MoveSeconds,
+		ClockSeconds:     n.Rules.ClockSeconds,
+		IncrementSeconds: n.Rules.IncrementSeconds,
+
+		PopOut:        n.Rules.PopOut,
+		FreePlacement: n.Rules.FreePlacement,
+		Topology:      n.Rules.Topology,
+		Teams:         n.Rules.Teams,
+
+		WinCondition: n.Rules.WinCondition,
+	}
+	n.Rules = *cgr.Rules()
+	err = serverBounds().Validate(&n.Rules)
+	if err != nil {
+		return nil, invalidRequest(ErrInvalidRules, err)
+	}
+	return n, nil
+}
+
+// malformedInput is a 400 for a body that is not the JSON expected, naming
+// the field of the wrong type if there is one.
+func malformedInput(err error) *APIError {
+	APIerr := mkAPIError(http.StatusBadRequest, ErrMalformedInput, "malformed input")
+	var ute *json.UnmarshalTypeError
+	if errors.As(err, &ute) {
+		APIerr.Field = ute.Field
+	}
+	return APIerr
+}
+
+// validateMatchRequest parses a MatchRequest and checks its rules are within
+// the server bounds. Engine players cannot wait for a game.
+func validateMatchRequest(r *http.Request) (string, *Rules, *APIError) {
+	b, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		return "", nil, serverError()
+	}
+	mr := &MatchRequest{}
+	err = json.Unmarshal(b, mr)
+	if err != nil {
+		return "", nil, malformedInput(err)
+	}
+	if mr.Player == "" {
+		return "", nil, invalidRequest(ErrInvalidPlayers, fieldError("player", "empty player id"))
+	}
+	if isBot(mr.Player) {
+		return "", nil, invalidRequest(ErrInvalidPlayers,
+			fieldError("player", "bot %s cannot wait for a game", mr.Player))
+	}
+	cgr := &CreateGameRequest{
+		Rows:      mr.Rows,
+		Columns:   mr.Columns,
+		WinLength: mr.WinLength,
+
+		MoveSeconds:      mr.MoveSeconds,
+		ClockSeconds:     mr.ClockSeconds,
+		IncrementSeconds: mr.IncrementSeconds,
+
+		PopOut:        mr.PopOut,
+		FreePlacement: mr.FreePlacement,
+		Topology:      mr.Topology,
+		Teams:         mr.Teams,
+
+		WinCondition: mr.WinCondition,
+	}
+	rules := cgr.Rules()
+	rules.Players = mr.Players
+	if rules.Players == 0 {
+		rules.Players = *MIN_PLAYERS
+	}
+	err = serverBounds().Validate(rules)
+	if err != nil {
+		return "", nil, invalidRequest(ErrInvalidRules, err)
+	}
+	return mr.Player, rules, nil
+}
+
+// validateTicketWait reads how long a ticket request may wait to be matched,
+// 0 if unset.
+func validateTicketWait(r *http.Request) (time.Duration, error) {
+	waitStr := strings.TrimSpace(r.URL.Query().Get("wait"))
+	if waitStr == "" {
+		return 0, nil
+	}
+	return parseWait(waitStr)
+}
+
+// validateJoinGame parses a JoinGameRequest. Engine players are only seated
+// when a game is created.
+func validateJoinGame(r *http.Request) (string, *APIError) {
+	b, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		return "", serverError()
+	}
+	jgr := &JoinGameRequest{}
+	err = json.Unmarshal(b, jgr)
+	if err != nil {
+		return "", malformedInput(err)
+	}
+	if jgr.Player == "" {
+		return "", invalidRequest(ErrInvalidPlayers, fieldError("player", "empty player id"))
+	}
+	if isBot(jgr.Player) {
+		return "", invalidRequest(ErrInvalidPlayers,
+			fieldError("player", "bot %s cannot claim a seat", jgr.Player))
+	}
+	return jgr.Player, nil
+}
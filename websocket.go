@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	socketWriteWait  = 10 * time.Second
+	socketPingPeriod = 30 * time.Second
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+func writeSocketEvent(conn *websocket.Conn, e *GameEvent) error {
+	conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+	return conn.WriteJSON(e)
+}
+
+func closeSocket(conn *websocket.Conn, code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(socketWriteWait))
+}
+
+// gameSocketHandler pushes a game's events over a WebSocket until the game is
+// over. A reconnecting client passes ?from= with the first move number it
+// has not seen to receive what it missed.
+func gameSocketHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	g, ok := GAMES.Get(vars["gameId"])
+	if !ok {
+		writeError(w, mkAPIError(http.StatusNotFound, ErrUnknownGame, "unknown game").forGame(vars["gameId"]))
+		return
+	}
+	from, err := validateFrom(r)
+	if err != nil {
+		writeError(w, invalidRequest(ErrInvalidParameter, err))
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied to the client.
+		LOGGER.Println(fmt.Sprintf("websocket upgrade failed %s", err))
+		return
+	}
+	defer conn.Close()
+
+	backlog, events, unsubscribe := g.Subscribe(from)
+	defer unsubscribe()
+
+	// Nothing is expected from the client, but reading handles control
+	// messages and notices when it goes away.
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, e := range backlog {
+		if err = writeSocketEvent(conn, e); err != nil {
+			return
+		}
+		if e.Type == EventGameOver {
+			closeSocket(conn, websocket.CloseNormalClosure, "game over")
+			return
+		}
+	}
+
+	ping := time.NewTicker(socketPingPeriod)
+	defer ping.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				closeSocket(conn, websocket.CloseTryAgainLater, "too far behind")
+				return
+			}
+			if err = writeSocketEvent(conn, e); err != nil {
+				return
+			}
+			if e.Type == EventGameOver {
+				closeSocket(conn, websocket.CloseNormalClosure, "game over")
+				return
+			}
+		case <-ping.C:
+			deadline := time.Now().Add(socketWriteWait)
+			if err = conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
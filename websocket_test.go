@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func dialGame(t *testing.T, server *httptest.Server, resource string) *websocket.Conn {
+	url := strings.Replace(server.URL, "http", "ws", 1) + "/" + *API_PREFIX + "/" + resource
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal("unable to dial ", url, " ", err)
+	}
+	return conn
+}
+
+func expectSocketEvent(t *testing.T, conn *websocket.Conn, typ EventType, move int) *GameEvent {
+	e := &GameEvent{}
+	err := conn.ReadJSON(e)
+	if err != nil {
+		t.Fatal("expected ", typ, " event got ", err)
+	}
+	if e.Type != typ || e.Move != move {
+		t.Error("expected ", typ, " for move ", move, " got ", e.Type, " for move ", e.Move)
+	}
+	return e
+}
+
+func Test_gameSocketHandler(t *testing.T) {
+	server := httptest.NewServer(configureRouter(*API_PREFIX))
+	defer server.Close()
+
+	g := CreateGame(4, 4, 4, "a", "b", "c")
+	g.id = "socket"
+	GAMES.Add(g)
+	g.Move("a", 3)
+	g.Move("b", 1)
+
+	// Resume from the second move.
+	conn := dialGame(t, server, "socket/ws?from=1")
+	defer conn.Close()
+	e := expectSocketEvent(t, conn, EventMove, 1)
+	if e.Player != "b" || e.Column == nil || *e.Column != 1 {
+		t.Error("expected b to play column 1 got", e.Player, e.Column)
+	}
+
+	// Moves made while connected are pushed.
+	g.Move("c", 0)
+	e = expectSocketEvent(t, conn, EventMove, 2)
+	if e.Column == nil || *e.Column != 0 {
+		t.Error("expected column 0 got", e.Column)
+	}
+	g.Quit("a")
+	e = expectSocketEvent(t, conn, EventQuit, 3)
+	if e.Player != "a" || e.Column != nil {
+		t.Error("expected quit by a got", e.Player, e.Column)
+	}
+	g.Quit("c")
+	expectSocketEvent(t, conn, EventQuit, 4)
+	e = expectSocketEvent(t, conn, EventGameOver, 4)
+	if e.Winner != "b" || e.Draw {
+		t.Error("expected b to win got", e.Winner)
+	}
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Error("expected socket to close after game over got", err)
+	}
+
+	// Reconnecting after the game is over replays the end.
+	conn = dialGame(t, server, "socket/ws?from=4")
+	defer conn.Close()
+	expectSocketEvent(t, conn, EventQuit, 4)
+	expectSocketEvent(t, conn, EventGameOver, 4)
+
+	// Draws are reported as such.
+	g = CreateGame(4, 4, 4, "a", "b")
+	g.id = "socket-draw"
+	GAMES.Add(g)
+	conn = dialGame(t, server, "socket-draw/ws")
+	defer conn.Close()
+	mkDraw(g, "a", "b")
+	for i := 0; i < 16; i++ {
+		expectSocketEvent(t, conn, EventMove, i)
+	}
+	e = expectSocketEvent(t, conn, EventGameOver, 15)
+	if e.Winner != "" || !e.Draw {
+		t.Error("expected a draw got", e.Winner)
+	}
+}
+
+func Test_gameSocketHandlerErrors(t *testing.T) {
+	server := httptest.NewServer(configureRouter(*API_PREFIX))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/" + *API_PREFIX + "/dogs/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Error("expected 404 got", resp.StatusCode)
+	}
+
+	g := CreateGame(4, 4, 4, "a", "b")
+	g.id = "socket-errors"
+	GAMES.Add(g)
+	resp, err = http.Get(server.URL + "/" + *API_PREFIX + "/socket-errors/ws?from=-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Error("expected 400 got", resp.StatusCode)
+	}
+}